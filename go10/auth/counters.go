@@ -2,56 +2,112 @@ package auth
 
 import (
 	"fmt"
+	"sync"
+	"ripple/config"
 	"ripple/types"
 	"ripple/database"
 )
 
+// counterLocks serializes the checks of incoming counters per account, since a server listening on more than one
+// transport validates datagrams in more than one server loop. Accounts do not wait for each other. Locks are keyed
+// by the account directory, so accounts of server instances in one process are told apart.
+var counterLocks = struct {
+	mu    sync.Mutex
+	locks map[string]*accountLock
+}{locks: make(map[string]*accountLock)}
+
+// accountLock is the lock of one account, removed once nothing holds or waits for it
+type accountLock struct {
+	mu    sync.Mutex
+	users int
+}
+
+// lockAccountCounters locks the counters of an account, and returns the function that unlocks them
+func lockAccountCounters(cfg *config.Config, username string) func() {
+	accountDir := database.GetAccountDir(cfg, username)
+	counterLocks.mu.Lock()
+	lock, exists := counterLocks.locks[accountDir]
+	if !exists {
+		lock = &accountLock{}
+		counterLocks.locks[accountDir] = lock
+	}
+	lock.users++
+	counterLocks.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		counterLocks.mu.Lock()
+		if lock.users--; lock.users == 0 {
+			delete(counterLocks.locks, accountDir)
+		}
+		counterLocks.mu.Unlock()
+	}
+}
+
 // validateAndIncrementClientCounter checks if the datagram's counter is valid by comparing it to the last known counter for client connections.
 // If valid, it sets the counter to the value in the datagram to prevent replay attacks.
-func validateAndIncrementClientCounter(datagram *types.Datagram) error {
-	prevCounter, err := database.GetCounter(datagram)
+func validateAndIncrementClientCounter(cfg *config.Config, datagram *types.Datagram) error {
+	defer lockAccountCounters(cfg, datagram.Username)()
+
+	prevCounter, err := database.GetCounter(cfg, datagram)
 	if err != nil {
 		return fmt.Errorf("error retrieving counter: %v", err)
 	}
 	if datagram.Counter <= prevCounter {
-		return fmt.Errorf("replay detected or old datagram: Counter %d is not greater than the last seen counter %d", datagram.Counter, prevCounter)
+		return fmt.Errorf("%w or old datagram: Counter %d is not greater than the last seen counter %d", ErrReplayDetected, datagram.Counter, prevCounter)
 	}
-	if err := database.SetCounter(datagram); err != nil {
+	if err := database.SetCounter(cfg, datagram); err != nil {
 		return fmt.Errorf("failed to set counter: %v", err)
 	}
 	return nil
 }
 
-// validateAndIncrementServerCounter checks if the datagram's counter is valid by comparing it to the last known counter for server connections.
-// If valid, it sets the counter to the value in the datagram to prevent replay attacks.
-func validateAndIncrementServerCounter(datagram *types.Datagram) error {
-	prevCounter, err := database.GetCounterIn(datagram)
+// ReplayWindowSize is how far below the highest counter seen from a peer a datagram can arrive and still be accepted,
+// so datagrams that overtake each other on the way are not dropped.
+const ReplayWindowSize = 64
+
+// validateAndIncrementServerCounter checks the datagram's counter against a sliding window of the counters seen for server connections.
+// Counters above the highest one seen advance the window, counters within the window are accepted once, and older ones are rejected.
+func validateAndIncrementServerCounter(cfg *config.Config, datagram *types.Datagram) error {
+	defer lockAccountCounters(cfg, datagram.Username)()
+
+	prevCounter, err := database.GetCounterIn(cfg, datagram)
 	if err != nil {
 		return fmt.Errorf("error retrieving in-counter: %v", err)
 	}
-	if datagram.Counter <= prevCounter {
-		return fmt.Errorf("replay detected or old datagram: Counter %d is not greater than the last seen in-counter %d", datagram.Counter, prevCounter)
+	window, err := database.GetWindowIn(cfg, datagram, prevCounter)
+	if err != nil {
+		return fmt.Errorf("error retrieving in-window: %v", err)
+	}
+
+	if datagram.Counter > prevCounter {
+		// Slide the window up to the new highest counter, and mark it as seen
+		if shift := datagram.Counter - prevCounter; shift >= ReplayWindowSize {
+			window = 1
+		} else {
+			window = window<<shift | 1
+		}
+		if err := database.AdvanceCounterIn(cfg, datagram, window); err != nil {
+			return fmt.Errorf("failed to set in-counter: %v", err)
+		}
+		return nil
+	}
+
+	offset := prevCounter - datagram.Counter
+	if offset >= ReplayWindowSize {
+		return fmt.Errorf("old datagram: Counter %d is below the replay window of the last seen in-counter %d", datagram.Counter, prevCounter)
+	}
+	if window&(1<<offset) != 0 {
+		return fmt.Errorf("%w: Counter %d has already been seen", ErrReplayDetected, datagram.Counter)
 	}
-	if err := database.SetCounterIn(datagram); err != nil {
-		return fmt.Errorf("failed to set in-counter: %v", err)
+	if err := database.SetWindowIn(cfg, datagram, prevCounter, window|1<<offset); err != nil {
+		return fmt.Errorf("failed to set in-window: %v", err)
 	}
 	return nil
 }
 
-// GetAndIncrementCounterOut retrieves the current counter_out, increments it, and updates the database.
-// It returns the counter value before it was incremented.
-func GetAndIncrementCounterOut(username, peerServerAddress, peerUsername string) (uint32, error) {
-    // Retrieve the current value of counter_out from the database.
-    counterOut, err := database.GetCounterOut(username, peerServerAddress, peerUsername)
-    if err != nil {
-        return 0, err  // Return error if unable to fetch the counter.
-    }
-
-    // Increment the counter and update it in the database within the same function call.
-    if err := database.SetCounterOut(username, peerServerAddress, peerUsername, counterOut + 1); err != nil {
-        return 0, err  // Return error if unable to update the counter.
-    }
-
-    // Return the original counter value that was fetched.
-    return counterOut, nil
+// GetAndIncrementCounterOut returns the next outgoing counter to a peer account, reserved ahead of use in the database.
+func GetAndIncrementCounterOut(cfg *config.Config, username, peerServerAddress, peerUsername string) (uint32, error) {
+    return database.NextCounterOut(cfg, username, peerServerAddress, peerUsername)
 }
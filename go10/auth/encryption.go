@@ -0,0 +1,102 @@
+package auth
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "ripple/types"
+)
+
+var (
+    // Predefined errors for decryption failure
+    ErrUnknownKeyIdentifier = errors.New("unknown key identifier")
+    ErrDecryptionFailed     = errors.New("decryption failed")
+)
+
+// encryptionKeyLabel separates the encryption key from other uses of the shared secret
+const encryptionKeyLabel = "ripple datagram encryption"
+
+// deriveEncryptionKey derives the AES-256 key from a shared secret
+func deriveEncryptionKey(secret []byte) []byte {
+    mac := hmac.New(sha256.New, secret)
+    mac.Write([]byte(encryptionKeyLabel))
+    return mac.Sum(nil)
+}
+
+// getKeyIdentifier returns the identifier of an encryption key, carried in the clear so a mismatched key is
+// detected before decrypting.
+func getKeyIdentifier(key []byte) []byte {
+    hash := sha256.Sum256(key)
+    return hash[:types.KeyIdentifierSize]
+}
+
+// newGCM creates the AES-GCM cipher for a derived key
+func newGCM(key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+    }
+    return cipher.NewGCM(block)
+}
+
+// encryptDatagram seals the arguments and counter of a datagram with a key derived from the given secret, which for server
+// datagrams is the key for their direction, and authenticates the clear header with them.
+func encryptDatagram(dg *types.Datagram, secret []byte) ([]byte, error) {
+    serializedData, err := types.SerializeDatagram(dg)
+    if err != nil {
+        return nil, fmt.Errorf("failed to serialize datagram: %w", err)
+    }
+
+    key := deriveEncryptionKey(secret)
+    gcm, err := newGCM(key)
+    if err != nil {
+        return nil, err
+    }
+
+    // Format byte, clear header, key identifier and nonce
+    headerSize := types.HeaderSize(serializedData)
+    buf := append([]byte{types.EncryptedFormatAESGCM}, serializedData[:headerSize]...)
+    buf = append(buf, getKeyIdentifier(key)...)
+    nonce := make([]byte, types.NonceSize)
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, fmt.Errorf("failed to generate nonce: %w", err)
+    }
+    buf = append(buf, nonce...)
+
+    // Arguments and counter, sealed with everything before them as additional data
+    plaintext := serializedData[headerSize : headerSize+260]
+    return gcm.Seal(buf, nonce, plaintext, buf), nil
+}
+
+// decryptDatagram opens an encrypted datagram and fills in the arguments and counter of the datagram parsed from its clear header.
+func decryptDatagram(buf []byte, dg *types.Datagram, secret []byte) error {
+    if buf[0] != types.EncryptedFormatAESGCM {
+        return fmt.Errorf("unknown encrypted datagram format %d", buf[0])
+    }
+
+    key := deriveEncryptionKey(secret)
+    keyIdentifierStart := 1 + types.HeaderSize(buf)
+    nonceStart := keyIdentifierStart + types.KeyIdentifierSize
+    ciphertextStart := nonceStart + types.NonceSize
+    if !hmac.Equal(buf[keyIdentifierStart:nonceStart], getKeyIdentifier(key)) {
+        return ErrUnknownKeyIdentifier
+    }
+
+    gcm, err := newGCM(key)
+    if err != nil {
+        return err
+    }
+    plaintext, err := gcm.Open(nil, buf[nonceStart:ciphertextStart], buf[ciphertextStart:], buf[:ciphertextStart])
+    if err != nil {
+        return ErrDecryptionFailed
+    }
+
+    copy(dg.Arguments[:], plaintext[:256])
+    dg.Counter = binary.BigEndian.Uint32(plaintext[256:260])
+    return nil
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+    "ripple/types"
+)
+
+var (
+    encryptionTestSecret = []byte("0123456789abcdef0123456789abcdef")
+    encryptionTestOther  = []byte("fedcba9876543210fedcba9876543210")
+)
+
+// encryptTestDatagram encrypts a server datagram with arguments and a counter
+func encryptTestDatagram(t *testing.T) []byte {
+    t.Helper()
+    dg := &types.Datagram{Command: 0x81, Username: "alice", PeerUsername: "bob", PeerServerAddress: "beta.test", Counter: 7}
+    copy(dg.Arguments[:], "arguments")
+    buf, err := encryptDatagram(dg, encryptionTestSecret)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return buf
+}
+
+func TestDecryptDatagram(t *testing.T) {
+    dg := &types.Datagram{}
+    if err := decryptDatagram(encryptTestDatagram(t), dg, encryptionTestSecret); err != nil {
+        t.Fatal(err)
+    }
+    if dg.Counter != 7 || !bytes.HasPrefix(dg.Arguments[:], []byte("arguments")) {
+        t.Errorf("decrypted counter %d and arguments %q", dg.Counter, dg.Arguments[:9])
+    }
+}
+
+func TestDecryptDatagramRejectsWrongKey(t *testing.T) {
+    if err := decryptDatagram(encryptTestDatagram(t), &types.Datagram{}, encryptionTestOther); !errors.Is(err, ErrUnknownKeyIdentifier) {
+        t.Errorf("decrypting with another key returned %v, want %v", err, ErrUnknownKeyIdentifier)
+    }
+}
+
+func TestDecryptDatagramRejectsTampering(t *testing.T) {
+    headerEnd := 1 + types.HeaderSize(encryptTestDatagram(t))
+    tests := []struct {
+        name  string
+        index func(buf []byte) int
+    }{
+        {"username in the clear header", func(buf []byte) int { return 1 + 1 }},
+        {"peer server address in the clear header", func(buf []byte) int { return 1 + 65 }},
+        {"nonce", func(buf []byte) int { return headerEnd + types.KeyIdentifierSize }},
+        {"ciphertext", func(buf []byte) int { return headerEnd + types.KeyIdentifierSize + types.NonceSize }},
+        {"authentication tag", func(buf []byte) int { return len(buf) - 1 }},
+    }
+    for _, test := range tests {
+        buf := encryptTestDatagram(t)
+        buf[test.index(buf)] ^= 0x01
+        if err := decryptDatagram(buf, &types.Datagram{}, encryptionTestSecret); !errors.Is(err, ErrDecryptionFailed) {
+            t.Errorf("tampered %s: got %v, want %v", test.name, err, ErrDecryptionFailed)
+        }
+    }
+}
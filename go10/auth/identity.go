@@ -0,0 +1,69 @@
+package auth
+
+import (
+    "crypto/ed25519"
+    "errors"
+    "fmt"
+    "ripple/commands"
+    "ripple/config"
+    "ripple/database"
+    "ripple/types"
+)
+
+var (
+    // Predefined error for a datagram that should have been signed with the peer's identity key
+    ErrIdentitySignatureRequired = errors.New("identity signature required")
+)
+
+// identityCommands are the server commands signed with the server identity key when the server has one,
+// since they act as receipts a third party can check: acknowledging a trustline, and closing a peer.
+var identityCommands = map[byte]bool{
+    commands.ServerTrustlines_SetSyncOut: true,
+    commands.ServerTrustlines_ClosePeer:  true,
+}
+
+// usesIdentitySignature checks whether an outgoing datagram is signed with the server identity key
+func usesIdentitySignature(cfg *config.Config, dg *types.Datagram) bool {
+    return cfg.GetIdentityKey() != nil && identityCommands[dg.Command]
+}
+
+// signIdentityDatagram signs a datagram with the server identity key, in a datagram of IdentityDatagramSize,
+// or WideIdentityDatagramSize in the wide layout
+func signIdentityDatagram(cfg *config.Config, dg *types.Datagram) ([]byte, error) {
+    if len(dg.Payload) > 0 {
+        return nil, fmt.Errorf("command %d is signed with the identity key and cannot carry a payload", dg.Command)
+    }
+    dg.Arguments[types.SignatureVersionIndex] = types.SignatureVersionEd25519
+
+    serializedData, err := types.SerializeDatagram(dg)
+    if err != nil {
+        return nil, fmt.Errorf("failed to serialize datagram: %w", err)
+    }
+
+    data := serializedData[:len(serializedData)-32]
+    signature := ed25519.Sign(cfg.GetIdentityKey(), data)
+    copy(dg.Signature[:], signature)
+    return append(append([]byte{}, data...), signature...), nil
+}
+
+// verifyIdentityDatagram checks the Ed25519 signature of a server datagram against the public key stored for the peer.
+// A peer with a public key must sign the identity commands with it, so they cannot be downgraded to the shared secret.
+func verifyIdentityDatagram(cfg *config.Config, buf []byte, dg *types.Datagram) error {
+    publicKey, err := database.LoadPeerPublicKey(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+    if err != nil {
+        return fmt.Errorf("loading peer public key failed: %w", err)
+    }
+
+    if !types.IsIdentityDatagram(buf) {
+        if publicKey != nil && identityCommands[dg.Command] {
+            return ErrIdentitySignatureRequired
+        }
+        return nil
+    }
+
+    data := buf[:len(buf)-ed25519.SignatureSize]
+    if publicKey == nil || buf[types.SignatureVersionOffset(buf)] != types.SignatureVersionEd25519 || !ed25519.Verify(publicKey, data, buf[len(data):]) {
+        return ErrSignatureVerificationFailed
+    }
+    return nil
+}
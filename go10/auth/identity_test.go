@@ -0,0 +1,102 @@
+package auth
+
+import (
+    "crypto/ed25519"
+    "encoding/hex"
+    "errors"
+    "testing"
+    "ripple/commands"
+    "ripple/config"
+    "ripple/testutil"
+    "ripple/types"
+)
+
+var (
+    identityTestSeed  = []byte("identity seed of alpha.test 0123")
+    identityTestOther = []byte("identity seed of gamma.test 0123")
+)
+
+// newIdentityTestConfigs creates the configuration of alpha.test, with an identity key, and of beta.test, where bob has
+// alice at alpha.test as a peer, with the public key peerPublicKey stored for her unless it is nil
+func newIdentityTestConfigs(t *testing.T, peerPublicKey ed25519.PublicKey) (*config.Config, *config.Config) {
+    t.Helper()
+
+    alphaDir := testutil.NewDataDir(t, "alpha.test")
+    testutil.WriteFile(t, alphaDir, "identity_key.txt", hex.EncodeToString(identityTestSeed))
+
+    betaDir := testutil.NewDataDir(t, "beta.test")
+    peerDir := testutil.AddPeer(t, betaDir, "bob", "alpha.test", "alice", nil, 0)
+    if peerPublicKey != nil {
+        testutil.WriteFile(t, peerDir, "public_key.txt", hex.EncodeToString(peerPublicKey))
+    }
+    return testutil.LoadConfig(t, alphaDir), testutil.LoadConfig(t, betaDir)
+}
+
+// testIdentityDatagram returns a SetSyncOut as beta.test parses it, from alice at alpha.test to bob
+func testIdentityDatagram() *types.Datagram {
+    return &types.Datagram{Command: commands.ServerTrustlines_SetSyncOut, Username: "bob", PeerUsername: "alice", PeerServerAddress: "alpha.test", Counter: 1}
+}
+
+// signTestIdentityDatagram signs a SetSyncOut with the identity key of alpha.test
+func signTestIdentityDatagram(t *testing.T, alpha *config.Config) []byte {
+    t.Helper()
+    buf, err := signIdentityDatagram(alpha, testIdentityDatagram())
+    if err != nil {
+        t.Fatal(err)
+    }
+    return buf
+}
+
+func TestVerifyIdentityDatagram(t *testing.T) {
+    publicKey := ed25519.NewKeyFromSeed(identityTestSeed).Public().(ed25519.PublicKey)
+    alpha, beta := newIdentityTestConfigs(t, publicKey)
+    if err := verifyIdentityDatagram(beta, signTestIdentityDatagram(t, alpha), testIdentityDatagram()); err != nil {
+        t.Errorf("identity datagram from a peer with its public key stored rejected: %v", err)
+    }
+}
+
+func TestVerifyIdentityDatagramRejectsPeerWithoutPublicKey(t *testing.T) {
+    // Without a stored public key there is nothing to check the signature against, so it is not accepted on trust
+    alpha, beta := newIdentityTestConfigs(t, nil)
+    if err := verifyIdentityDatagram(beta, signTestIdentityDatagram(t, alpha), testIdentityDatagram()); !errors.Is(err, ErrSignatureVerificationFailed) {
+        t.Errorf("identity datagram from a peer without a public key: got %v, want %v", err, ErrSignatureVerificationFailed)
+    }
+}
+
+func TestVerifyIdentityDatagramRejectsOtherKey(t *testing.T) {
+    otherPublicKey := ed25519.NewKeyFromSeed(identityTestOther).Public().(ed25519.PublicKey)
+    alpha, beta := newIdentityTestConfigs(t, otherPublicKey)
+    if err := verifyIdentityDatagram(beta, signTestIdentityDatagram(t, alpha), testIdentityDatagram()); !errors.Is(err, ErrSignatureVerificationFailed) {
+        t.Errorf("identity datagram signed with another key: got %v, want %v", err, ErrSignatureVerificationFailed)
+    }
+}
+
+func TestVerifyIdentityDatagramRejectsTampering(t *testing.T) {
+    publicKey := ed25519.NewKeyFromSeed(identityTestSeed).Public().(ed25519.PublicKey)
+    alpha, beta := newIdentityTestConfigs(t, publicKey)
+
+    buf := signTestIdentityDatagram(t, alpha)
+    buf[types.SignatureVersionOffset(buf)] = types.SignatureVersionHMAC
+    if err := verifyIdentityDatagram(beta, buf, testIdentityDatagram()); !errors.Is(err, ErrSignatureVerificationFailed) {
+        t.Errorf("identity datagram with another signature version: got %v, want %v", err, ErrSignatureVerificationFailed)
+    }
+
+    buf = signTestIdentityDatagram(t, alpha)
+    buf[1] ^= 0x01
+    if err := verifyIdentityDatagram(beta, buf, testIdentityDatagram()); !errors.Is(err, ErrSignatureVerificationFailed) {
+        t.Errorf("identity datagram with a changed username: got %v, want %v", err, ErrSignatureVerificationFailed)
+    }
+}
+
+func TestVerifyIdentityDatagramRequiresIdentitySignature(t *testing.T) {
+    // Once the public key of a peer is stored, a receipt signed with the shared secret is a downgrade
+    publicKey := ed25519.NewKeyFromSeed(identityTestSeed).Public().(ed25519.PublicKey)
+    _, beta := newIdentityTestConfigs(t, publicKey)
+    buf, err := types.SerializeDatagram(testIdentityDatagram())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := verifyIdentityDatagram(beta, buf, testIdentityDatagram()); !errors.Is(err, ErrIdentitySignatureRequired) {
+        t.Errorf("receipt without an identity signature: got %v, want %v", err, ErrIdentitySignatureRequired)
+    }
+}
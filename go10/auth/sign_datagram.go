@@ -2,12 +2,58 @@ package auth
 
 import (
     "fmt"
+    "ripple/config"
+    "ripple/database"
     "ripple/types"
 )
 
+// SealDatagram encrypts a datagram if encryption is enabled and the peer has advertised that it accepts encrypted datagrams,
+// and otherwise signs it. Commands signed with the server identity key are never encrypted, so the signature can be checked by others,
+// and neither are messages with a payload, which are sent in fragments.
+func SealDatagram(cfg *config.Config, dg *types.Datagram, peerServerAddress string) ([]byte, error) {
+    if !cfg.GetEncryptionEnabled() || usesIdentitySignature(cfg, dg) || len(dg.Payload) > 0 {
+        return SignDatagram(cfg, dg, peerServerAddress)
+    }
+
+    peerEncryption, err := database.GetPeerEncryption(cfg, dg.PeerUsername, peerServerAddress, dg.Username)
+    if err != nil {
+        return nil, fmt.Errorf("failed to check peer encryption: %w", err)
+    }
+    if !peerEncryption {
+        return SignDatagram(cfg, dg, peerServerAddress)
+    }
+
+    secretKey, err := loadServerSecretKeyOut(cfg, dg, peerServerAddress)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load server secret key: %w", err)
+    }
+    setCapabilities(cfg, dg)
+    return encryptDatagram(dg, getSendKey(cfg, dg, peerServerAddress, secretKey))
+}
+
+// setCapabilities sets the capabilities this server advertises from its configuration. They are set from scratch,
+// since the arguments of a forwarded datagram are copied from one received from another server.
+func setCapabilities(cfg *config.Config, dg *types.Datagram) {
+    dg.Arguments[types.CapabilitiesIndex] = types.CapabilitySignatureHMAC | types.CapabilitySignatureDirectional
+    if cfg.GetEncryptionEnabled() {
+        dg.Arguments[types.CapabilitiesIndex] |= types.CapabilityEncryption
+    }
+}
+
 // SignDatagram creates a signed datagram by serializing it and adding a signature.
 // It requires the session to load the secret key for signature generation.
-func SignDatagram(dg *types.Datagram, peerServerAddress string) ([]byte, error) {
+func SignDatagram(cfg *config.Config, dg *types.Datagram, peerServerAddress string) ([]byte, error) {
+    // Set the capabilities and signature version, which are covered by the signature
+    setCapabilities(cfg, dg)
+    if usesIdentitySignature(cfg, dg) {
+        return signIdentityDatagram(cfg, dg)
+    }
+    version, err := getSignatureVersion(cfg, dg, peerServerAddress)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get peer signature version: %w", err)
+    }
+    dg.Arguments[types.SignatureVersionIndex] = version
+
     // Serialize the datagram without the signature field
     serializedData, err := types.SerializeDatagram(dg)
     if err != nil {
@@ -15,16 +61,23 @@ func SignDatagram(dg *types.Datagram, peerServerAddress string) ([]byte, error)
     }
 
     // Load the secret key for signature generation
-    secretKey, err := loadServerSecretKeyOut(dg, peerServerAddress)
+    secretKey, err := loadServerSecretKeyOut(cfg, dg, peerServerAddress)
     if err != nil {
         return nil, fmt.Errorf("failed to load server secret key: %w", err)
     }
 
-    // Generate signature for the serialized data
-    signature := generateSignature(serializedData[:357], secretKey)
+    // Generate signature for the serialized data, with the key for this direction unless signing with the legacy scheme
+    key := secretKey
+    if dg.Arguments[types.SignatureVersionIndex] == types.SignatureVersionDirectional {
+        key = getSendKey(cfg, dg, peerServerAddress, secretKey)
+    }
+    // The signature is the last 32 bytes, after the payload of a message
+    signatureOffset := len(serializedData) - 32
+    signature := generateSignature(serializedData, key)
 
     // Update the datagram's signature field with the generated signature
     copy(dg.Signature[:], []byte(signature)) // Ensure we copy the signature into the byte array
+    copy(serializedData[signatureOffset:], signature)
 
     // Return the serialized data including the signature
     return serializedData, nil
@@ -1,48 +1,146 @@
 package auth
 
 import (
+    "crypto/hmac"
     "crypto/sha256"
-    "bytes"
+    "ripple/config"
     "ripple/database"
     "ripple/types"
 )
 
-func loadClientSecretKey(dg *types.Datagram) ([]byte, error) {
-    return database.LoadSecretKey(dg.Username)
+func loadClientSecretKey(cfg *config.Config, dg *types.Datagram) ([]byte, error) {
+    return database.LoadSecretKey(cfg, dg.Username)
 }
 
-func loadServerSecretKey(dg *types.Datagram) ([]byte, error) {
-    return database.LoadPeerSecretKey(dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+func loadServerSecretKey(cfg *config.Config, dg *types.Datagram) ([]byte, error) {
+    return database.LoadPeerSecretKey(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername)
 }
 
-func loadServerSecretKeyOut(dg *types.Datagram, peerServerAddress string) ([]byte, error) {
-    return database.LoadPeerSecretKey(dg.PeerUsername, peerServerAddress, dg.Username)
+func loadServerSecretKeyOut(cfg *config.Config, dg *types.Datagram, peerServerAddress string) ([]byte, error) {
+    return database.LoadPeerSecretKey(cfg, dg.PeerUsername, peerServerAddress, dg.Username)
 }
 
-// verifySignature checks the integrity of the received buffer
-func verifySignature(buf []byte, key []byte) bool {
+// directionalKeyLabel separates the per-direction keys from other uses of the shared secret
+const directionalKeyLabel = "ripple directional key"
+
+// deriveDirectionalKey derives the key for datagrams from one account to another from their shared secret.
+// Each direction gets its own key, so a datagram reflected back at its sender does not verify. The server addresses
+// are canonical, so both servers derive the same key however each of them writes the addresses.
+func deriveDirectionalKey(secret []byte, senderUsername, senderServerAddress, receiverUsername, receiverServerAddress string) []byte {
+    mac := hmac.New(sha256.New, secret)
+    mac.Write([]byte(directionalKeyLabel))
+    mac.Write(types.PadStringTo32Bytes(senderUsername))
+    mac.Write(types.PadServerAddress(types.CanonicalServerAddress(senderServerAddress)))
+    mac.Write(types.PadStringTo32Bytes(receiverUsername))
+    mac.Write(types.PadServerAddress(types.CanonicalServerAddress(receiverServerAddress)))
+    return mac.Sum(nil)
+}
+
+// getSendKey derives the key for a datagram sent from this server to a peer server
+func getSendKey(cfg *config.Config, dg *types.Datagram, peerServerAddress string, secret []byte) []byte {
+    return deriveDirectionalKey(secret, dg.PeerUsername, cfg.GetServerAddress(), dg.Username, peerServerAddress)
+}
+
+// getReceiveKey derives the key for a datagram received by this server from a peer server
+func getReceiveKey(cfg *config.Config, dg *types.Datagram, secret []byte) []byte {
+    return deriveDirectionalKey(secret, dg.PeerUsername, dg.PeerServerAddress, dg.Username, cfg.GetServerAddress())
+}
+
+// getSignatureVersion returns the signature version used for an outgoing server datagram to a peer. Until the peer has
+// shown that it verifies HMAC, it is signed with the legacy scheme, so peers that have not upgraded keep accepting it,
+// and the directional keys are only used once the peer has shown that it derives the same keys. Once every server has
+// migrated, the hmac mode signs with the directional keys regardless, the only version servers accept in that mode.
+func getSignatureVersion(cfg *config.Config, dg *types.Datagram, peerServerAddress string) (byte, error) {
+    switch cfg.GetSignatureMode() {
+    case config.SignatureModeLegacy:
+        return types.SignatureVersionLegacy, nil
+    case config.SignatureModeHMAC:
+        return types.SignatureVersionDirectional, nil
+    }
+    return database.GetPeerSignatureVersion(cfg, dg.PeerUsername, peerServerAddress, dg.Username)
+}
+
+// recordPeerSignatureVersion records the highest signature version a peer has shown it verifies, by signing with it
+// or by advertising the capability. An encrypted datagram shows it as well, since it is encrypted with the directional
+// keys. It only writes when this increases.
+func recordPeerSignatureVersion(cfg *config.Config, buf []byte, dg *types.Datagram) error {
+    supported := byte(types.SignatureVersionLegacy)
+    if types.IsEncryptedDatagram(buf) {
+        supported = types.SignatureVersionDirectional
+    } else if !types.IsIdentityDatagram(buf) {
+        supported = buf[types.SignatureVersionOffset(buf)]
+    }
+    capabilities := dg.Arguments[types.CapabilitiesIndex]
+    if capabilities&types.CapabilitySignatureHMAC != 0 && supported < types.SignatureVersionHMAC {
+        supported = types.SignatureVersionHMAC
+    }
+    if capabilities&types.CapabilitySignatureDirectional != 0 {
+        supported = types.SignatureVersionDirectional
+    }
+
+    recorded, err := database.GetPeerSignatureVersion(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+    if err != nil {
+        return err
+    }
+    if supported <= recorded {
+        return nil
+    }
+    return database.SetPeerSignatureVersion(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername, supported)
+}
+
+// isSignatureVersionAccepted checks whether incoming datagrams signed with a version are accepted in the configured signature mode.
+// Server datagrams are signed with the per-direction keys once fully migrated, client datagrams with HMAC.
+func isSignatureVersionAccepted(cfg *config.Config, version byte, isServer bool) bool {
+    switch version {
+    case types.SignatureVersionLegacy:
+        return cfg.GetSignatureMode() != config.SignatureModeHMAC
+    case types.SignatureVersionHMAC:
+        return !isServer || cfg.GetSignatureMode() != config.SignatureModeHMAC
+    case types.SignatureVersionDirectional:
+        return isServer
+    default:
+        return false
+    }
+}
+
+// verifySignature checks the integrity of the received buffer, using the signature version it was signed with.
+// The directional key is only given for server datagrams, and is used by the versions that sign with it.
+func verifySignature(cfg *config.Config, buf []byte, secret []byte, directionalKey []byte) bool {
     // The signature is the last 32 bytes of the buffer
     data := buf[:len(buf)-32]
     signature := buf[len(buf)-32:]
 
-    // Concatenate data and key
-    preimage := append(data, key...)
+    version := buf[types.SignatureVersionOffset(buf)]
+    if !isSignatureVersionAccepted(cfg, version, directionalKey != nil) {
+        return false
+    }
 
-    // Compute the SHA-256 hash
-    hash := sha256.Sum256(preimage)
+    key := secret
+    if version == types.SignatureVersionDirectional {
+        key = directionalKey
+    }
 
-    // Compare the computed hash with the signature directly using bytes.Equal
-    return bytes.Equal(signature, hash[:])
+    // Compare the computed signature in constant time
+    return hmac.Equal(signature, computeSignature(data, key, version))
 }
 
-// generateSignature generates a SHA-256 hash for the given data using the provided key.
-func generateSignature(data []byte, secret []byte) []byte {
-    // Concatenate data and secret
-    preimage := append(data, secret...)
+// generateSignature generates the signature of a serialized datagram, everything but the last 32 bytes left for
+// the signature, using the provided key and the signature version already set in the datagram.
+func generateSignature(serializedData []byte, secret []byte) []byte {
+    data := serializedData[:len(serializedData)-32]
+    return computeSignature(data, secret, serializedData[types.SignatureVersionOffset(serializedData)])
+}
 
-    // Compute the SHA-256 hash
-    hash := sha256.Sum256(preimage)
+// computeSignature computes the signature of the data in a signature version
+func computeSignature(data []byte, key []byte, version byte) []byte {
+    if version == types.SignatureVersionLegacy {
+        // Concatenate data and key into a new slice, so the data is not appended to in place
+        preimage := append(append([]byte{}, data...), key...)
+        hash := sha256.Sum256(preimage)
+        return hash[:]
+    }
 
-    // Return the hash as a byte slice
-    return hash[:]
+    mac := hmac.New(sha256.New, key)
+    mac.Write(data)
+    return mac.Sum(nil)
 }
@@ -0,0 +1,112 @@
+package auth
+
+import (
+    "testing"
+    "ripple/config"
+    "ripple/database"
+    "ripple/testutil"
+    "ripple/types"
+)
+
+// signatureTestSecret is the secret shared by alice at alpha.test and alice at beta.test
+var signatureTestSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// newSignatureTestConfig creates the configuration of a server with an account alice, whose peer account is alice at
+// peerServerAddress, in a signature mode
+func newSignatureTestConfig(t *testing.T, serverAddress, peerServerAddress, signatureMode string) *config.Config {
+    t.Helper()
+    datadir := testutil.NewDataDir(t, serverAddress)
+    testutil.WriteFile(t, datadir, "signature_mode.txt", signatureMode)
+    testutil.AddPeer(t, datadir, "alice", peerServerAddress, "alice", signatureTestSecret, 0)
+    return testutil.LoadConfig(t, datadir)
+}
+
+// signFromAlpha signs a server datagram from alice at alpha.test to alice at beta.test
+func signFromAlpha(t *testing.T, alpha *config.Config) []byte {
+    t.Helper()
+    dg := &types.Datagram{Command: 0x81, Username: "alice", PeerUsername: "alice", PeerServerAddress: "alpha.test", Counter: 1}
+    buf, err := SignDatagram(alpha, dg, "beta.test")
+    if err != nil {
+        t.Fatal(err)
+    }
+    return buf
+}
+
+// verifyAt verifies a server datagram at a server as if it came from alice at peerServerAddress
+func verifyAt(cfg *config.Config, buf []byte, peerServerAddress string) error {
+    dg := types.DeserializeDatagram(buf)
+    dg.PeerServerAddress = peerServerAddress
+    return verifyDatagram(cfg, buf, dg, signatureTestSecret, getReceiveKey(cfg, dg, signatureTestSecret))
+}
+
+func TestDirectionalSignature(t *testing.T) {
+    alpha := newSignatureTestConfig(t, "alpha.test", "beta.test", config.SignatureModeHMAC)
+    beta := newSignatureTestConfig(t, "beta.test", "alpha.test", config.SignatureModeHMAC)
+
+    buf := signFromAlpha(t, alpha)
+    if version := buf[types.SignatureVersionOffset(buf)]; version != types.SignatureVersionDirectional {
+        t.Fatalf("signed with version %d, want %d", version, types.SignatureVersionDirectional)
+    }
+    if err := verifyAt(beta, buf, "alpha.test"); err != nil {
+        t.Errorf("datagram from alpha.test rejected at beta.test: %v", err)
+    }
+}
+
+func TestReflectedDatagramRejected(t *testing.T) {
+    // The usernames are the same on both sides, so only the direction tells a datagram from its reflection
+    alpha := newSignatureTestConfig(t, "alpha.test", "beta.test", config.SignatureModeHMAC)
+    buf := signFromAlpha(t, alpha)
+    if err := verifyAt(alpha, buf, "beta.test"); err == nil {
+        t.Errorf("datagram reflected back at alpha.test accepted")
+    }
+}
+
+func TestDowngradedSignatureVersionRejected(t *testing.T) {
+    // A server that has migrated only accepts server datagrams signed with the directional keys
+    alpha := newSignatureTestConfig(t, "alpha.test", "beta.test", config.SignatureModeMigrate)
+    beta := newSignatureTestConfig(t, "beta.test", "alpha.test", config.SignatureModeHMAC)
+    for _, version := range []byte{types.SignatureVersionLegacy, types.SignatureVersionHMAC} {
+        if err := database.SetPeerSignatureVersion(alpha, "alice", "beta.test", "alice", version); err != nil {
+            t.Fatal(err)
+        }
+        buf := signFromAlpha(t, alpha)
+        if got := buf[types.SignatureVersionOffset(buf)]; got != version {
+            t.Fatalf("signed with version %d, want %d", got, version)
+        }
+        if err := verifyAt(beta, buf, "alpha.test"); err == nil {
+            t.Errorf("datagram signed with version %d accepted in the hmac mode", version)
+        }
+    }
+
+    // The version is covered by the signature, so it cannot be lowered in transit to one a migrating server accepts
+    alpha = newSignatureTestConfig(t, "alpha.test", "beta.test", config.SignatureModeHMAC)
+    beta = newSignatureTestConfig(t, "beta.test", "alpha.test", config.SignatureModeMigrate)
+    buf := signFromAlpha(t, alpha)
+    buf[types.SignatureVersionOffset(buf)] = types.SignatureVersionHMAC
+    if err := verifyAt(beta, buf, "alpha.test"); err == nil {
+        t.Errorf("datagram with its signature version lowered accepted")
+    }
+}
+
+func TestRecordedSignatureVersionNotLowered(t *testing.T) {
+    beta := newSignatureTestConfig(t, "beta.test", "alpha.test", config.SignatureModeMigrate)
+    if err := database.SetPeerSignatureVersion(beta, "alice", "alpha.test", "alice", types.SignatureVersionDirectional); err != nil {
+        t.Fatal(err)
+    }
+
+    // A legacy datagram without capabilities does not make beta.test sign with an older version again
+    alpha := newSignatureTestConfig(t, "alpha.test", "beta.test", config.SignatureModeLegacy)
+    buf := signFromAlpha(t, alpha)
+    dg := types.DeserializeDatagram(buf)
+    dg.Arguments[types.CapabilitiesIndex] = 0
+    if err := recordPeerSignatureVersion(beta, buf, dg); err != nil {
+        t.Fatal(err)
+    }
+    version, err := database.GetPeerSignatureVersion(beta, "alice", "alpha.test", "alice")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if version != types.SignatureVersionDirectional {
+        t.Errorf("recorded signature version lowered to %d", version)
+    }
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	"errors"
 	"fmt"
+	"ripple/config"
 	"ripple/types"
 	"ripple/database"
 )
@@ -10,12 +11,18 @@ import (
 var (
 	// Predefined error for signature verification failure
 	ErrSignatureVerificationFailed = errors.New("signature verification failed")
+
+	// Predefined error for a counter that has already been seen, a datagram being replayed
+	ErrReplayDetected = errors.New("replay detected")
+
+	// Predefined error for datagrams to or from a suspended account
+	ErrAccountSuspended = errors.New("account suspended")
 )
 
 // ValidatePeerExists checks for the existence of user and peer directories
 // It returns an error message string (empty if successful) and an error object for detailed information if an error occurs.
-func ValidatePeerExists(dg *types.Datagram) (string, error) {
-	exists, err := database.CheckPeerExists(dg)
+func ValidatePeerExists(cfg *config.Config, dg *types.Datagram) (string, error) {
+	exists, err := database.CheckPeerExists(cfg, dg)
 	if err != nil {
 		return "Error checking peer existence", fmt.Errorf("error checking peer existence for server '%s' and user '%s': %v", dg.PeerServerAddress, dg.PeerUsername, err)
 	} else if !exists {
@@ -25,19 +32,58 @@ func ValidatePeerExists(dg *types.Datagram) (string, error) {
 	return "", nil // No error, directories exist
 }
 
+// verifyDatagram decrypts an encrypted datagram, which also authenticates it, or verifies the signature of a plaintext datagram.
+// Server datagrams are given the key derived for their direction, client datagrams only the account secret key.
+func verifyDatagram(cfg *config.Config, buf []byte, dg *types.Datagram, secretKey []byte, directionalKey []byte) error {
+	if types.IsEncryptedDatagram(buf) {
+		key := secretKey
+		if directionalKey != nil {
+			key = directionalKey
+		}
+		if err := decryptDatagram(buf, dg, key); err != nil {
+			return fmt.Errorf("%w: %v", ErrSignatureVerificationFailed, err)
+		}
+		return nil
+	}
+
+	if !verifySignature(cfg, buf, secretKey, directionalKey) {
+		return ErrSignatureVerificationFailed
+	}
+	return nil
+}
+
+// recordPeerEncryption records whether a peer accepts encrypted datagrams, which it shows by sending one
+// or by advertising the capability in a plaintext datagram. It only writes when this changes.
+func recordPeerEncryption(cfg *config.Config, buf []byte, dg *types.Datagram) error {
+	supported := types.IsEncryptedDatagram(buf) || dg.Arguments[types.CapabilitiesIndex]&types.CapabilityEncryption != 0
+	recorded, err := database.GetPeerEncryption(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+	if err != nil {
+		return err
+	}
+	if supported == recorded {
+		return nil
+	}
+	return database.SetPeerEncryption(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername, supported)
+}
+
 // validateClientDatagram validates the client datagram and checks the counter
-func validateClientDatagram(buf []byte, dg *types.Datagram) error {
-	secretKey, err := loadClientSecretKey(dg)
+func validateClientDatagram(cfg *config.Config, buf []byte, dg *types.Datagram) error {
+	// Client datagrams are only signed with the account secret key
+	if types.IsIdentityDatagram(buf) {
+		return ErrSignatureVerificationFailed
+	}
+
+	secretKey, err := loadClientSecretKey(cfg, dg)
 	if err != nil {
 		return fmt.Errorf("loading client secret key failed: %w", err)
 	}
 
-	if !verifySignature(buf, secretKey) {
-		return ErrSignatureVerificationFailed
+	if err := verifyDatagram(cfg, buf, dg, secretKey, nil); err != nil {
+		return err
 	}
 
 	// Validate the counter
-	if err := validateAndIncrementClientCounter(dg); err != nil {
+	if err := validateAndIncrementClientCounter(cfg, dg); err != nil {
 		return fmt.Errorf("counter validation failed: %w", err)
 	}
 
@@ -45,29 +91,53 @@ func validateClientDatagram(buf []byte, dg *types.Datagram) error {
 }
 
 // validateServerDatagram validates the server datagram and checks the counter
-func validateServerDatagram(buf []byte, dg *types.Datagram) error {
-	secretKey, err := loadServerSecretKey(dg)
-	if err != nil {
-		return fmt.Errorf("loading server secret key failed: %w", err)
+func validateServerDatagram(cfg *config.Config, buf []byte, dg *types.Datagram) error {
+	if err := verifyIdentityDatagram(cfg, buf, dg); err != nil {
+		return err
 	}
 
-	if !verifySignature(buf, secretKey) {
-		return ErrSignatureVerificationFailed
+	// Datagrams signed with the identity key do not use the shared secret
+	if !types.IsIdentityDatagram(buf) {
+		secretKey, err := loadServerSecretKey(cfg, dg)
+		if err != nil {
+			return fmt.Errorf("loading server secret key failed: %w", err)
+		}
+
+		if err := verifyDatagram(cfg, buf, dg, secretKey, getReceiveKey(cfg, dg, secretKey)); err != nil {
+			return err
+		}
 	}
 
 	// Validate the counter
-	if err := validateAndIncrementServerCounter(dg); err != nil {
+	if err := validateAndIncrementServerCounter(cfg, dg); err != nil {
 		return fmt.Errorf("counter validation failed: %w", err)
 	}
 
+	// Keep track of whether the peer accepts encrypted datagrams
+	if err := recordPeerEncryption(cfg, buf, dg); err != nil {
+		return fmt.Errorf("recording peer encryption failed: %w", err)
+	}
+
+	// Keep track of the signature versions the peer verifies
+	if err := recordPeerSignatureVersion(cfg, buf, dg); err != nil {
+		return fmt.Errorf("recording peer signature version failed: %w", err)
+	}
+
 	return nil
 }
 
 // ValidateDatagram validates a datagram based on whether it's for a client or server session.
-func ValidateDatagram(buf []byte, dg *types.Datagram) error {
+// Datagrams for suspended accounts are rejected before any key is loaded.
+func ValidateDatagram(cfg *config.Config, buf []byte, dg *types.Datagram) error {
+	if suspended, err := database.IsAccountSuspended(cfg, dg.Username); err != nil {
+		return fmt.Errorf("checking account suspension failed: %w", err)
+	} else if suspended {
+		return ErrAccountSuspended
+	}
+
 	if dg.Command&0x80 == 0 { // Client session if MSB is 0
-		return validateClientDatagram(buf, dg)
+		return validateClientDatagram(cfg, buf, dg)
 	} else { // Server session if MSB is 1
-		return validateServerDatagram(buf, dg)
+		return validateServerDatagram(cfg, buf, dg)
 	}
 }
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"ripple/config"
+	"ripple/testutil"
+	"ripple/types"
+)
+
+// newWindowTestConfig creates a data directory with one peer account whose counter_in is 0
+func newWindowTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	datadir := testutil.NewDataDir(t, "alpha.test")
+	testutil.AddPeer(t, datadir, "alice", "beta.test", "bob", nil, 0)
+	return testutil.LoadConfig(t, datadir)
+}
+
+// checkCounter validates a server datagram with a counter, and checks whether it is accepted
+func checkCounter(t *testing.T, cfg *config.Config, counter uint32, accepted bool) {
+	t.Helper()
+
+	dg := &types.Datagram{Username: "alice", PeerServerAddress: "beta.test", PeerUsername: "bob", Counter: counter}
+	err := validateAndIncrementServerCounter(cfg, dg)
+	if accepted && err != nil {
+		t.Errorf("counter %d rejected: %v", counter, err)
+	} else if !accepted && err == nil {
+		t.Errorf("counter %d accepted", counter)
+	}
+}
+
+func TestReplayWindowAcceptsOutOfOrder(t *testing.T) {
+	cfg := newWindowTestConfig(t)
+
+	checkCounter(t, cfg, 1, true)
+	checkCounter(t, cfg, 5, true)
+	checkCounter(t, cfg, 3, true)
+	checkCounter(t, cfg, 2, true)
+	checkCounter(t, cfg, 4, true)
+	checkCounter(t, cfg, 6, true)
+}
+
+func TestReplayWindowRejectsReplays(t *testing.T) {
+	cfg := newWindowTestConfig(t)
+
+	checkCounter(t, cfg, 10, true)
+	checkCounter(t, cfg, 8, true)
+
+	for _, counter := range []uint32{10, 8} {
+		dg := &types.Datagram{Username: "alice", PeerServerAddress: "beta.test", PeerUsername: "bob", Counter: counter}
+		if err := validateAndIncrementServerCounter(cfg, dg); !errors.Is(err, ErrReplayDetected) {
+			t.Errorf("replayed counter %d: got %v, want %v", counter, err, ErrReplayDetected)
+		}
+	}
+
+	// Counters below the highest one that have not been seen are still accepted once
+	checkCounter(t, cfg, 9, true)
+	checkCounter(t, cfg, 9, false)
+}
+
+func TestReplayWindowRejectsOldCounters(t *testing.T) {
+	cfg := newWindowTestConfig(t)
+
+	checkCounter(t, cfg, 100, true)
+	checkCounter(t, cfg, 100-ReplayWindowSize+1, true)
+	checkCounter(t, cfg, 100-ReplayWindowSize, false)
+
+	// Sliding the window past everything seen forgets it, and counters below the new window are rejected
+	checkCounter(t, cfg, 100+ReplayWindowSize, true)
+	checkCounter(t, cfg, 100+1, true)
+	checkCounter(t, cfg, 100, false)
+}
+
+func TestReplayWindowSurvivesRestart(t *testing.T) {
+	cfg := newWindowTestConfig(t)
+
+	checkCounter(t, cfg, 20, true)
+	checkCounter(t, cfg, 18, true)
+
+	// A new configuration of the same data directory reads the window back from disk
+	restarted, err := config.LoadConfig(cfg.GetDataDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCounter(t, restarted, 18, false)
+	checkCounter(t, restarted, 19, true)
+	checkCounter(t, restarted, 21, true)
+}
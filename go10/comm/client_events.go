@@ -0,0 +1,71 @@
+package comm
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+	"ripple/config"
+)
+
+// subscription is the address a client waits for pushed events at, the source address of its subscribe
+// command, which is reachable through its NAT for as long as the client keeps renewing the subscription
+type subscription struct {
+	addr    net.Addr
+	expires time.Time
+}
+
+// subscriptions holds the client subscribed to pushed events for each account
+type subscriptions struct {
+	byUsername map[string]subscription
+	mu         sync.Mutex
+}
+
+// Subscribe registers the address a client of an account receives pushed events at, until SubscriptionTimeout has passed
+func (e *Endpoint) Subscribe(username string, addr net.Addr) {
+	e.subscriptions.mu.Lock()
+	e.subscriptions.byUsername[username] = subscription{addr: addr, expires: time.Now().Add(config.SubscriptionTimeout)}
+	e.subscriptions.mu.Unlock()
+}
+
+// Unsubscribe stops pushing events to the client of an account
+func (e *Endpoint) Unsubscribe(username string) {
+	e.subscriptions.mu.Lock()
+	delete(e.subscriptions.byUsername, username)
+	e.subscriptions.mu.Unlock()
+}
+
+// RefreshSubscription moves the subscription of an account to the address its client last sent from,
+// so events follow the client when its NAT maps it to a new port.
+func (e *Endpoint) RefreshSubscription(username string, addr net.Addr) {
+	e.subscriptions.mu.Lock()
+	defer e.subscriptions.mu.Unlock()
+
+	if sub, exists := e.subscriptions.byUsername[username]; exists && time.Now().Before(sub.expires) {
+		sub.addr = addr
+		e.subscriptions.byUsername[username] = sub
+	}
+}
+
+// PushEvent sends an event to the client subscribed for an account, from the listening socket, and returns
+// false if no client is subscribed. The event is sent in the background, retransmitted until acknowledged.
+func (e *Endpoint) PushEvent(username string, event []byte) bool {
+	e.subscriptions.mu.Lock()
+	sub, exists := e.subscriptions.byUsername[username]
+	if exists && !time.Now().Before(sub.expires) {
+		delete(e.subscriptions.byUsername, username)
+		exists = false
+	}
+	e.subscriptions.mu.Unlock()
+	if !exists {
+		return false
+	}
+
+	message := append([]byte{2}, event...) // Combine event indicator and event
+	go func() {
+		if err := e.SendWithAddress(sub.addr, message, LowImportance); err != nil {
+			log.Printf("Failed to push event to user %s at %s: %v", username, sub.addr.String(), err)
+		}
+	}()
+	return true
+}
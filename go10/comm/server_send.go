@@ -2,20 +2,21 @@ package comm
 
 import (
     "fmt"
+    "time"
     "ripple/types"
     "ripple/auth"
 )
 
-// signAndSendDatagram creates a signed datagram and sends it over the network with custom priority
-func signAndSendDatagram(dg *types.Datagram, peerServerAddress string, maxRetries int) error {
-    // Create the signed datagram
-    serializedData, err := auth.SignDatagram(dg, peerServerAddress)
+// signAndSendDatagram creates a signed, or if negotiated with the peer encrypted, datagram and sends it over the network with custom priority
+func (e *Endpoint) signAndSendDatagram(dg *types.Datagram, peerServerAddress string, budget time.Duration) error {
+    // Create the signed or encrypted datagram
+    serializedData, err := auth.SealDatagram(e.config, dg, peerServerAddress)
     if err != nil {
         return fmt.Errorf("failed to create signed datagram: %w", err)
     }
     
     // Send the signed datagram over the network
-    if err := SendWithResolvedAddress(peerServerAddress, serializedData, maxRetries); err != nil {
+    if err := e.SendWithResolvedAddress(peerServerAddress, serializedData, budget); err != nil {
         return fmt.Errorf("failed to send datagram: %w", err)
     }
 
@@ -23,11 +24,11 @@ func signAndSendDatagram(dg *types.Datagram, peerServerAddress string, maxRetrie
 }
 
 // SignAndSendDatagram creates a signed datagram and sends it over the network with low priority.
-func SignAndSendDatagram(dg *types.Datagram, peerServerAddress string) error {
-    return signAndSendDatagram(dg, peerServerAddress, LowImportance)
+func (e *Endpoint) SignAndSendDatagram(dg *types.Datagram, peerServerAddress string) error {
+    return e.signAndSendDatagram(dg, peerServerAddress, LowImportance)
 }
 
 // SignAndSendPriorityDatagram creates a signed datagram and sends it over the network with high priority.
-func SignAndSendPriorityDatagram(dg *types.Datagram, peerServerAddress string) error {
-    return signAndSendDatagram(dg, peerServerAddress, HighImportance)
+func (e *Endpoint) SignAndSendPriorityDatagram(dg *types.Datagram, peerServerAddress string) error {
+    return e.signAndSendDatagram(dg, peerServerAddress, HighImportance)
 }
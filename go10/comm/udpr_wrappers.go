@@ -3,56 +3,90 @@ package comm
 import (
 	"fmt"
 	"net"
+	"strconv"
+	"time"
 	"ripple/config"
+	"ripple/transport"
+	"ripple/types"
 	"ripple/udpr"
 )
 
-// Retry levels based on importance
+// Retry budgets based on importance, how long retransmissions continue before a send fails
 const (
-	LowImportance    = 5  // 5 retries for standard messages
-	HighImportance   = 12 // 12 retries for priority messages
+	LowImportance    = 45 * time.Second  // Standard messages
+	HighImportance   = 150 * time.Second // Priority messages
 )
 
-// SendWithAddress sends data to a specified UDP address with retry logic.
-// It handles the creation and closure of the UDP connection internally.
-func SendWithAddress(addr *net.UDPAddr, data []byte, maxRetries int) error {
-	// Create a UDP connection with an ephemeral local port
-	conn, err := net.DialUDP("udp", nil, addr)
-	if err != nil {
-		return fmt.Errorf("failed to create UDP connection: %w", err)
+// Endpoint is the network side of a server instance: the transports it listens on, which everything is sent from,
+// by the network of their addresses, and the clients subscribed to pushed events. The first transport is the default,
+// used for peer servers whose transport the server does not listen on.
+type Endpoint struct {
+	config           *config.Config
+	transports       map[string]transport.Transport
+	defaultTransport transport.Transport
+	subscriptions    subscriptions
+}
+
+// NewEndpoint initializes the Endpoint of a server instance, without transports
+func NewEndpoint(cfg *config.Config) *Endpoint {
+	return &Endpoint{
+		config:        cfg,
+		transports:    make(map[string]transport.Transport),
+		subscriptions: subscriptions{byUsername: make(map[string]subscription)},
 	}
-	defer conn.Close()
+}
 
-	// Use the udpr.SendWithRetry to send the data
-	if err := udpr.SendWithRetry(conn, addr, data, maxRetries); err != nil {
+// AddTransport adds a transport that datagrams and responses are sent from
+func (e *Endpoint) AddTransport(t transport.Transport) {
+	if e.defaultTransport == nil {
+		e.defaultTransport = t
+	}
+	e.transports[t.Network()] = t
+}
+
+// getTransport returns the transport of a network, or the default transport
+func (e *Endpoint) getTransport(network string) transport.Transport {
+	if t, exists := e.transports[network]; exists {
+		return t
+	}
+	return e.defaultTransport
+}
+
+// SendWithAddress sends data to a specified address with retry logic, from the listening socket of the
+// transport the address is on, so responses go back the way a request came. A message with a payload is sent in fragments.
+func (e *Endpoint) SendWithAddress(addr net.Addr, data []byte, budget time.Duration) error {
+	serverTransport := e.getTransport(addr.Network())
+	send := func(packet []byte, budget time.Duration) error {
+		return serverTransport.SendReliable(addr, packet, budget)
+	}
+
+	if types.IsMessage(data) {
+		if err := udpr.SendFragmented(send, data, budget); err != nil {
+			return fmt.Errorf("error sending message: %w", err)
+		}
+		return nil
+	}
+
+	if err := send(data, budget); err != nil {
 		return fmt.Errorf("error sending data: %w", err)
 	}
 
 	return nil
 }
 
-// SendWithResolvedAddressAndConn resolves the address, creates a new UDP connection, and sends data with retries.
-func SendWithResolvedAddress(address string, data []byte, maxRetries int) error {
-	// Resolve the destination address to a UDP address
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", address, config.Port))
+// SendWithResolvedAddress resolves the address and sends data with retries, on the transport configured for
+// the server. A server address without a port is reached at the default port.
+func (e *Endpoint) SendWithResolvedAddress(address string, data []byte, budget time.Duration) error {
+	host, port, err := types.SplitServerAddress(address, config.Port)
 	if err != nil {
-		return fmt.Errorf("failed to resolve address '%s:%d': %w", address, config.Port, err)
+		return err
 	}
-	// Call SendWithAddress function with the resolved address
-	return SendWithAddress(addr, data, maxRetries)
-}
 
-// // Default Send with standard importance (5 retries)
-// func Send(destinationAddr string, data []byte) error {
-// 	return SendWithResolvedAddress(destinationAddr, data, LowImportance)
-// }
-
-// // Send with priority importance (12 retries)
-// func SendPriority(destinationAddr string, data []byte) error {
-// 	return SendWithResolvedAddress(destinationAddr, data, HighImportance)
-// }
-
-// Wrapper for udpr.SendAck
-func SendAck(conn *net.UDPConn, addr *net.UDPAddr, idBytes []byte) error {
-	return udpr.SendAck(conn, addr, idBytes)
+	// Resolve the destination address on the transport
+	addr, err := e.getTransport(e.config.GetPeerTransport(address)).Resolve(net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	// Call SendWithAddress function with the resolved address
+	return e.SendWithAddress(addr, data, budget)
 }
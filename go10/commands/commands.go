@@ -9,6 +9,9 @@ const (
     ClientPayments_NewPaymentOut       = 5
     ClientPayments_NewPaymentIn        = 6
     ClientPayments_GetPayment          = 7
+    ClientTrustlines_ClosePeer         = 8
+    ClientTrustlines_ListPeers         = 9
+    ClientEvents_Subscribe             = 10
 
     ServerTrustlines_SetTrustline      = 127
     ServerTrustlines_GetTrustline      = 128
@@ -17,4 +20,5 @@ const (
     ServerPayments_FindPathOut         = 131
     ServerPayments_FindPathIn          = 132
     ServerPayments_PathRecurse         = 133
+    ServerTrustlines_ClosePeer         = 134
 )
@@ -1,16 +1,21 @@
 package config
 
 import (
+    "crypto/ed25519"
+    "encoding/hex"
     "fmt"
     "log"
     "os"
     "io/ioutil"
     "path/filepath"
+    "strconv"
+    "strings"
     "time"
+    "ripple/types"
 )
 
 const (
-    Port = 2012
+    Port = types.DefaultPort
 )
 
 // PathFindingTimeout is a global constant that defines the timeout duration for pathfinding operations
@@ -19,33 +24,250 @@ const PathFindingTimeout = 5 * time.Minute
 // CommitTimeout is a global constant that defines the timeout duration for commits during payment
 const CommitTimeout = 10 * time.Minute
 
-var datadir = filepath.Join(os.Getenv("HOME"), "ripple")
-var serverAddress string
+// OutboxMaxAge is a global constant that defines how long a queued server-to-server datagram is retried before it is dropped
+const OutboxMaxAge = 24 * time.Hour
+
+// SubscriptionTimeout is a global constant that defines how long a client subscription to pushed events lasts unless renewed
+const SubscriptionTimeout = 2 * time.Minute
+
+// ExpiryCheckInterval is a global constant that defines how often expired trustlines are looked for
+const ExpiryCheckInterval = 1 * time.Minute
+
+// Config is the configuration of one server instance, loaded from its data directory. Several instances, each
+// with a data directory of its own, can run in one process.
+type Config struct {
+    datadir           string
+    serverAddress     string
+    currencyScales    map[string]uint8
+    signatureMode     string
+    encryptionEnabled bool
+    identityKey       ed25519.PrivateKey
+    transportMode     string
+    peerTransports    map[string]string
+}
+
+// DefaultDataDir returns the data directory of a server started from the command line
+func DefaultDataDir() string {
+    return filepath.Join(os.Getenv("HOME"), "ripple")
+}
+
+// Transport modes, which transports the server listens on
+const (
+    TransportModeUDP  = "udp"  // Listen on UDP only
+    TransportModeTCP  = "tcp"  // Listen on TCP only, for networks that drop UDP
+    TransportModeBoth = "both" // Listen on both
+)
+
+// Signature modes, for rolling out HMAC signatures across servers and clients that still use the legacy scheme
+const (
+    SignatureModeLegacy  = "legacy"  // Sign with the legacy scheme, accept both
+    SignatureModeMigrate = "migrate" // Sign with HMAC, accept both
+    SignatureModeHMAC    = "hmac"    // Sign with HMAC, accept only HMAC
+)
 
 // GetServerAddress returns the server address as a string
-func GetServerAddress() string {
-    return serverAddress
+func (c *Config) GetServerAddress() string {
+    return c.serverAddress
 }
 
 // GetDataDir returns the datadir as a string
-func GetDataDir() string {
-    return datadir
+func (c *Config) GetDataDir() string {
+    return c.datadir
 }
 
 // loadServerAddress reads the server address from the configuration file.
-func loadServerAddress() error {
-    addressPath := filepath.Join(datadir, "server_address.txt")
+func (c *Config) loadServerAddress() error {
+    addressPath := filepath.Join(c.datadir, "server_address.txt")
     address, err := ioutil.ReadFile(addressPath)
     if err != nil {
         return fmt.Errorf("error loading server address from %s: %w", addressPath, err)
     }
-    serverAddress = string(address)
-    log.Printf("Loaded server address: %s", serverAddress) // Log that the address was loaded
+    c.serverAddress = string(address)
+    log.Printf("Loaded server address: %s", c.serverAddress) // Log that the address was loaded
+    return nil
+}
+
+// GetCurrencyScale returns the number of decimal places of a base unit of a currency, 0 if it is not configured
+func (c *Config) GetCurrencyScale(currency string) uint8 {
+    return c.currencyScales[currency]
+}
+
+// IsCurrencyConfigured checks whether a currency is listed in the currency configuration file
+func (c *Config) IsCurrencyConfigured(currency string) bool {
+    _, exists := c.currencyScales[currency]
+    return exists
+}
+
+// loadCurrencyScales reads the optional currency configuration file, with one currency code and scale per line.
+func (c *Config) loadCurrencyScales() error {
+    scalesPath := filepath.Join(c.datadir, "currencies.txt")
+    data, err := ioutil.ReadFile(scalesPath)
+    if os.IsNotExist(err) {
+        return nil
+    } else if err != nil {
+        return fmt.Errorf("error loading currency scales from %s: %w", scalesPath, err)
+    }
+
+    for _, line := range strings.Split(string(data), "\n") {
+        fields := strings.Fields(line)
+        if len(fields) == 0 {
+            continue
+        }
+        if len(fields) != 2 {
+            return fmt.Errorf("invalid line in %s: %q", scalesPath, line)
+        }
+        scale, err := strconv.ParseUint(fields[1], 10, 8)
+        if err != nil {
+            return fmt.Errorf("invalid scale for currency %s in %s: %w", fields[0], scalesPath, err)
+        }
+        c.currencyScales[fields[0]] = uint8(scale)
+    }
+    log.Printf("Loaded scales for %d currencies", len(c.currencyScales))
+    return nil
+}
+
+// GetSignatureMode returns the signature mode
+func (c *Config) GetSignatureMode() string {
+    return c.signatureMode
+}
+
+// loadSignatureMode reads the optional signature mode file, defaulting to SignatureModeMigrate.
+func (c *Config) loadSignatureMode() error {
+    modePath := filepath.Join(c.datadir, "signature_mode.txt")
+    data, err := ioutil.ReadFile(modePath)
+    if os.IsNotExist(err) {
+        return nil
+    } else if err != nil {
+        return fmt.Errorf("error loading signature mode from %s: %w", modePath, err)
+    }
+
+    mode := strings.TrimSpace(string(data))
+    switch mode {
+    case SignatureModeLegacy, SignatureModeMigrate, SignatureModeHMAC:
+        c.signatureMode = mode
+    default:
+        return fmt.Errorf("invalid signature mode in %s: %q", modePath, mode)
+    }
+    log.Printf("Loaded signature mode: %s", c.signatureMode)
+    return nil
+}
+
+// GetEncryptionEnabled returns whether datagrams to peers that support it are encrypted
+func (c *Config) GetEncryptionEnabled() bool {
+    return c.encryptionEnabled
+}
+
+// loadEncryptionMode reads the optional encryption mode file, "on" or "off", defaulting to off.
+func (c *Config) loadEncryptionMode() error {
+    modePath := filepath.Join(c.datadir, "encryption_mode.txt")
+    data, err := ioutil.ReadFile(modePath)
+    if os.IsNotExist(err) {
+        return nil
+    } else if err != nil {
+        return fmt.Errorf("error loading encryption mode from %s: %w", modePath, err)
+    }
+
+    switch mode := strings.TrimSpace(string(data)); mode {
+    case "on":
+        c.encryptionEnabled = true
+    case "off":
+        c.encryptionEnabled = false
+    default:
+        return fmt.Errorf("invalid encryption mode in %s: %q", modePath, mode)
+    }
+    log.Printf("Loaded encryption mode, enabled: %t", c.encryptionEnabled)
+    return nil
+}
+
+// GetIdentityKey returns the server identity key, nil if the server has none
+func (c *Config) GetIdentityKey() ed25519.PrivateKey {
+    return c.identityKey
+}
+
+// loadIdentityKey reads the optional server identity key file, holding a hex encoded Ed25519 seed.
+func (c *Config) loadIdentityKey() error {
+    keyPath := filepath.Join(c.datadir, "identity_key.txt")
+    data, err := ioutil.ReadFile(keyPath)
+    if os.IsNotExist(err) {
+        return nil
+    } else if err != nil {
+        return fmt.Errorf("error loading identity key from %s: %w", keyPath, err)
+    }
+
+    seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+    if err != nil || len(seed) != ed25519.SeedSize {
+        return fmt.Errorf("invalid identity key in %s, expected a hex encoded %d-byte seed", keyPath, ed25519.SeedSize)
+    }
+    c.identityKey = ed25519.NewKeyFromSeed(seed)
+    log.Printf("Loaded identity key with public key: %x", c.identityKey.Public())
+    return nil
+}
+
+// GetTransportMode returns which transports the server listens on
+func (c *Config) GetTransportMode() string {
+    return c.transportMode
+}
+
+// loadTransportMode reads the optional transport mode file, defaulting to TransportModeUDP.
+func (c *Config) loadTransportMode() error {
+    modePath := filepath.Join(c.datadir, "transport_mode.txt")
+    data, err := ioutil.ReadFile(modePath)
+    if os.IsNotExist(err) {
+        return nil
+    } else if err != nil {
+        return fmt.Errorf("error loading transport mode from %s: %w", modePath, err)
+    }
+
+    mode := strings.TrimSpace(string(data))
+    switch mode {
+    case TransportModeUDP, TransportModeTCP, TransportModeBoth:
+        c.transportMode = mode
+    default:
+        return fmt.Errorf("invalid transport mode in %s: %q", modePath, mode)
+    }
+    log.Printf("Loaded transport mode: %s", c.transportMode)
+    return nil
+}
+
+// GetPeerTransport returns the transport a peer server is reached on, "udp" unless configured otherwise
+func (c *Config) GetPeerTransport(serverAddress string) string {
+    if peerTransport, exists := c.peerTransports[types.CanonicalServerAddress(serverAddress)]; exists {
+        return peerTransport
+    }
+    return TransportModeUDP
+}
+
+// loadPeerTransports reads the optional peer transport configuration file, with one server address and
+// the transport it is reached on, "udp" or "tcp", per line.
+func (c *Config) loadPeerTransports() error {
+    transportsPath := filepath.Join(c.datadir, "peer_transports.txt")
+    data, err := ioutil.ReadFile(transportsPath)
+    if os.IsNotExist(err) {
+        return nil
+    } else if err != nil {
+        return fmt.Errorf("error loading peer transports from %s: %w", transportsPath, err)
+    }
+
+    for _, line := range strings.Split(string(data), "\n") {
+        fields := strings.Fields(line)
+        if len(fields) == 0 {
+            continue
+        }
+        if len(fields) != 2 || (fields[1] != TransportModeUDP && fields[1] != TransportModeTCP) {
+            return fmt.Errorf("invalid line in %s: %q", transportsPath, line)
+        }
+        if err := types.ValidateServerAddress("peer server address", fields[0]); err != nil {
+            return fmt.Errorf("invalid line in %s: %w", transportsPath, err)
+        }
+        // Stored under the canonical address, which is what peer servers are looked up by
+        c.peerTransports[types.CanonicalServerAddress(fields[0])] = fields[1]
+    }
+    log.Printf("Loaded transports for %d peer servers", len(c.peerTransports))
     return nil
 }
 
 // setupLogger initializes the logging configuration.
-func setupLogger() error {
+func setupLogger(datadir string) error {
     // Construct the full path to the log file
     logFilePath := filepath.Join(datadir, "ripple.log")
     
@@ -59,17 +281,55 @@ func setupLogger() error {
     return nil
 }
 
-// InitConfig initializes the configuration by setting up the logger and loading the server address.
-func InitConfig() error {
-    if err := setupLogger(); err != nil {
-        return fmt.Errorf("initializing logger: %w", err)
+// InitConfig initializes the configuration of the server started from the command line, by setting up the logger
+// and loading the configuration from the default data directory.
+func InitConfig() (*Config, error) {
+    datadir := DefaultDataDir()
+    if err := setupLogger(datadir); err != nil {
+        return nil, fmt.Errorf("initializing logger: %w", err)
     }
     log.Println("Logger setup completed, initializing configuration...")
+    return LoadConfig(datadir)
+}
+
+// LoadConfig loads the configuration of a server instance from its data directory, starting with the server address.
+func LoadConfig(datadir string) (*Config, error) {
+    c := &Config{
+        datadir:        datadir,
+        currencyScales: make(map[string]uint8),
+        signatureMode:  SignatureModeMigrate,
+        transportMode:  TransportModeUDP,
+        peerTransports: make(map[string]string),
+    }
+
+    if err := c.loadServerAddress(); err != nil {
+        return nil, fmt.Errorf("initializing configuration by loading server address: %w", err)
+    }
+
+    if err := c.loadCurrencyScales(); err != nil {
+        return nil, fmt.Errorf("initializing configuration by loading currency scales: %w", err)
+    }
 
-    if err := loadServerAddress(); err != nil {
-        return fmt.Errorf("initializing configuration by loading server address: %w", err)
+    if err := c.loadSignatureMode(); err != nil {
+        return nil, fmt.Errorf("initializing configuration by loading signature mode: %w", err)
+    }
+
+    if err := c.loadEncryptionMode(); err != nil {
+        return nil, fmt.Errorf("initializing configuration by loading encryption mode: %w", err)
+    }
+
+    if err := c.loadIdentityKey(); err != nil {
+        return nil, fmt.Errorf("initializing configuration by loading identity key: %w", err)
+    }
+
+    if err := c.loadTransportMode(); err != nil {
+        return nil, fmt.Errorf("initializing configuration by loading transport mode: %w", err)
+    }
+
+    if err := c.loadPeerTransports(); err != nil {
+        return nil, fmt.Errorf("initializing configuration by loading peer transports: %w", err)
     }
 
     log.Println("Configuration initialized successfully.")
-    return nil
+    return c, nil
 }
@@ -0,0 +1,43 @@
+package config
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// newPeerTransportsDataDir creates a data directory with a server address and the given peer_transports.txt
+func newPeerTransportsDataDir(t *testing.T, peerTransports string) string {
+    t.Helper()
+    datadir := t.TempDir()
+    if err := os.WriteFile(filepath.Join(datadir, "server_address.txt"), []byte("alpha.test"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(filepath.Join(datadir, "peer_transports.txt"), []byte(peerTransports), 0644); err != nil {
+        t.Fatal(err)
+    }
+    return datadir
+}
+
+func TestPeerTransportsAreCanonicalized(t *testing.T) {
+    cfg, err := LoadConfig(newPeerTransportsDataDir(t, "Beta.Test:2012 tcp\n[2001:DB8:0::1]:3000 tcp\n"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, address := range []string{"beta.test", "BETA.test:2012", "[2001:db8::1]:3000"} {
+        if transport := cfg.GetPeerTransport(address); transport != TransportModeTCP {
+            t.Errorf("transport of %s is %q, want %q", address, transport, TransportModeTCP)
+        }
+    }
+    if transport := cfg.GetPeerTransport("gamma.test"); transport != TransportModeUDP {
+        t.Errorf("transport of an unlisted server is %q, want %q", transport, TransportModeUDP)
+    }
+}
+
+func TestPeerTransportsRejectInvalidAddresses(t *testing.T) {
+    for _, address := range []string{"../beta.test", "beta.test:0", "2001:db8::1", "beta_test"} {
+        if _, err := LoadConfig(newPeerTransportsDataDir(t, address+" tcp\n")); err == nil {
+            t.Errorf("peer transport for %q loaded, want an error", address)
+        }
+    }
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "time"
+
+    "ripple/config"
+)
+
+// GetRemovedDir constructs the directory removed accounts are moved to and returns it
+func GetRemovedDir(cfg *config.Config) string {
+    return filepath.Join(cfg.GetDataDir(), "removed")
+}
+
+// CreateAccount creates the account directory of a username with its secret key, a zeroed counter and an empty peers directory.
+// It fails if the account already exists.
+func CreateAccount(cfg *config.Config, username string, secretKey []byte) error {
+    accountDir := GetAccountDir(cfg, username)
+    if err := os.MkdirAll(filepath.Dir(accountDir), 0755); err != nil {
+        return fmt.Errorf("error creating accounts directory: %w", err)
+    }
+    if err := os.Mkdir(accountDir, 0700); err != nil {
+        return fmt.Errorf("error creating account directory %s: %w", accountDir, err)
+    }
+
+    if err := os.Mkdir(filepath.Join(accountDir, "peers"), 0755); err != nil {
+        return fmt.Errorf("error creating peers directory for %s: %w", username, err)
+    }
+    if err := os.WriteFile(filepath.Join(accountDir, "secretkey.txt"), secretKey, 0600); err != nil {
+        return fmt.Errorf("error writing secret key for %s: %w", username, err)
+    }
+    return WriteUint32ToFile(accountDir, "counter.txt", 0)
+}
+
+// IsAccountSuspended checks whether an account is suspended, which is marked by a suspended.txt file in the account directory
+func IsAccountSuspended(cfg *config.Config, username string) (bool, error) {
+    suspendedPath := filepath.Join(GetAccountDir(cfg, username), "suspended.txt")
+    if _, err := os.Stat(suspendedPath); err != nil {
+        if os.IsNotExist(err) {
+            return false, nil
+        }
+        return false, err
+    }
+    return true, nil
+}
+
+// SetAccountSuspended suspends or reinstates an account
+func SetAccountSuspended(cfg *config.Config, username string, suspended bool) error {
+    accountDir := GetAccountDir(cfg, username)
+    if exists, err := checkDirExists(accountDir); err != nil {
+        return err
+    } else if !exists {
+        return fmt.Errorf("account %s does not exist", username)
+    }
+
+    suspendedPath := filepath.Join(accountDir, "suspended.txt")
+    if suspended {
+        return WriteTimeToFile(accountDir, "suspended.txt", time.Now().Unix())
+    }
+    if err := os.Remove(suspendedPath); err != nil && !os.IsNotExist(err) {
+        return fmt.Errorf("error reinstating account %s: %w", username, err)
+    }
+    return nil
+}
+
+// RemoveAccount moves an account directory out of "accounts" and into the removed directory, suffixed with the
+// Unix time of removal, so nothing is lost if an account is removed by mistake.
+func RemoveAccount(cfg *config.Config, username string) error {
+    accountDir := GetAccountDir(cfg, username)
+    if err := os.MkdirAll(GetRemovedDir(cfg), 0755); err != nil {
+        return fmt.Errorf("error creating removed directory: %w", err)
+    }
+
+    removedDir := filepath.Join(GetRemovedDir(cfg), username+"-"+strconv.FormatInt(time.Now().Unix(), 10))
+    if err := os.Rename(accountDir, removedDir); err != nil {
+        return fmt.Errorf("error removing account directory %s: %w", accountDir, err)
+    }
+    return nil
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "time"
+    "ripple/types"
+    "ripple/config"
+)
+
+// GetArchiveDir constructs the archive directory path for closed peers of a username and returns it
+func GetArchiveDir(cfg *config.Config, username string) string {
+    accountDir := GetAccountDir(cfg, username)
+    return filepath.Join(accountDir, "archive")
+}
+
+// ArchivePeerDir moves a peer directory out of "peers" and into the account's archive directory.
+// The archived directory is suffixed with the Unix time of closing so a peer can be closed more than once.
+func ArchivePeerDir(cfg *config.Config, username, peerServerAddress, peerUsername string) error {
+    peerDir := GetPeerDir(cfg, username, peerServerAddress, peerUsername)
+    archiveServerDir := filepath.Join(GetArchiveDir(cfg, username), types.EncodeServerAddress(peerServerAddress))
+
+    if err := os.MkdirAll(archiveServerDir, 0755); err != nil {
+        return fmt.Errorf("error creating archive directory %s: %w", archiveServerDir, err)
+    }
+
+    archivedName := peerUsername + "-" + strconv.FormatInt(time.Now().Unix(), 10)
+    archivedDir := filepath.Join(archiveServerDir, archivedName)
+    if err := os.Rename(peerDir, archivedDir); err != nil {
+        return fmt.Errorf("error archiving peer directory %s: %w", peerDir, err)
+    }
+
+    // Remove the server address directory if this was its last peer, so it is not left empty
+    serverDir := filepath.Dir(peerDir)
+    if entries, err := os.ReadDir(serverDir); err == nil && len(entries) == 0 {
+        os.Remove(serverDir)
+    }
+
+    return nil
+}
+
+// SetPeerWriteOff records, in written_off.txt in the peer directory, that a peer closed the relationship and
+// wrote off what the account owes it
+func SetPeerWriteOff(cfg *config.Config, username, peerServerAddress, peerUsername string) error {
+    peerDir := GetPeerDir(cfg, username, peerServerAddress, peerUsername)
+    return WriteTimeToFile(peerDir, "written_off.txt", time.Now().Unix())
+}
+
+// GetPeerWriteOff checks if a peer has written off what the account owes it
+func GetPeerWriteOff(cfg *config.Config, username, peerServerAddress, peerUsername string) (bool, error) {
+    peerDir := GetPeerDir(cfg, username, peerServerAddress, peerUsername)
+    writtenOff, err := ReadOptionalTimeFromFile(peerDir, "written_off.txt")
+    return writtenOff != 0, err
+}
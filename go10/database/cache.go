@@ -0,0 +1,136 @@
+package database
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// cacheEntry holds the contents of a file along with the size and modification time it had when read,
+// so a change to the file made outside the cache is noticed on the next read.
+type cacheEntry struct {
+    data    []byte
+    size    int64
+    modTime time.Time
+}
+
+// fileCache caches small files that are read for every datagram, the secret keys and the counters
+var fileCache = struct {
+    entries map[string]cacheEntry
+    mu      sync.Mutex
+}{entries: make(map[string]cacheEntry)}
+
+// ReadCachedFile reads a file through the cache. The file is only read from disk if it is not cached,
+// or if its size or modification time differs from when it was cached.
+func ReadCachedFile(dir, filename string) ([]byte, error) {
+    filePath := filepath.Join(dir, filename)
+    info, err := os.Stat(filePath)
+    if err != nil {
+        invalidateCachedFile(filePath)
+        return nil, fmt.Errorf("error reading file %s: %w", filePath, err)
+    }
+
+    fileCache.mu.Lock()
+    entry, exists := fileCache.entries[filePath]
+    fileCache.mu.Unlock()
+    if exists && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+        return entry.data, nil
+    }
+
+    data, err := ReadFile(dir, filename)
+    if err != nil {
+        return nil, err
+    }
+    fileCache.mu.Lock()
+    fileCache.entries[filePath] = cacheEntry{data: data, size: info.Size(), modTime: info.ModTime()}
+    fileCache.mu.Unlock()
+    return data, nil
+}
+
+// WriteCachedFileDurable writes a file through the cache, and only returns once the file is on disk.
+func WriteCachedFileDurable(dir, filename string, data []byte) error {
+    filePath := filepath.Join(dir, filename)
+    if err := WriteFileDurable(dir, filename, data); err != nil {
+        invalidateCachedFile(filePath)
+        return err
+    }
+
+    info, err := os.Stat(filePath)
+    if err != nil {
+        invalidateCachedFile(filePath)
+        return nil
+    }
+    fileCache.mu.Lock()
+    fileCache.entries[filePath] = cacheEntry{data: data, size: info.Size(), modTime: info.ModTime()}
+    fileCache.mu.Unlock()
+    return nil
+}
+
+// WriteFileDurable writes a file and only returns once it is on disk. The data is written to a temporary file
+// that is synced and renamed over the file, so a crash leaves either the old or the new contents.
+func WriteFileDurable(dir, filename string, data []byte) error {
+    filePath := filepath.Join(dir, filename)
+    tempPath := filePath + ".tmp"
+
+    file, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+    if err != nil {
+        return fmt.Errorf("error creating file %s: %w", tempPath, err)
+    }
+    if _, err := file.Write(data); err != nil {
+        file.Close()
+        return fmt.Errorf("error writing file %s: %w", tempPath, err)
+    }
+    if err := file.Sync(); err != nil {
+        file.Close()
+        return fmt.Errorf("error syncing file %s: %w", tempPath, err)
+    }
+    if err := file.Close(); err != nil {
+        return fmt.Errorf("error closing file %s: %w", tempPath, err)
+    }
+    if err := os.Rename(tempPath, filePath); err != nil {
+        return fmt.Errorf("error renaming file %s: %w", tempPath, err)
+    }
+    return syncDir(dir)
+}
+
+// GetCachedUint32 reads a uint32 value from a file through the cache.
+func GetCachedUint32(dir, filename string) (uint32, error) {
+    data, err := ReadCachedFile(dir, filename)
+    if err != nil {
+        return 0, err
+    }
+
+    value, err := strconv.ParseUint(string(data), 10, 32)
+    if err != nil {
+        return 0, fmt.Errorf("error parsing value from file %s: %v", filepath.Join(dir, filename), err)
+    }
+    return uint32(value), nil
+}
+
+// WriteCachedUint32Durable writes a uint32 value to a file through the cache, and only returns once it is on disk.
+func WriteCachedUint32Durable(dir, filename string, value uint32) error {
+    return WriteCachedFileDurable(dir, filename, []byte(fmt.Sprintf("%d", value)))
+}
+
+// invalidateCachedFile removes a file from the cache
+func invalidateCachedFile(filePath string) {
+    fileCache.mu.Lock()
+    delete(fileCache.entries, filePath)
+    fileCache.mu.Unlock()
+}
+
+// syncDir syncs a directory, so a file renamed into it survives a crash
+func syncDir(dir string) error {
+    d, err := os.Open(dir)
+    if err != nil {
+        return fmt.Errorf("error opening directory %s: %w", dir, err)
+    }
+    defer d.Close()
+    if err := d.Sync(); err != nil {
+        return fmt.Errorf("error syncing directory %s: %w", dir, err)
+    }
+    return nil
+}
@@ -1,41 +1,117 @@
 package database
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 	"ripple/types"
+	"ripple/config"
 )
 
+// CounterOutReservation is how many outgoing counters are reserved at a time. counter_out.txt holds the end of the
+// reservation rather than the next counter, so it is only written once per reservation, and a restart skips the
+// counters left in it, which the peer's sliding window accepts like any counter above the highest seen.
+const CounterOutReservation = 1024
+
+// counterOutReservations holds the next counter and the end of the reservation per peer directory
+var counterOutReservations = struct {
+	mu      sync.Mutex
+	entries map[string]*counterOutReservation
+}{entries: make(map[string]*counterOutReservation)}
+
+type counterOutReservation struct {
+	next  uint32
+	limit uint32
+}
+
 // GetCounter retrieves the counter value using the datagram to determine the directory.
-func GetCounter(dg *types.Datagram) (uint32, error) {
-	accountDir := GetAccountDir(dg.Username)
-	return GetUint32FromFile(accountDir, "counter.txt")
+func GetCounter(cfg *config.Config, dg *types.Datagram) (uint32, error) {
+	accountDir := GetAccountDir(cfg, dg.Username)
+	return GetCachedUint32(accountDir, "counter.txt")
 }
 
 // SetCounter sets the counter value.
-func SetCounter(dg *types.Datagram) error {
-	accountDir := GetAccountDir(dg.Username)
-	return WriteUint32ToFile(accountDir, "counter.txt", dg.Counter)
+func SetCounter(cfg *config.Config, dg *types.Datagram) error {
+	accountDir := GetAccountDir(cfg, dg.Username)
+	return WriteCachedUint32Journaled(cfg, accountDir, "counter.txt", dg.Counter)
 }
 
 // GetCounterIn retrieves the counter_in value using the datagram to determine the directory.
-func GetCounterIn(dg *types.Datagram) (uint32, error) {
-	peerDir := GetPeerDir(dg.Username, dg.PeerServerAddress, dg.PeerUsername)
-	return GetUint32FromFile(peerDir, "counter_in.txt")
+func GetCounterIn(cfg *config.Config, dg *types.Datagram) (uint32, error) {
+	peerDir := GetPeerDir(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+	return GetCachedUint32(peerDir, "counter_in.txt")
+}
+
+// AdvanceCounterIn sets counter_in to the datagram's counter together with the sliding window that belongs to it.
+// counter_in is written first, so a crash that only keeps the first write leaves a window that belongs to the
+// previous counter_in, which is read back as all seen.
+func AdvanceCounterIn(cfg *config.Config, dg *types.Datagram, window uint64) error {
+	peerDir := GetPeerDir(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+	return writeJournaled(cfg, 
+		journalWrite{peerDir, "counter_in.txt", []byte(fmt.Sprintf("%d", dg.Counter))},
+		journalWrite{peerDir, "window_in.txt", []byte(fmt.Sprintf("%d %d", dg.Counter, window))},
+	)
 }
 
-// SetCounterIn sets the counter_in value.
-func SetCounterIn(dg *types.Datagram) error {
-	peerDir := GetPeerDir(dg.Username, dg.PeerServerAddress, dg.PeerUsername)
-	return WriteUint32ToFile(peerDir, "counter_in.txt", dg.Counter)
+// GetWindowIn retrieves the sliding window bitmap of recently seen counters below counter_in. Bit i is set if
+// counter_in - i has been seen. The window is stored with the counter_in it belongs to, and a missing window or
+// one that belongs to another counter_in is returned with every bit set, so only counters above counter_in are accepted.
+func GetWindowIn(cfg *config.Config, dg *types.Datagram, counterIn uint32) (uint64, error) {
+	peerDir := GetPeerDir(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+	data, err := ReadCachedFile(peerDir, "window_in.txt")
+	if errors.Is(err, os.ErrNotExist) {
+		return ^uint64(0), nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	var highest uint32
+	var window uint64
+	if _, err := fmt.Sscanf(string(data), "%d %d", &highest, &window); err != nil {
+		return 0, fmt.Errorf("error parsing window from file %s: %v", filepath.Join(peerDir, "window_in.txt"), err)
+	}
+	if highest != counterIn {
+		return ^uint64(0), nil
+	}
+	return window, nil
 }
 
-// GetCounterOut retrieves the counter_out value using the datagram to determine the directory.
-func GetCounterOut(username, peerServerAddress, peerUsername string) (uint32, error) {
-	peerDir := GetPeerDir(username, peerServerAddress, peerUsername)
-	return GetUint32FromFile(peerDir, "counter_out.txt")
+// SetWindowIn sets the sliding window bitmap together with the counter_in it belongs to.
+func SetWindowIn(cfg *config.Config, dg *types.Datagram, counterIn uint32, window uint64) error {
+	peerDir := GetPeerDir(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+	return WriteCachedFileJournaled(cfg, peerDir, "window_in.txt", []byte(fmt.Sprintf("%d %d", counterIn, window)))
 }
 
-// SetCounterOut sets the counter_out value.
-func SetCounterOut(username, peerServerAddress, peerUsername string, value uint32) error {
-	peerDir := GetPeerDir(username, peerServerAddress, peerUsername)
-	return WriteUint32ToFile(peerDir, "counter_out.txt", value)
+// NextCounterOut returns the next outgoing counter to a peer account. Counters are handed out from a reservation
+// in memory, and a new reservation is written to disk once it is used up. A counter_out.txt that differs from the
+// end of the reservation, because it was edited or the peer directory recreated, starts a new reservation from its value.
+func NextCounterOut(cfg *config.Config, username, peerServerAddress, peerUsername string) (uint32, error) {
+	peerDir := GetPeerDir(cfg, username, peerServerAddress, peerUsername)
+	stored, err := GetCachedUint32(peerDir, "counter_out.txt")
+	if err != nil {
+		return 0, err
+	}
+
+	counterOutReservations.mu.Lock()
+	defer counterOutReservations.mu.Unlock()
+
+	reservation, exists := counterOutReservations.entries[peerDir]
+	if !exists || reservation.limit != stored {
+		reservation = &counterOutReservation{next: stored, limit: stored}
+		counterOutReservations.entries[peerDir] = reservation
+	}
+	if reservation.next == reservation.limit {
+		limit := reservation.next + CounterOutReservation
+		if err := WriteCachedUint32Durable(peerDir, "counter_out.txt", limit); err != nil {
+			delete(counterOutReservations.entries, peerDir)
+			return 0, err
+		}
+		reservation.limit = limit
+	}
+
+	counterOut := reservation.next
+	reservation.next++
+	return counterOut, nil
 }
@@ -6,12 +6,13 @@ import (
     "path/filepath"
     "ripple/config"
     "ripple/pathfinding"
+    "ripple/types"
 )
 
 // GetPeers retrieves a list of all peer accounts for a given username
-func GetPeers(username string) ([]pathfinding.PeerAccount, error) {
+func GetPeers(cfg *config.Config, username string) ([]pathfinding.PeerAccount, error) {
     var peers []pathfinding.PeerAccount
-    baseDir := filepath.Join(config.GetDataDir(), "accounts", username, "peers")
+    baseDir := filepath.Join(cfg.GetDataDir(), "accounts", username, "peers")
 
     // Read all server address directories in the peers directory
     serverDirs, err := ioutil.ReadDir(baseDir)
@@ -22,8 +23,11 @@ func GetPeers(username string) ([]pathfinding.PeerAccount, error) {
     // Iterate over all server address directories
     for _, serverDir := range serverDirs {
         if serverDir.IsDir() {
-            serverAddress := serverDir.Name()
-            serverPath := filepath.Join(baseDir, serverAddress)
+            serverPath := filepath.Join(baseDir, serverDir.Name())
+            serverAddress, err := types.DecodeServerAddress(serverDir.Name())
+            if err != nil {
+                return nil, err
+            }
 
             // Read all peer directories under the current server address
             peerDirs, err := ioutil.ReadDir(serverPath)
@@ -0,0 +1,23 @@
+package db_trustlines
+
+import (
+	"ripple/database"
+	"ripple/config"
+)
+
+// InitTrustline creates the trustline directory for a currency the first time it is used,
+// with the trustlines and sync counters starting at zero.
+func InitTrustline(cfg *config.Config, username, peerServerAddress, peerUsername, currency string) error {
+	created, err := database.CreateTrustlineDir(cfg, username, peerServerAddress, peerUsername, currency)
+	if err != nil || !created {
+		return err
+	}
+
+	trustlineDir := database.GetTrustlineDir(cfg, username, peerServerAddress, peerUsername, currency)
+	for _, filename := range []string{"trustline_out.txt", "trustline_in.txt", "sync_counter.txt", "sync_in.txt", "sync_out.txt"} {
+		if err := database.WriteUint32ToFile(trustlineDir, filename, 0); err != nil {
+			return err
+		}
+	}
+	return database.WriteTimeToFile(trustlineDir, "timestamp.txt", 0)
+}
@@ -3,34 +3,55 @@ package db_trustlines
 import (
 	"ripple/types"
 	"ripple/database"
+	"ripple/config"
 )
 
 // GetTrustlineOut retrieves the outbound trustline
-func GetTrustlineOut(username, peerServerAddress, peerUsername string) (uint32, error) {
-	trustlineDir := database.GetTrustlineDir(username, peerServerAddress, peerUsername)
-	return database.GetUint32FromFile(trustlineDir, "trustline_out.txt")
+func GetTrustlineOut(cfg *config.Config, username, peerServerAddress, peerUsername, currency string) (types.Amount, error) {
+	trustlineDir := database.GetTrustlineDir(cfg, username, peerServerAddress, peerUsername, currency)
+	value, err := database.GetUint64FromFile(trustlineDir, "trustline_out.txt")
+	return types.Amount(value), err
 }
 
 // GetTrustlineIn retrieves the inbound trustline
-func GetTrustlineIn(username, peerServerAddress, peerUsername string) (uint32, error) {
-	trustlineDir := database.GetTrustlineDir(username, peerServerAddress, peerUsername)
-	return database.GetUint32FromFile(trustlineDir, "trustline_in.txt")
+func GetTrustlineIn(cfg *config.Config, username, peerServerAddress, peerUsername, currency string) (types.Amount, error) {
+	trustlineDir := database.GetTrustlineDir(cfg, username, peerServerAddress, peerUsername, currency)
+	value, err := database.GetUint64FromFile(trustlineDir, "trustline_in.txt")
+	return types.Amount(value), err
+}
+
+// GetExpiryOut retrieves the expiry of the outbound trustline, 0 if it does not expire
+func GetExpiryOut(cfg *config.Config, username, peerServerAddress, peerUsername, currency string) (int64, error) {
+	trustlineDir := database.GetTrustlineDir(cfg, username, peerServerAddress, peerUsername, currency)
+	return database.ReadOptionalTimeFromFile(trustlineDir, "expiry_out.txt")
+}
+
+// GetExpiryIn retrieves the expiry of the inbound trustline, 0 if it does not expire
+func GetExpiryIn(cfg *config.Config, username, peerServerAddress, peerUsername, currency string) (int64, error) {
+	trustlineDir := database.GetTrustlineDir(cfg, username, peerServerAddress, peerUsername, currency)
+	return database.ReadOptionalTimeFromFile(trustlineDir, "expiry_in.txt")
 }
 
 // GetSyncCounter retrieves the sync_counter_in value using the datagram to determine the directory.
-func GetSyncCounter(dg *types.Datagram) (uint32, error) {
-	trustlineDir := database.GetTrustlineDir(dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+func GetSyncCounter(cfg *config.Config, dg *types.Datagram, currency string) (uint32, error) {
+	trustlineDir := database.GetTrustlineDir(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername, currency)
 	return database.GetUint32FromFile(trustlineDir, "sync_counter.txt")
 }
 
 // GetSyncIn retrieves the sync_in value using the datagram to determine the directory.
-func GetSyncIn(dg *types.Datagram) (uint32, error) {
-	trustlineDir := database.GetTrustlineDir(dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+func GetSyncIn(cfg *config.Config, dg *types.Datagram, currency string) (uint32, error) {
+	trustlineDir := database.GetTrustlineDir(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername, currency)
 	return database.GetUint32FromFile(trustlineDir, "sync_in.txt")
 }
 
 // GetSyncOut retrieves the sync_out value using the datagram to determine the directory.
-func GetSyncOut(dg *types.Datagram) (uint32, error) {
-	trustlineDir := database.GetTrustlineDir(dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+func GetSyncOut(cfg *config.Config, dg *types.Datagram, currency string) (uint32, error) {
+	trustlineDir := database.GetTrustlineDir(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername, currency)
 	return database.GetUint32FromFile(trustlineDir, "sync_out.txt")
 }
+
+// GetTimestamp retrieves the sync timestamp using the datagram to determine the directory.
+func GetTimestamp(cfg *config.Config, dg *types.Datagram, currency string) (int64, error) {
+	trustlineDir := database.GetTrustlineDir(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername, currency)
+	return database.ReadTimeFromFile(trustlineDir, "timestamp.txt")
+}
@@ -1,42 +1,102 @@
 package db_trustlines
 
-import "ripple/types"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+	"ripple/types"
+	"ripple/config"
+)
 
 // GetTrustlineOutFromDatagram retrieves the outbound trustline using fields from datagram
-func GetTrustlineOutFromDatagram(dg *types.Datagram) (uint32, error) {
-	return GetTrustlineOut(dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+func GetTrustlineOutFromDatagram(cfg *config.Config, dg *types.Datagram, currency string) (types.Amount, error) {
+	return GetTrustlineOut(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername, currency)
 }
 
 // GetTrustlineInFromDatagram retrieves the inbound trustline using fields from datagram
-func GetTrustlineInFromDatagram(dg *types.Datagram) (uint32, error) {
-	return GetTrustlineIn(dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+func GetTrustlineInFromDatagram(cfg *config.Config, dg *types.Datagram, currency string) (types.Amount, error) {
+	return GetTrustlineIn(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername, currency)
 }
 
 // SetTrustlineOutFromDatagram sets the outbound trustline amount using fields from datagram
-func SetTrustlineOutFromDatagram(dg *types.Datagram, value uint32) error {
-	return SetTrustlineOut(dg.Username, dg.PeerServerAddress, dg.PeerUsername, value)
+func SetTrustlineOutFromDatagram(cfg *config.Config, dg *types.Datagram, currency string, value types.Amount) error {
+	return SetTrustlineOut(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername, currency, value)
 }
 
 // SetTrustlineInFromDatagram sets the inbound trustline amount using fields from datagram
-func SetTrustlineInFromDatagram(dg *types.Datagram, value uint32) error {
-	return SetTrustlineIn(dg.Username, dg.PeerServerAddress, dg.PeerUsername, value)
+func SetTrustlineInFromDatagram(cfg *config.Config, dg *types.Datagram, currency string, value types.Amount) error {
+	return SetTrustlineIn(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername, currency, value)
 }
 
 // GetTrustline retrieves the trustline (either incoming or outgoing) based on the inOrOut parameter.
-func GetTrustline(username, peerServerAddress, peerUsername string, inOrOut byte) (uint32, error) {
+func GetTrustline(cfg *config.Config, username, peerServerAddress, peerUsername, currency string, inOrOut byte) (types.Amount, error) {
     if inOrOut == 0 { // Assume 0 means incoming trustline
-        return GetTrustlineIn(username, peerServerAddress, peerUsername)
+        return GetTrustlineIn(cfg, username, peerServerAddress, peerUsername, currency)
     } else { // Assume 1 means outgoing trustline
-        return GetTrustlineOut(username, peerServerAddress, peerUsername)
+        return GetTrustlineOut(cfg, username, peerServerAddress, peerUsername, currency)
     }
 }
 
+// GetExpiry retrieves the trustline expiry (either incoming or outgoing) based on the inOrOut parameter.
+func GetExpiry(cfg *config.Config, username, peerServerAddress, peerUsername, currency string, inOrOut byte) (int64, error) {
+	if inOrOut == types.Incoming {
+		return GetExpiryIn(cfg, username, peerServerAddress, peerUsername, currency)
+	}
+	return GetExpiryOut(cfg, username, peerServerAddress, peerUsername, currency)
+}
+
+// GetEffectiveTrustline retrieves the trustline (either incoming or outgoing), or zero if it has expired.
+func GetEffectiveTrustline(cfg *config.Config, username, peerServerAddress, peerUsername, currency string, inOrOut byte) (types.Amount, error) {
+	expiry, err := GetExpiry(cfg, username, peerServerAddress, peerUsername, currency, inOrOut)
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve trustline expiry: %v", err)
+	}
+	if expiry != 0 && time.Now().Unix() >= expiry {
+		return 0, nil
+	}
+	return GetTrustline(cfg, username, peerServerAddress, peerUsername, currency, inOrOut)
+}
+
 // GetCreditline retrieves the creditline (either incoming or outgoing) based on the inOrOut parameter.
-func GetCreditline(username, peerServerAddress, peerUsername string, inOrOut byte) (uint32, error) {
+func GetCreditline(cfg *config.Config, username, peerServerAddress, peerUsername, currency string, inOrOut byte) (types.Amount, error) {
     // if inOrOut == 0 { // Assume 0 means incoming trustline
-    //     return GetCreditlineIn(username, peerServerAddress, peerUsername)
+    //     return GetCreditlineIn(username, peerServerAddress, peerUsername, currency)
     // } else { // Assume 1 means outgoing trustline
-    //     return GetCreditlineOut(username, peerServerAddress, peerUsername)
+    //     return GetCreditlineOut(username, peerServerAddress, peerUsername, currency)
     // }
 	return 0, nil
 }
+
+// GetAvailableTrustline retrieves how much of the trustline (either incoming or outgoing) is not used by the credit line.
+// A trustline lowered below its credit line is over limit and has nothing available, so the credit line can only shrink.
+// A peer without a trustline in the currency has nothing available either.
+func GetAvailableTrustline(cfg *config.Config, username, peerServerAddress, peerUsername, currency string, inOrOut byte) (types.Amount, error) {
+	trustline, err := GetEffectiveTrustline(cfg, username, peerServerAddress, peerUsername, currency, inOrOut)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to retrieve trustline: %v", err)
+	}
+
+	creditline, err := GetCreditline(cfg, username, peerServerAddress, peerUsername, currency, inOrOut)
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve creditline: %v", err)
+	}
+
+	// Subtraction that would go below zero means the trustline is over limit
+	available, err := types.SubAmounts(trustline, creditline)
+	if errors.Is(err, types.ErrAmountOverflow) {
+		return 0, nil
+	}
+	return available, err
+}
+
+// IsOverLimit checks if the credit line (either incoming or outgoing) exceeds a trustline amount.
+func IsOverLimit(cfg *config.Config, username, peerServerAddress, peerUsername, currency string, trustline types.Amount, inOrOut byte) (bool, error) {
+	creditline, err := GetCreditline(cfg, username, peerServerAddress, peerUsername, currency, inOrOut)
+	if err != nil {
+		return false, fmt.Errorf("failed to retrieve creditline: %v", err)
+	}
+	return creditline > trustline, nil
+}
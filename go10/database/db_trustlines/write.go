@@ -3,40 +3,53 @@ package db_trustlines
 import (
 	"ripple/types"
 	"ripple/database"
+	"ripple/config"
 )
 
 // SetTrustlineOut sets the outbound trustline amount.
-func SetTrustlineOut(username, peerServerAddress, peerUsername string, value uint32) error {
-	trustlineDir := database.GetTrustlineDir(username, peerServerAddress, peerUsername)
-	return database.WriteUint32ToFile(trustlineDir, "trustline_out.txt", value)
+func SetTrustlineOut(cfg *config.Config, username, peerServerAddress, peerUsername, currency string, value types.Amount) error {
+	trustlineDir := database.GetTrustlineDir(cfg, username, peerServerAddress, peerUsername, currency)
+	return database.WriteUint64ToFile(trustlineDir, "trustline_out.txt", uint64(value))
 }
 
 // SetTrustlineOut sets the inbound trustline amount.
-func SetTrustlineIn(username, peerServerAddress, peerUsername string, value uint32) error {
-	trustlineDir := database.GetTrustlineDir(username, peerServerAddress, peerUsername)
-	return database.WriteUint32ToFile(trustlineDir, "trustline_in.txt", value)
+func SetTrustlineIn(cfg *config.Config, username, peerServerAddress, peerUsername, currency string, value types.Amount) error {
+	trustlineDir := database.GetTrustlineDir(cfg, username, peerServerAddress, peerUsername, currency)
+	return database.WriteUint64ToFile(trustlineDir, "trustline_in.txt", uint64(value))
+}
+
+// SetExpiryOut sets the expiry of the outbound trustline, 0 for no expiry.
+func SetExpiryOut(cfg *config.Config, username, peerServerAddress, peerUsername, currency string, expiry int64) error {
+	trustlineDir := database.GetTrustlineDir(cfg, username, peerServerAddress, peerUsername, currency)
+	return database.WriteTimeToFile(trustlineDir, "expiry_out.txt", expiry)
+}
+
+// SetExpiryIn sets the expiry of the inbound trustline, 0 for no expiry.
+func SetExpiryIn(cfg *config.Config, username, peerServerAddress, peerUsername, currency string, expiry int64) error {
+	trustlineDir := database.GetTrustlineDir(cfg, username, peerServerAddress, peerUsername, currency)
+	return database.WriteTimeToFile(trustlineDir, "expiry_in.txt", expiry)
 }
 
 // SetSyncCounter sets the sync_counter value.
-func SetSyncCounter(dg *types.Datagram, value uint32) error {
-	trustlineDir := database.GetTrustlineDir(dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+func SetSyncCounter(cfg *config.Config, dg *types.Datagram, currency string, value uint32) error {
+	trustlineDir := database.GetTrustlineDir(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername, currency)
 	return database.WriteUint32ToFile(trustlineDir, "sync_counter.txt", value)
 }
 
 // SetSyncIn sets the sync_in value.
-func SetSyncIn(dg *types.Datagram, value uint32) error {
-	trustlineDir := database.GetTrustlineDir(dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+func SetSyncIn(cfg *config.Config, dg *types.Datagram, currency string, value uint32) error {
+	trustlineDir := database.GetTrustlineDir(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername, currency)
 	return database.WriteUint32ToFile(trustlineDir, "sync_in.txt", value)
 }
 
 // SetSyncOut sets the sync_out value.
-func SetSyncOut(dg *types.Datagram, value uint32) error {
-	trustlineDir := database.GetTrustlineDir(dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+func SetSyncOut(cfg *config.Config, dg *types.Datagram, currency string, value uint32) error {
+	trustlineDir := database.GetTrustlineDir(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername, currency)
 	return database.WriteUint32ToFile(trustlineDir, "sync_out.txt", value)
 }
 
 // SetTimestamp sets the sync timestamp.
-func SetTimestamp(dg *types.Datagram, timestamp int64) error {
-	trustlineDir := database.GetTrustlineDir(dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+func SetTimestamp(cfg *config.Config, dg *types.Datagram, currency string, timestamp int64) error {
+	trustlineDir := database.GetTrustlineDir(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername, currency)
 	return database.WriteTimeToFile(trustlineDir, "timestamp.txt", timestamp)
 }
@@ -1,6 +1,7 @@
 package database
 
 import (
+    "fmt"
     "os"
     "path/filepath"
     "ripple/types"
@@ -8,21 +9,87 @@ import (
 )
 
 // GetAccountDir constructs the account directory path from a username and returns it
-func GetAccountDir(username string) string {
-    datadir := config.GetDataDir()
+func GetAccountDir(cfg *config.Config, username string) string {
+    datadir := cfg.GetDataDir()
     return filepath.Join(datadir, "accounts", username)
 }
 
-// GetPeerDir constructs the peer directory path from a username, peer server address and peer username and returns it
-func GetPeerDir(username, peerServerAddress, peerUsername string) string {
-    accountDir := GetAccountDir(username)
-    return filepath.Join(accountDir, "peers", peerServerAddress, peerUsername)
+// GetPeerDir constructs the peer directory path from a username, peer server address and peer username and returns it.
+// The server address is encoded so ports and IPv6 addresses are safe as a directory name.
+func GetPeerDir(cfg *config.Config, username, peerServerAddress, peerUsername string) string {
+    accountDir := GetAccountDir(cfg, username)
+    return filepath.Join(accountDir, "peers", types.EncodeServerAddress(peerServerAddress), peerUsername)
 }
 
-// GetTrustlineDir constructs the trustline directory path from  a username, peer server address and peer username and returns it
-func GetTrustlineDir(username, peerServerAddress, peerUsername string) string {
-    peerDir := GetPeerDir(username, peerServerAddress, peerUsername)
-    return filepath.Join(peerDir, "trustline")
+// GetTrustlineDir constructs the trustline directory path from a username, peer server address, peer username and currency and returns it.
+// The default currency (an empty currency code) uses the "trustline" directory, other currencies are kept under "trustlines".
+func GetTrustlineDir(cfg *config.Config, username, peerServerAddress, peerUsername, currency string) string {
+    peerDir := GetPeerDir(cfg, username, peerServerAddress, peerUsername)
+    if currency == "" {
+        return filepath.Join(peerDir, "trustline")
+    }
+    return filepath.Join(peerDir, "trustlines", currency)
+}
+
+// GetCurrencies retrieves the currencies a peer has trustline directories for
+func GetCurrencies(cfg *config.Config, username, peerServerAddress, peerUsername string) ([]string, error) {
+    var currencies []string
+
+    exists, err := checkDirExists(GetTrustlineDir(cfg, username, peerServerAddress, peerUsername, ""))
+    if err != nil {
+        return nil, err
+    }
+    if exists {
+        currencies = append(currencies, "")
+    }
+
+    currenciesDir := filepath.Join(GetPeerDir(cfg, username, peerServerAddress, peerUsername), "trustlines")
+    entries, err := os.ReadDir(currenciesDir)
+    if err != nil && !os.IsNotExist(err) {
+        return nil, fmt.Errorf("unable to read directory %s: %v", currenciesDir, err)
+    }
+    for _, entry := range entries {
+        if entry.IsDir() {
+            currencies = append(currencies, entry.Name())
+        }
+    }
+    return currencies, nil
+}
+
+// CreateTrustlineDir creates the trustline directory for a currency if it does not exist.
+// It returns true if the directory was created.
+func CreateTrustlineDir(cfg *config.Config, username, peerServerAddress, peerUsername, currency string) (bool, error) {
+    trustlineDir := GetTrustlineDir(cfg, username, peerServerAddress, peerUsername, currency)
+    exists, err := checkDirExists(trustlineDir)
+    if err != nil || exists {
+        return false, err
+    }
+    if err := os.MkdirAll(trustlineDir, 0755); err != nil {
+        return false, fmt.Errorf("error creating trustline directory %s: %w", trustlineDir, err)
+    }
+    return true, nil
+}
+
+// CheckTrustlineExists checks if the trustline directory for a currency exists
+func CheckTrustlineExists(cfg *config.Config, username, peerServerAddress, peerUsername, currency string) (bool, error) {
+    return checkDirExists(GetTrustlineDir(cfg, username, peerServerAddress, peerUsername, currency))
+}
+
+// GetAccounts retrieves the usernames of all accounts on the server
+func GetAccounts(cfg *config.Config) ([]string, error) {
+    accountsDir := filepath.Join(cfg.GetDataDir(), "accounts")
+    entries, err := os.ReadDir(accountsDir)
+    if err != nil {
+        return nil, fmt.Errorf("unable to read directory %s: %v", accountsDir, err)
+    }
+
+    var usernames []string
+    for _, entry := range entries {
+        if entry.IsDir() {
+            usernames = append(usernames, entry.Name())
+        }
+    }
+    return usernames, nil
 }
 
 // checkDirExists checks if a specific directory exists.
@@ -41,8 +108,8 @@ func checkDirExists(dirPath string) (bool, error) {
 }
 
 // CheckPeerExists checks if the peer directory exists
-func CheckPeerExists(dg *types.Datagram) (bool, error) {
-    peerDir := GetPeerDir(dg.Username, dg.PeerServerAddress, dg.PeerUsername)
+func CheckPeerExists(cfg *config.Config, dg *types.Datagram) (bool, error) {
+    peerDir := GetPeerDir(cfg, dg.Username, dg.PeerServerAddress, dg.PeerUsername)
     // Ensure the peer directory exists
     return checkDirExists(peerDir)
 }
@@ -0,0 +1,28 @@
+package database
+
+import (
+    "errors"
+    "os"
+    "ripple/config"
+)
+
+// GetPeerEncryption returns whether a peer has advertised that it accepts encrypted datagrams
+func GetPeerEncryption(cfg *config.Config, username, peerServerAddress, peerUsername string) (bool, error) {
+    peerDir := GetPeerDir(cfg, username, peerServerAddress, peerUsername)
+    data, err := ReadCachedFile(peerDir, "encryption.txt")
+    if errors.Is(err, os.ErrNotExist) {
+        return false, nil
+    } else if err != nil {
+        return false, err
+    }
+    return string(data) == "1", nil
+}
+
+// SetPeerEncryption records whether a peer accepts encrypted datagrams
+func SetPeerEncryption(cfg *config.Config, username, peerServerAddress, peerUsername string, enabled bool) error {
+    peerDir := GetPeerDir(cfg, username, peerServerAddress, peerUsername)
+    if enabled {
+        return WriteFile(peerDir, "encryption.txt", []byte("1"))
+    }
+    return WriteFile(peerDir, "encryption.txt", []byte("0"))
+}
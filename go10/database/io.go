@@ -1,8 +1,10 @@
 package database
 
 import (
+    "errors"
     "fmt"
     "io/ioutil"
+    "os"
     "path/filepath"
     "strconv"
 )
@@ -37,6 +39,20 @@ func GetUint32FromFile(dir, filename string) (uint32, error) {
     return uint32(value), nil
 }
 
+// GetUint64FromFile reads the contents of a file, parses it as a uint64, and returns the value.
+func GetUint64FromFile(dir, filename string) (uint64, error) {
+    data, err := ReadFile(dir, filename)
+    if err != nil {
+        return 0, err
+    }
+
+    value, err := strconv.ParseUint(string(data), 10, 64)
+    if err != nil {
+        return 0, fmt.Errorf("error parsing value from file %s: %v", filepath.Join(dir, filename), err)
+    }
+    return value, nil
+}
+
 // ReadTimeFromFile reads a Unix timestamp from a file and returns it as an int64.
 func ReadTimeFromFile(dir, filename string) (int64, error) {
     data, err := ReadFile(dir, filename)
@@ -51,11 +67,25 @@ func ReadTimeFromFile(dir, filename string) (int64, error) {
     return timestamp, nil
 }
 
+// ReadOptionalTimeFromFile reads a Unix timestamp from a file, and returns 0 if the file does not exist.
+func ReadOptionalTimeFromFile(dir, filename string) (int64, error) {
+    timestamp, err := ReadTimeFromFile(dir, filename)
+    if errors.Is(err, os.ErrNotExist) {
+        return 0, nil
+    }
+    return timestamp, err
+}
+
 // WriteUint32ToFile writes a uint32 value to a file.
 func WriteUint32ToFile(dir, filename string, value uint32) error {
     return WriteFile(dir, filename, []byte(fmt.Sprintf("%d", value)))
 }
 
+// WriteUint64ToFile writes a uint64 value to a file.
+func WriteUint64ToFile(dir, filename string, value uint64) error {
+    return WriteFile(dir, filename, []byte(fmt.Sprintf("%d", value)))
+}
+
 // WriteTimeToFile writes a Unix timestamp to a file.
 func WriteTimeToFile(dir, filename string, timestamp int64) error {
     return WriteFile(dir, filename, []byte(fmt.Sprintf("%d", timestamp)))
@@ -0,0 +1,292 @@
+package database
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "sync"
+    "ripple/config"
+)
+
+// The counters written for every accepted datagram are made durable with a journal rather than a synced file each.
+// A counter file is written through the page cache, and a record of the write is appended to the journal, which is
+// synced once for all the writes that arrive while the previous sync is in progress (group commit). Once the journal
+// has grown past journalCheckpointSize, the files written since the last checkpoint are synced and it is emptied.
+// After a crash, the journal is replayed over the counter files before anything else is read.
+const (
+    journalFilename       = "counters.journal"
+    journalCheckpointSize = 1024 * 1024
+    journalHeaderSize     = 4 + 2 + 2 // CRC-32 of the rest of the record, path length, data length
+)
+
+// counterJournal is the journal of a data directory, and the writes waiting for the next group commit
+type counterJournal struct {
+    mu         sync.Mutex
+    path       string
+    file       *os.File
+    size       int64
+    buffer     []byte
+    waiters    []chan error
+    dirty      map[string]bool // Files written since the last checkpoint
+    committing bool
+}
+
+// journals holds the journal of each data directory in use, so server instances with data directories of their
+// own commit their counters separately
+var journals = struct {
+    mu        sync.Mutex
+    byDataDir map[string]*counterJournal
+}{byDataDir: make(map[string]*counterJournal)}
+
+// getJournal returns the journal of the data directory of a configuration
+func getJournal(cfg *config.Config) *counterJournal {
+    journals.mu.Lock()
+    defer journals.mu.Unlock()
+
+    j, exists := journals.byDataDir[cfg.GetDataDir()]
+    if !exists {
+        j = &counterJournal{path: getJournalPath(cfg), dirty: make(map[string]bool)}
+        journals.byDataDir[cfg.GetDataDir()] = j
+    }
+    return j
+}
+
+// getJournalPath returns the path of the journal in the data directory
+func getJournalPath(cfg *config.Config) string {
+    return filepath.Join(cfg.GetDataDir(), journalFilename)
+}
+
+// RecoverJournal replays the journal left by a previous run over the counter files, syncs them and empties it.
+// It is called once at startup, before any datagram is validated.
+func RecoverJournal(cfg *config.Config) error {
+    journalPath := getJournalPath(cfg)
+    data, err := ioutil.ReadFile(journalPath)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    } else if err != nil {
+        return fmt.Errorf("error reading journal %s: %w", journalPath, err)
+    }
+
+    // The last write of each file is the one to restore. A record cut short by the crash ends the journal.
+    latest := make(map[string][]byte)
+    var order []string
+    for offset := 0; offset+journalHeaderSize <= len(data); {
+        pathLength := int(binary.BigEndian.Uint16(data[offset+4 : offset+6]))
+        dataLength := int(binary.BigEndian.Uint16(data[offset+6 : offset+8]))
+        end := offset + journalHeaderSize + pathLength + dataLength
+        if end > len(data) || crc32.ChecksumIEEE(data[offset+4:end]) != binary.BigEndian.Uint32(data[offset:offset+4]) {
+            break
+        }
+        path := string(data[offset+journalHeaderSize : offset+journalHeaderSize+pathLength])
+        if _, exists := latest[path]; !exists {
+            order = append(order, path)
+        }
+        latest[path] = data[offset+journalHeaderSize+pathLength : end]
+        offset = end
+    }
+
+    for _, path := range order {
+        filePath := filepath.Join(cfg.GetDataDir(), path)
+        if err := WriteFileDurable(filepath.Dir(filePath), filepath.Base(filePath), latest[path]); err != nil {
+            return fmt.Errorf("error restoring %s from the journal: %w", filePath, err)
+        }
+        invalidateCachedFile(filePath)
+    }
+    if len(order) > 0 {
+        log.Printf("Restored %d counter files from the journal", len(order))
+    }
+
+    if err := os.Remove(journalPath); err != nil {
+        return fmt.Errorf("error removing journal %s: %w", journalPath, err)
+    }
+    return syncDir(cfg.GetDataDir())
+}
+
+// journalWrite is one file written through the journal
+type journalWrite struct {
+    dir      string
+    filename string
+    data     []byte
+}
+
+// WriteCachedFileJournaled writes a file through the cache, and only returns once the write is durable in the journal.
+func WriteCachedFileJournaled(cfg *config.Config, dir, filename string, data []byte) error {
+    return writeJournaled(cfg, journalWrite{dir, filename, data})
+}
+
+// WriteCachedUint32Journaled writes a uint32 value to a file through the cache, and only returns once the write is durable in the journal.
+func WriteCachedUint32Journaled(cfg *config.Config, dir, filename string, value uint32) error {
+    return WriteCachedFileJournaled(cfg, dir, filename, []byte(fmt.Sprintf("%d", value)))
+}
+
+// writeJournaled writes files through the cache, in order, and waits for the group commit that makes them all durable
+func writeJournaled(cfg *config.Config, writes ...journalWrite) error {
+    var records []byte
+    var filePaths []string
+    for _, write := range writes {
+        filePath := filepath.Join(write.dir, write.filename)
+        path, err := filepath.Rel(cfg.GetDataDir(), filePath)
+        if err != nil {
+            return fmt.Errorf("error locating %s in the data directory: %w", filePath, err)
+        }
+
+        // Replace the file, without waiting for it to reach the disk
+        if err := replaceFile(write.dir, write.filename, write.data); err != nil {
+            invalidateCachedFile(filePath)
+            return err
+        }
+        if info, err := os.Stat(filePath); err == nil {
+            fileCache.mu.Lock()
+            fileCache.entries[filePath] = cacheEntry{data: write.data, size: info.Size(), modTime: info.ModTime()}
+            fileCache.mu.Unlock()
+        } else {
+            invalidateCachedFile(filePath)
+        }
+
+        record := make([]byte, journalHeaderSize, journalHeaderSize+len(path)+len(write.data))
+        binary.BigEndian.PutUint16(record[4:6], uint16(len(path)))
+        binary.BigEndian.PutUint16(record[6:8], uint16(len(write.data)))
+        record = append(append(record, path...), write.data...)
+        binary.BigEndian.PutUint32(record[:4], crc32.ChecksumIEEE(record[4:]))
+        records = append(records, record...)
+        filePaths = append(filePaths, filePath)
+    }
+
+    done := make(chan error, 1)
+    j := getJournal(cfg)
+    j.mu.Lock()
+    j.buffer = append(j.buffer, records...)
+    j.waiters = append(j.waiters, done)
+    for _, filePath := range filePaths {
+        j.dirty[filePath] = true
+    }
+    if !j.committing {
+        j.committing = true
+        go j.commitLoop()
+    }
+    j.mu.Unlock()
+
+    return <-done
+}
+
+// commitLoop appends the buffered records to the journal and syncs it, one batch at a time, until no writes are waiting
+func (j *counterJournal) commitLoop() {
+    for {
+        j.mu.Lock()
+        if len(j.buffer) == 0 {
+            j.committing = false
+            j.mu.Unlock()
+            return
+        }
+        batch, waiters := j.buffer, j.waiters
+        j.buffer, j.waiters = nil, nil
+        j.mu.Unlock()
+
+        err := j.commit(batch)
+        for _, done := range waiters {
+            done <- err
+        }
+    }
+}
+
+// commit appends a batch of records to the journal and syncs it, and checkpoints the journal once it is large
+func (j *counterJournal) commit(batch []byte) error {
+    if j.file == nil {
+        file, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+        if err != nil {
+            return fmt.Errorf("error opening journal: %w", err)
+        }
+        j.file = file
+    }
+
+    if _, err := j.file.Write(batch); err != nil {
+        return fmt.Errorf("error writing journal: %w", err)
+    }
+    if err := j.file.Sync(); err != nil {
+        return fmt.Errorf("error syncing journal: %w", err)
+    }
+    j.size += int64(len(batch))
+
+    if j.size < journalCheckpointSize {
+        return nil
+    }
+    if err := j.checkpoint(); err != nil {
+        // The journal still holds every write, so it is only checkpointed again later
+        log.Printf("Error checkpointing the counter journal: %v", err)
+    }
+    return nil
+}
+
+// checkpoint syncs the files written since the last checkpoint and empties the journal. Writes recorded after the
+// files to sync were taken are appended to the emptied journal, so none of them is left only in the page cache.
+func (j *counterJournal) checkpoint() error {
+    j.mu.Lock()
+    dirty := j.dirty
+    j.dirty = make(map[string]bool)
+    j.mu.Unlock()
+
+    restore := func(err error) error {
+        j.mu.Lock()
+        for filePath := range dirty {
+            j.dirty[filePath] = true
+        }
+        j.mu.Unlock()
+        return err
+    }
+
+    dirs := make(map[string]bool)
+    for filePath := range dirty {
+        if err := syncFile(filePath); err != nil {
+            return restore(err)
+        }
+        dirs[filepath.Dir(filePath)] = true
+    }
+    for dir := range dirs {
+        if err := syncDir(dir); err != nil {
+            return restore(err)
+        }
+    }
+
+    if err := j.file.Truncate(0); err != nil {
+        return restore(fmt.Errorf("error truncating journal: %w", err))
+    }
+    if err := j.file.Sync(); err != nil {
+        return restore(fmt.Errorf("error syncing journal: %w", err))
+    }
+    j.size = 0
+    return nil
+}
+
+// replaceFile writes a file to a temporary file that is renamed over it, so a crash of the process leaves either
+// the old or the new contents. It does not wait for the disk.
+func replaceFile(dir, filename string, data []byte) error {
+    filePath := filepath.Join(dir, filename)
+    tempPath := filePath + ".tmp"
+    if err := ioutil.WriteFile(tempPath, data, 0644); err != nil {
+        return fmt.Errorf("error writing file %s: %w", tempPath, err)
+    }
+    if err := os.Rename(tempPath, filePath); err != nil {
+        return fmt.Errorf("error renaming file %s: %w", tempPath, err)
+    }
+    return nil
+}
+
+// syncFile syncs a file that has already been written
+func syncFile(filePath string) error {
+    file, err := os.Open(filePath)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil // Removed since, with the directory it was in
+    } else if err != nil {
+        return fmt.Errorf("error opening file %s: %w", filePath, err)
+    }
+    defer file.Close()
+    if err := file.Sync(); err != nil {
+        return fmt.Errorf("error syncing file %s: %w", filePath, err)
+    }
+    return nil
+}
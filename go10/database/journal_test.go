@@ -0,0 +1,168 @@
+package database
+
+import (
+    "fmt"
+    "os"
+    "sync"
+    "testing"
+    "ripple/config"
+    "ripple/testutil"
+    "ripple/types"
+)
+
+// newJournalTestConfig creates a data directory with accounts that each have one peer account
+func newJournalTestConfig(t *testing.T, accounts int) *config.Config {
+    t.Helper()
+
+    datadir := testutil.NewDataDir(t, "alpha.test")
+    for i := 0; i < accounts; i++ {
+        testutil.AddPeer(t, datadir, fmt.Sprintf("user%d", i), "beta.test", "bob", nil, 0)
+    }
+    return testutil.LoadConfig(t, datadir)
+}
+
+// testPeerDatagram returns a datagram from the peer account of an account in the tests
+func testPeerDatagram(account int, counter uint32) *types.Datagram {
+    return &types.Datagram{Username: fmt.Sprintf("user%d", account), PeerServerAddress: "beta.test", PeerUsername: "bob", Counter: counter}
+}
+
+func TestJournalGroupCommit(t *testing.T) {
+    cfg := newJournalTestConfig(t, 20)
+
+    var wg sync.WaitGroup
+    errs := make(chan error, 20*10)
+    for account := 0; account < 20; account++ {
+        wg.Add(1)
+        go func(account int) {
+            defer wg.Done()
+            for counter := uint32(1); counter <= 10; counter++ {
+                errs <- AdvanceCounterIn(cfg, testPeerDatagram(account, counter), uint64(counter))
+            }
+        }(account)
+    }
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    for account := 0; account < 20; account++ {
+        dg := testPeerDatagram(account, 0)
+        counterIn, err := GetCounterIn(cfg, dg)
+        if err != nil {
+            t.Fatal(err)
+        }
+        window, err := GetWindowIn(cfg, dg, counterIn)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if counterIn != 10 || window != 10 {
+            t.Errorf("account %d: counter_in %d and window %d, want 10 and 10", account, counterIn, window)
+        }
+    }
+
+    info, err := os.Stat(getJournalPath(cfg))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if info.Size() == 0 {
+        t.Error("nothing recorded in the journal")
+    }
+
+    // A checkpoint syncs the counter files and empties the journal
+    j := getJournal(cfg)
+    if err := j.checkpoint(); err != nil {
+        t.Fatal(err)
+    }
+    if info, err := os.Stat(getJournalPath(cfg)); err != nil || info.Size() != 0 {
+        t.Errorf("journal not emptied by a checkpoint: %v", err)
+    }
+    j.mu.Lock()
+    dirty := len(j.dirty)
+    j.mu.Unlock()
+    if dirty != 0 {
+        t.Errorf("%d files left to sync after a checkpoint", dirty)
+    }
+}
+
+func TestRecoverJournal(t *testing.T) {
+    cfg := newJournalTestConfig(t, 2)
+
+    for counter := uint32(1); counter <= 3; counter++ {
+        if err := AdvanceCounterIn(cfg, testPeerDatagram(0, counter), 1); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := AdvanceCounterIn(cfg, testPeerDatagram(1, 7), 1); err != nil {
+        t.Fatal(err)
+    }
+
+    // Copy the journal, with a record cut short by a crash at the end, and lose the counter files written
+    // through the page cache, as a crash of the machine would
+    journal, err := os.ReadFile(getJournalPath(cfg))
+    if err != nil {
+        t.Fatal(err)
+    }
+    journal = append(journal, 0, 1, 2, 3, 0, 10)
+    crashed := newJournalTestConfig(t, 2)
+    if err := os.WriteFile(getJournalPath(crashed), journal, 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    if err := RecoverJournal(crashed); err != nil {
+        t.Fatal(err)
+    }
+    for account, want := range []uint32{3, 7} {
+        counterIn, err := GetCounterIn(crashed, testPeerDatagram(account, 0))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if counterIn != want {
+            t.Errorf("account %d: counter_in %d after recovery, want %d", account, counterIn, want)
+        }
+    }
+    if _, err := os.Stat(getJournalPath(crashed)); !os.IsNotExist(err) {
+        t.Errorf("journal left after recovery: %v", err)
+    }
+
+    // Without a journal there is nothing to recover
+    if err := RecoverJournal(crashed); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestNextCounterOutReservation(t *testing.T) {
+    cfg := newJournalTestConfig(t, 1)
+    peerDir := GetPeerDir(cfg, "user0", "beta.test", "bob")
+
+    for want := uint32(0); want < 3; want++ {
+        counter, err := NextCounterOut(cfg, "user0", "beta.test", "bob")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if counter != want {
+            t.Errorf("counter_out %d, want %d", counter, want)
+        }
+    }
+    stored, err := GetCachedUint32(peerDir, "counter_out.txt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stored != CounterOutReservation {
+        t.Errorf("counter_out.txt holds %d, want the end of the reservation %d", stored, CounterOutReservation)
+    }
+
+    // After a restart, the counters left in the reservation are skipped
+    counterOutReservations.mu.Lock()
+    delete(counterOutReservations.entries, peerDir)
+    counterOutReservations.mu.Unlock()
+    counter, err := NextCounterOut(cfg, "user0", "beta.test", "bob")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if counter != CounterOutReservation {
+        t.Errorf("counter_out %d after a restart, want %d", counter, CounterOutReservation)
+    }
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+
+    "ripple/types"
+    "ripple/config"
+)
+
+// AccountPolicy holds the limits an admin sets for an account in policy.txt in the account directory.
+// Zero limits and an empty list of allowed commands mean no limit.
+type AccountPolicy struct {
+    MaxTrustline    types.Amount  // Largest outbound trustline the account may extend, in any currency
+    MaxPayment      types.Amount  // Largest payment the account may make or request
+    MaxPeers        int           // Most peers the account may extend trustlines to
+    AllowedCommands map[byte]bool // Client commands the account may use
+    Frozen          bool          // No trustline changes, payments or routing through the account
+}
+
+// AllowsCommand checks whether the policy allows a client command
+func (policy *AccountPolicy) AllowsCommand(command byte) bool {
+    return len(policy.AllowedCommands) == 0 || policy.AllowedCommands[command]
+}
+
+// CheckTrustline checks whether the policy allows an outbound trustline amount
+func (policy *AccountPolicy) CheckTrustline(amount types.Amount) error {
+    if policy.Frozen {
+        return fmt.Errorf("the account is frozen")
+    }
+    if policy.MaxTrustline != 0 && amount > policy.MaxTrustline {
+        return fmt.Errorf("trustline %d exceeds the maximum of %d set by the account policy", amount, policy.MaxTrustline)
+    }
+    return nil
+}
+
+// CheckPayment checks whether the policy allows a payment amount
+func (policy *AccountPolicy) CheckPayment(amount types.Amount) error {
+    if policy.Frozen {
+        return fmt.Errorf("the account is frozen")
+    }
+    if policy.MaxPayment != 0 && amount > policy.MaxPayment {
+        return fmt.Errorf("payment %d exceeds the maximum of %d set by the account policy", amount, policy.MaxPayment)
+    }
+    return nil
+}
+
+// LoadAccountPolicy loads the policy of an account, with one setting and its value per line. An account without
+// a policy file has no limits.
+func LoadAccountPolicy(cfg *config.Config, username string) (*AccountPolicy, error) {
+    policy := &AccountPolicy{}
+    accountDir := GetAccountDir(cfg, username)
+    data, err := ReadCachedFile(accountDir, "policy.txt")
+    if errors.Is(err, os.ErrNotExist) {
+        return policy, nil
+    } else if err != nil {
+        return nil, err
+    }
+
+    policyPath := filepath.Join(accountDir, "policy.txt")
+    for _, line := range strings.Split(string(data), "\n") {
+        fields := strings.Fields(line)
+        if len(fields) == 0 {
+            continue
+        }
+        if len(fields) != 2 {
+            return nil, fmt.Errorf("invalid line in %s: %q", policyPath, line)
+        }
+
+        setting, value := fields[0], fields[1]
+        switch setting {
+        case "max_trustline", "max_payment":
+            amount, err := strconv.ParseUint(value, 10, 64)
+            if err != nil {
+                return nil, fmt.Errorf("invalid %s in %s: %w", setting, policyPath, err)
+            }
+            if setting == "max_trustline" {
+                policy.MaxTrustline = types.Amount(amount)
+            } else {
+                policy.MaxPayment = types.Amount(amount)
+            }
+        case "max_peers":
+            if policy.MaxPeers, err = strconv.Atoi(value); err != nil {
+                return nil, fmt.Errorf("invalid %s in %s: %w", setting, policyPath, err)
+            }
+        case "allowed_commands":
+            policy.AllowedCommands = make(map[byte]bool)
+            for _, command := range strings.Split(value, ",") {
+                parsed, err := strconv.ParseUint(command, 10, 7)
+                if err != nil {
+                    return nil, fmt.Errorf("invalid client command %q in %s: %w", command, policyPath, err)
+                }
+                policy.AllowedCommands[byte(parsed)] = true
+            }
+        case "frozen":
+            if policy.Frozen, err = strconv.ParseBool(value); err != nil {
+                return nil, fmt.Errorf("invalid %s in %s: %w", setting, policyPath, err)
+            }
+        default:
+            return nil, fmt.Errorf("unknown setting %q in %s", setting, policyPath)
+        }
+    }
+    return policy, nil
+}
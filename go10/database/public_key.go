@@ -0,0 +1,29 @@
+package database
+
+import (
+    "crypto/ed25519"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+    "ripple/config"
+)
+
+// LoadPeerPublicKey loads the identity public key of a peer's server, stored hex encoded in public_key.txt.
+// It returns nil if the peer has no public key.
+func LoadPeerPublicKey(cfg *config.Config, username, peerServerAddress, peerUsername string) (ed25519.PublicKey, error) {
+    peerDir := GetPeerDir(cfg, username, peerServerAddress, peerUsername)
+    data, err := ReadCachedFile(peerDir, "public_key.txt")
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+
+    publicKey, err := hex.DecodeString(strings.TrimSpace(string(data)))
+    if err != nil || len(publicKey) != ed25519.PublicKeySize {
+        return nil, fmt.Errorf("invalid public key for peer %s at %s", peerUsername, peerServerAddress)
+    }
+    return ed25519.PublicKey(publicKey), nil
+}
@@ -1,10 +1,13 @@
 package database
 
-import "fmt"
+import (
+    "fmt"
+    "ripple/config"
+)
 
-// loadSecretKeyFromDir loads the secret key from the specified directory.
+// loadSecretKeyFromDir loads the secret key from the specified directory, through the cache.
 func loadSecretKeyFromDir(dir string) ([]byte, error) {
-    secretKey, err := ReadFile(dir, "secretkey.txt")
+    secretKey, err := ReadCachedFile(dir, "secretkey.txt")
     if err != nil {
         return nil, fmt.Errorf("error reading secret key: %w", err)
     }
@@ -12,13 +15,13 @@ func loadSecretKeyFromDir(dir string) ([]byte, error) {
 }
 
 // LoadSecretKey loads the secret key for the given username.
-func LoadSecretKey(username string) ([]byte, error) {
-    accountDir := GetAccountDir(username)
+func LoadSecretKey(cfg *config.Config, username string) ([]byte, error) {
+    accountDir := GetAccountDir(cfg, username)
     return loadSecretKeyFromDir(accountDir)
 }
 
 // LoadPeerSecretKey loads the peer's secret key.
-func LoadPeerSecretKey(username, peerServerAddress, peerUsername string) ([]byte, error) {
-    peerDir := GetPeerDir(username, peerServerAddress, peerUsername)
+func LoadPeerSecretKey(cfg *config.Config, username, peerServerAddress, peerUsername string) ([]byte, error) {
+    peerDir := GetPeerDir(cfg, username, peerServerAddress, peerUsername)
     return loadSecretKeyFromDir(peerDir)
 }
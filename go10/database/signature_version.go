@@ -0,0 +1,31 @@
+package database
+
+import (
+    "errors"
+    "os"
+    "strconv"
+    "ripple/types"
+    "ripple/config"
+)
+
+// GetPeerSignatureVersion returns the highest signature version a peer has shown it verifies, the legacy scheme until it has shown any
+func GetPeerSignatureVersion(cfg *config.Config, username, peerServerAddress, peerUsername string) (byte, error) {
+    peerDir := GetPeerDir(cfg, username, peerServerAddress, peerUsername)
+    data, err := ReadCachedFile(peerDir, "signature_version.txt")
+    if errors.Is(err, os.ErrNotExist) {
+        return types.SignatureVersionLegacy, nil
+    } else if err != nil {
+        return 0, err
+    }
+    version, err := strconv.ParseUint(string(data), 10, 8)
+    if err != nil {
+        return 0, err
+    }
+    return byte(version), nil
+}
+
+// SetPeerSignatureVersion records the highest signature version a peer has shown it verifies
+func SetPeerSignatureVersion(cfg *config.Config, username, peerServerAddress, peerUsername string, version byte) error {
+    peerDir := GetPeerDir(cfg, username, peerServerAddress, peerUsername)
+    return WriteFile(peerDir, "signature_version.txt", []byte(strconv.Itoa(int(version))))
+}
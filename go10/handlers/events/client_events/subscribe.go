@@ -0,0 +1,31 @@
+package client_events
+
+import (
+    "log"
+
+    "ripple/types"
+    "ripple/server"
+)
+
+// Subscribe handles the client request to receive pushed events at the address it sends from.
+// Arguments[0] set to 1 subscribes or renews the subscription, 0 unsubscribes. The client renews it
+// before SubscriptionTimeout, which also keeps its NAT forwarding events from the server.
+func Subscribe(srv *server.Server, session types.Session) {
+    datagram := session.Datagram
+
+    if datagram.Arguments[0] == 0 {
+        srv.Endpoint.Unsubscribe(datagram.Username)
+        if err := srv.Endpoint.SendSuccessResponse(session.Addr, []byte("Unsubscribed from events.")); err != nil {
+            log.Printf("Failed to send success response in Subscribe for user %s: %v", datagram.Username, err)
+        }
+        return
+    }
+
+    srv.Endpoint.Subscribe(datagram.Username, session.Addr)
+    if err := srv.Endpoint.SendSuccessResponse(session.Addr, []byte("Subscribed to events.")); err != nil {
+        log.Printf("Failed to send success response in Subscribe for user %s: %v", datagram.Username, err)
+        return
+    }
+
+    log.Printf("User %s subscribed to events at %s.", datagram.Username, session.Addr.String())
+}
@@ -0,0 +1,25 @@
+package events
+
+import (
+    "encoding/binary"
+    "ripple/types"
+    "ripple/server"
+)
+
+// Event types, the first byte of a pushed event
+const (
+    EventTrustlineIn = 1 // A peer changed the trustline it extends to the account
+    EventPeerClosed  = 2 // A peer closed its relationship with the account
+)
+
+// PushPeerEvent pushes an event about a peer to the client subscribed for the account a datagram is for, if any.
+// The event is the event type, an amount, the currency code, the peer username, and the peer server address,
+// which is last since it may be longer than 32 bytes.
+func PushPeerEvent(srv *server.Server, datagram *types.Datagram, eventType byte, amount types.Amount, currency string) {
+    event := []byte{eventType}
+    event = binary.BigEndian.AppendUint64(event, uint64(amount))
+    event = append(event, types.CurrencyToBytes(currency)...)
+    event = append(event, types.PadStringTo32Bytes(datagram.PeerUsername)...)
+    event = append(event, datagram.PeerServerAddress...)
+    srv.Endpoint.PushEvent(datagram.Username, event)
+}
@@ -3,27 +3,27 @@ package handlers
 import (
     "fmt"
     "ripple/auth"
-    "ripple/comm"
     "ripple/types"
+    "ripple/server"
 )
 
 // PrepareDatagramWithoutCommand prepares common Datagram fields and increments counter_out.
-func PrepareDatagramWithoutCommand(username, peerServerAddress, peerUsername string) (*types.Datagram, error) {
+func PrepareDatagramWithoutCommand(srv *server.Server, username, peerServerAddress, peerUsername string) (*types.Datagram, error) {
     // Retrieve and increment the counter_out value
-    counterOut, err := auth.GetAndIncrementCounterOut(username, peerServerAddress, peerUsername)
+    counterOut, err := auth.GetAndIncrementCounterOut(srv.Config, username, peerServerAddress, peerUsername)
     if err != nil {
         return nil, fmt.Errorf("error handling counter_out for user %s: %v", username, err)
     }
 
-    dg := types.NewDatagram(peerUsername, username, counterOut)
+    dg := types.NewDatagram(peerUsername, username, srv.Config.GetServerAddress(), counterOut)
 
     return dg, nil
 }
 
 // PrepareDatagram prepares a datagram with all necessary fields including the command and arguments.
-func PrepareDatagram(command byte, username, peerServerAddress, peerUsername string, arguments []byte) (*types.Datagram, error) {
+func PrepareDatagram(srv *server.Server, command byte, username, peerServerAddress, peerUsername string, arguments []byte) (*types.Datagram, error) {
     // Prepare the new datagram
-    datagram, err := PrepareDatagramWithoutCommand(username, peerServerAddress, peerUsername)
+    datagram, err := PrepareDatagramWithoutCommand(srv, username, peerServerAddress, peerUsername)
     if err != nil {
         return nil, fmt.Errorf("Failed to prepare datagram: %v", err)
     }
@@ -34,21 +34,26 @@ func PrepareDatagram(command byte, username, peerServerAddress, peerUsername str
 }
 
 // PrepareDatagramResponse calls PrepareDatagram with fields from an incoming datagram
-func PrepareDatagramResponse(datagram *types.Datagram) (*types.Datagram, error) {
-    return PrepareDatagramWithoutCommand(datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername)
+func PrepareDatagramResponse(srv *server.Server, datagram *types.Datagram) (*types.Datagram, error) {
+    return PrepareDatagramWithoutCommand(srv, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername)
 }
 
-// PrepareAndSendDatagram prepares, signs, and sends a datagram to a specified peer.
-func PrepareAndSendDatagram(command byte, username, serverAddress, peerUsername string, arguments []byte) error {
+// PrepareAndSendDatagram prepares and signs a datagram, and queues it in the outbox of a specified peer.
+func PrepareAndSendDatagram(srv *server.Server, command byte, username, serverAddress, peerUsername string, arguments []byte) error {
+    return PrepareAndSendDatagramWithCallback(srv, command, username, serverAddress, peerUsername, arguments, nil)
+}
+
+// PrepareAndSendDatagramWithCallback is PrepareAndSendDatagram, calling onDone once the datagram is delivered or given up on.
+func PrepareAndSendDatagramWithCallback(srv *server.Server, command byte, username, serverAddress, peerUsername string, arguments []byte, onDone func(error)) error {
     // Prepare the datagram with the command and arguments
-    newDatagram, err := PrepareDatagram(command, username, serverAddress, peerUsername, arguments)
+    newDatagram, err := PrepareDatagram(srv, command, username, serverAddress, peerUsername, arguments)
     if err != nil {
         return fmt.Errorf("Failed to prepare datagram: %v", err)
     }
 
-    // Sign and send the datagram to the target peer
-    if err := comm.SignAndSendDatagram(newDatagram, serverAddress); err != nil {
-        return fmt.Errorf("Failed to sign and send datagram to %s at %s: %v", peerUsername, serverAddress, err)
+    // Sign the datagram and queue it for the target peer
+    if err := srv.Outbox.SealAndSend(newDatagram, serverAddress, onDone); err != nil {
+        return fmt.Errorf("Failed to queue datagram to %s at %s: %v", peerUsername, serverAddress, err)
     }
 
     return nil
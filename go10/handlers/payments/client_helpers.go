@@ -3,29 +3,39 @@ package payments
 import (
     "ripple/types"
     "ripple/pathfinding"
+    "ripple/server"
 )
 
-// serializePaymentDetails constructs a byte array from the payment details
-func serializePaymentDetails(payment *pathfinding.Payment, amount uint32) []byte {
+// serializePaymentDetails constructs a byte array from the payment details, with the amount sized by the
+// argument layout version of the client request. The current layout also holds the scale of the currency.
+func serializePaymentDetails(srv *server.Server, payment *pathfinding.Payment, amount types.Amount, version byte) ([]byte, error) {
+    amountBytes, err := types.AmountToBytes(amount, version)
+    if err != nil {
+        return nil, err
+    }
     buffer := concatNameAndServer(payment.Counterpart.Username, payment.Counterpart.ServerAddress)
     buffer = append(buffer, payment.InOrOut)
-    amountAndNonce := append(types.Uint32ToBytes(amount), types.Uint32ToBytes(payment.Nonce)...)
-    buffer = append(buffer, amountAndNonce...)
-    return buffer
+    buffer = append(buffer, amountBytes...)
+    buffer = append(buffer, types.Uint32ToBytes(payment.Nonce)...)
+    buffer = append(buffer, types.CurrencyToBytes(payment.Currency)...)
+    if version != types.ArgumentsVersionLegacy {
+        buffer = append(buffer, srv.Config.GetCurrencyScale(payment.Currency))
+    }
+    return buffer, nil
 }
 
 // Wrapper function to fetch and serialize payment details
-func FetchAndSerializePaymentDetails(username string) []byte {
+func FetchAndSerializePaymentDetails(srv *server.Server, username string, version byte) ([]byte, error) {
     // Use the existing Find method from PathManager to retrieve the account
-    account := pathfinding.GetPathManager().Find(username)
+    account := srv.Paths.Find(username)
     if account == nil || account.Payment == nil {
-        return nil // Return nil if no account or no payment is found
+        return nil, nil // Return nil if no account or no payment is found
     }
     // Find the Path using the identifier in the Payment
     path := account.Find(account.Payment.Identifier)
     if path == nil {
-        return nil // Return nil if no Path is found for the payment
+        return nil, nil // Return nil if no Path is found for the payment
     }
 
-    return serializePaymentDetails(account.Payment, path.Amount)
+    return serializePaymentDetails(srv, account.Payment, path.Amount, version)
 }
@@ -4,24 +4,29 @@ import (
     "log"
     "ripple/handlers/payments"
     "ripple/types"
-    "ripple/comm"
+    "ripple/server"
 
 )
 
 // GetPayment handles the command to retrieve payment parameters.
-func GetPayment(session types.Session) {
+func GetPayment(srv *server.Server, session types.Session) {
 
     // Extract username from the datagram
     username := session.Datagram.Username
 
     // Retrieve and serialize payment details using the wrapper method
-    paymentDetails := payments.FetchAndSerializePaymentDetails(username)
+    paymentDetails, err := payments.FetchAndSerializePaymentDetails(srv, username, types.GetArgumentsVersion(session.Datagram))
+    if err != nil {
+        log.Printf("Failed to serialize payment details for user %s: %v", username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Payment amount does not fit the request layout.")
+        return
+    }
     if paymentDetails == nil {
         paymentDetails = []byte{}  // Send an empty response if no payment details
     }
 
     // Send the payment details as a success response
-    if err := comm.SendSuccessResponse(session.Addr, paymentDetails); err != nil {
+    if err := srv.Endpoint.SendSuccessResponse(session.Addr, paymentDetails); err != nil {
         log.Printf("Failed to send payment details to client for user %s: %v", username, err)
         return
     }
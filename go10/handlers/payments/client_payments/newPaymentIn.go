@@ -3,9 +3,10 @@ package client_payments
 import (
     "ripple/types"
     "ripple/handlers/payments/payment_operations"
+    "ripple/server"
 )
 
 // NewPaymentIn handles the command to initiate a new incoming payment.
-func NewPaymentIn(session types.Session) {
-    payment_operations.NewPayment(session, types.Incoming)
+func NewPaymentIn(srv *server.Server, session types.Session) {
+    payment_operations.NewPayment(srv, session, types.Incoming)
 }
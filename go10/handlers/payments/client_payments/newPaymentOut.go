@@ -3,9 +3,10 @@ package client_payments
 import (
     "ripple/types"
     "ripple/handlers/payments/payment_operations"
+    "ripple/server"
 )
 
 // NewPaymentOut handles the command to initiate a new outgoing payment.
-func NewPaymentOut(session types.Session) {
-    payment_operations.NewPayment(session, types.Outgoing)
+func NewPaymentOut(srv *server.Server, session types.Session) {
+    payment_operations.NewPayment(srv, session, types.Outgoing)
 }
@@ -1,19 +1,22 @@
 package payments
 
 import (
+	"encoding/binary"
 	"fmt"
 	"crypto/sha256"
 	"ripple/types"
 	"ripple/pathfinding"
-	"ripple/config"
+	"ripple/server"
 )
 
 func concatNameAndServer(username, serverAddress string) []byte {
-  return append(types.PadStringTo32Bytes(username), types.PadStringTo32Bytes(serverAddress)...)
+  return append(types.PadStringTo32Bytes(username), types.PadServerAddress(serverAddress)...)
 }
 
-func generatePaymentIdentifier(dg *types.Datagram, inOrOut byte) string {
-  user := concatNameAndServer(dg.Username, config.GetServerAddress())
+// generatePaymentIdentifier hashes both accounts with the payment details. The details are laid out the same
+// way whatever the argument layout version, so both ends arrive at the same identifier.
+func generatePaymentIdentifier(srv *server.Server, dg *types.Datagram, inOrOut byte, amount types.Amount, nonce uint32, currency string) string {
+  user := concatNameAndServer(dg.Username, srv.Config.GetServerAddress())
   peer := concatNameAndServer(dg.PeerUsername, dg.PeerServerAddress)
   
   var preimage []byte
@@ -23,7 +26,9 @@ func generatePaymentIdentifier(dg *types.Datagram, inOrOut byte) string {
   } else {
     preimage = append(user, peer...)
   }
-  preimage = append(preimage, dg.Arguments[:8]...)
+  preimage = binary.BigEndian.AppendUint64(preimage, uint64(amount))
+  preimage = append(preimage, types.Uint32ToBytes(nonce)...)
+  preimage = append(preimage, types.CurrencyToBytes(currency)...)
   hash := sha256.Sum256(preimage)
   
   return fmt.Sprintf("%x", hash[:])
@@ -31,12 +36,20 @@ func generatePaymentIdentifier(dg *types.Datagram, inOrOut byte) string {
 
 
 // GenerateAndInitiatePayment handles the generation of the payment identifier and initiation of the payment.
-func GenerateAndInitiatePayment(datagram *types.Datagram, inOrOut byte) {
+// The arguments hold the amount, the nonce and the currency code, in that order.
+func GenerateAndInitiatePayment(srv *server.Server, datagram *types.Datagram, inOrOut byte) error {
+    reader := types.NewArgumentReader(datagram)
+    amount := reader.Amount()
+    nonce := reader.Uint32()
+    currency, err := reader.Currency()
+    if err != nil {
+        return err
+    }
+
     // Generate the Payment struct for an incoming payment
-    identifier := generatePaymentIdentifier(datagram, inOrOut)
-    nonce := types.BytesToUint32(datagram.Arguments[4:8])
-    payment := pathfinding.NewPayment(datagram, identifier, inOrOut, nonce)
-    amount := types.BytesToUint32(datagram.Arguments[0:4])
+    identifier := generatePaymentIdentifier(srv, datagram, inOrOut, amount, nonce, currency)
+    payment := pathfinding.NewPayment(datagram, identifier, inOrOut, nonce, currency)
     // Initiate the incoming payment using the constructed Payment struct
-    pathfinding.GetPathManager().InitiatePayment(datagram.Username, payment, amount)
+    srv.Paths.InitiatePayment(datagram.Username, payment, amount)
+    return nil
 }
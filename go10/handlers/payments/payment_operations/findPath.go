@@ -1,20 +1,33 @@
 package payment_operations
 
 import (
-    "encoding/binary"
     "log"
     "ripple/pathfinding"
     "ripple/types"
+    "ripple/server"
 )
 
 // FindPath handles the common logic for processing FindPath requests.
-func FindPath(datagram *types.Datagram, inOrOut byte) {
-    // Extract the path identifier and amount from datagram arguments
-    pathIdentifier := types.BytesToString(datagram.Arguments[:32])
-    pathAmount := binary.BigEndian.Uint32(datagram.Arguments[32:36])
+// Accounts whose account policy does not allow the path amount do not route it.
+func FindPath(srv *server.Server, datagram *types.Datagram, inOrOut byte) {
+    // Extract the path identifier, amount and currency from datagram arguments
+    reader := types.NewArgumentReader(datagram)
+    pathIdentifier := reader.Identifier()
+    pathAmount := reader.Amount()
+    pathCurrency, err := reader.Currency()
+    if err != nil {
+        log.Printf("Invalid currency in FindPath for user %s: %v", datagram.Username, err)
+        return
+    }
+
+    // Check the path amount against the account policy
+    if err := CheckRoutingPolicy(srv, datagram.Username, pathAmount); err != nil {
+        log.Printf("Not routing path finding request: %v", err)
+        return
+    }
 
-    // Check if the trustline (incoming or outgoing) is sufficient for the path amount
-    sufficient, err := CheckTrustlineSufficient(datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername, pathAmount, inOrOut)
+    // Check if the trustline (incoming or outgoing) in the path currency is sufficient for the path amount
+    sufficient, err := CheckTrustlineSufficient(srv, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername, pathCurrency, pathAmount, inOrOut)
     if err != nil {
         log.Printf("Error checking trustline: %v", err)
         return
@@ -25,7 +38,7 @@ func FindPath(datagram *types.Datagram, inOrOut byte) {
     }
 
     // Find the account using the username from the datagram
-    account := pathfinding.GetPathManager().Find(datagram.Username)
+    account := srv.Paths.Find(datagram.Username)
     if account == nil {
         log.Printf("Account not found for user: %s", datagram.Username)
         return
@@ -37,18 +50,18 @@ func FindPath(datagram *types.Datagram, inOrOut byte) {
         // Path is not found, add the new path using the Add method
         newPeer := pathfinding.NewPeerAccount(datagram.PeerUsername, datagram.PeerServerAddress)
         if inOrOut == types.Outgoing {
-            path = account.Add(pathIdentifier, pathAmount, newPeer, pathfinding.PeerAccount{})
+            path = account.Add(pathIdentifier, pathAmount, pathCurrency, newPeer, pathfinding.PeerAccount{})
         } else {
-            path = account.Add(pathIdentifier, pathAmount, pathfinding.PeerAccount{}, newPeer)
+            path = account.Add(pathIdentifier, pathAmount, pathCurrency, pathfinding.PeerAccount{}, newPeer)
         }
         log.Printf("Initialized new path for identifier: %s with amount: %d", pathIdentifier, pathAmount)
 
         // Send a PathFindingRecurse back to the appropriate peer
-        PathRecurse(datagram, newPeer, 0)
+        PathRecurse(srv, datagram, newPeer, 0)
         return
     }
 
     // If the path is already present, forward the PathFinding request to peers
     log.Printf("Path already exists for identifier %s, forwarding to peers", pathIdentifier)
-    ForwardFindPath(datagram, inOrOut)
+    ForwardFindPath(srv, datagram, inOrOut)
 }
@@ -1,22 +1,34 @@
 package payment_operations
 
 import (
-    "encoding/binary"
     "log"
     "ripple/types"
     "ripple/database/db_pathfinding"
+    "ripple/server"
 )
 
-// ForwardFindPath forwards the pathfinding request to all connected peers
-func ForwardFindPath(datagram *types.Datagram, inOrOut byte) {
+// ForwardFindPath forwards the pathfinding request to all connected peers, if the account policy allows routing the amount
+func ForwardFindPath(srv *server.Server, datagram *types.Datagram, inOrOut byte) {
     // Retrieve the list of connected peers
-    peers, err := db_pathfinding.GetPeers(datagram.Username)
+    peers, err := db_pathfinding.GetPeers(srv.Config, datagram.Username)
     if err != nil {
         log.Printf("Failed to retrieve peers for user %s: %v", datagram.Username, err)
         return
     }
 
-    amount := binary.BigEndian.Uint32(datagram.Arguments[32:36])
+    // Skip the identifier, the arguments are forwarded unchanged in the layout version they arrived in
+    reader := types.NewArgumentReader(datagram)
+    reader.Identifier()
+    amount := reader.Amount()
+    currency, err := reader.Currency()
+    if err != nil {
+        log.Printf("Invalid currency in ForwardFindPath for user %s: %v", datagram.Username, err)
+        return
+    }
+    if err := CheckRoutingPolicy(srv, datagram.Username, amount); err != nil {
+        log.Printf("Not forwarding path finding request: %v", err)
+        return
+    }
 
     for _, peer := range peers {
         // Skip if this peer is the one from which the datagram was received
@@ -25,7 +37,7 @@ func ForwardFindPath(datagram *types.Datagram, inOrOut byte) {
         }
 
         // Use the new CheckTrustlineAndSendFindPathDatagram helper function to handle trustline checking and datagram sending
-        if err := CheckTrustlineAndSendFindPathDatagram(datagram.Command, datagram.Username, peer.ServerAddress, peer.Username, amount, inOrOut, datagram.Arguments[:]); err != nil {
+        if err := CheckTrustlineAndSendFindPathDatagram(srv, datagram.Command, datagram.Username, peer.ServerAddress, peer.Username, currency, amount, inOrOut, datagram.Arguments[:]); err != nil {
             log.Printf("Failed to process pathfinding request from %s to peer %s at server %s: %v", datagram.Username, peer.Username, peer.ServerAddress, err)
             continue
         }
@@ -2,27 +2,21 @@ package payment_operations
 
 import (
     "fmt"
+    "ripple/database"
     "ripple/database/db_trustlines"
     "ripple/handlers"
+    "ripple/types"
+    "ripple/server"
 )
 
-// CheckTrustlineSufficient checks if the trustline (either incoming or outgoing) is sufficient for the given amount.
-func CheckTrustlineSufficient(username, peerServerAddress, peerUsername string, amount uint32, inOrOut byte) (bool, error) {
-    // Get the relevant trustline
-    trustline, err := db_trustlines.GetTrustline(username, peerServerAddress, peerUsername, inOrOut)
+// CheckTrustlineSufficient checks if the trustline (either incoming or outgoing) in a currency is sufficient for the given amount.
+func CheckTrustlineSufficient(srv *server.Server, username, peerServerAddress, peerUsername, currency string, amount types.Amount, inOrOut byte) (bool, error) {
+    // Get the trustline not already used by the credit line, over limit trustlines have none available
+    available, err := db_trustlines.GetAvailableTrustline(srv.Config, username, peerServerAddress, peerUsername, currency, inOrOut)
     if err != nil {
-        return false, fmt.Errorf("failed to retrieve trustline: %v", err)
+        return false, err
     }
 
-    // Get the relevant credit line
-    creditline, err := db_trustlines.GetCreditline(username, peerServerAddress, peerUsername, inOrOut)
-    if err != nil {
-        return false, fmt.Errorf("failed to retrieve creditline: %v", err)
-    }
-
-    // Calculate the available trustline after accounting for the credit line
-    available := trustline - creditline
-
     // Check if the available trustline is sufficient
     if available < amount {
         return false, nil
@@ -32,9 +26,9 @@ func CheckTrustlineSufficient(username, peerServerAddress, peerUsername string,
 }
 
 // CheckTrustlineAndSendFindPathDatagram checks the trustline and sends the datagram if sufficient.
-func CheckTrustlineAndSendFindPathDatagram(command byte, username, peerServerAddress, peerUsername string, amount uint32, inOrOut byte, arguments []byte) error {
+func CheckTrustlineAndSendFindPathDatagram(srv *server.Server, command byte, username, peerServerAddress, peerUsername, currency string, amount types.Amount, inOrOut byte, arguments []byte) error {
     // Check if the trustline is sufficient
-    sufficient, err := CheckTrustlineSufficient(username, peerServerAddress, peerUsername, amount, inOrOut)
+    sufficient, err := CheckTrustlineSufficient(srv, username, peerServerAddress, peerUsername, currency, amount, inOrOut)
     if err != nil {
         return fmt.Errorf("error checking trustline: %v", err)
     }
@@ -43,9 +37,22 @@ func CheckTrustlineAndSendFindPathDatagram(command byte, username, peerServerAdd
     }
 
     // Prepare, sign, and send the datagram
-    if err := handlers.PrepareAndSendDatagram(command, username, peerServerAddress, peerUsername, arguments); err != nil {
+    if err := handlers.PrepareAndSendDatagram(srv, command, username, peerServerAddress, peerUsername, arguments); err != nil {
         return fmt.Errorf("failed to prepare and send pathfinding request from %s to peer %s at server %s: %v", username, peerUsername, peerServerAddress, err)
     }
 
     return nil
 }
+
+// CheckRoutingPolicy checks whether the account policy allows routing an amount through an account. Accounts that
+// are frozen, or whose maximum payment is below the amount, do not take part in path finding for it.
+func CheckRoutingPolicy(srv *server.Server, username string, amount types.Amount) error {
+    policy, err := database.LoadAccountPolicy(srv.Config, username)
+    if err != nil {
+        return fmt.Errorf("error loading account policy for user %s: %v", username, err)
+    }
+    if err := policy.CheckPayment(amount); err != nil {
+        return fmt.Errorf("account %s does not route %d by its account policy: %v", username, amount, err)
+    }
+    return nil
+}
@@ -2,26 +2,44 @@ package payment_operations
 
 import (
     "log"                 // For logging errors and success messages
-    "ripple/comm"         // For sending error and success responses to the client
+    "ripple/database"     // For loading the account policy
     "ripple/handlers/payments"  // For calling the GenerateAndInitiatePayment function
     "ripple/types"
+    "ripple/server"
 )
 
 // NewPayment is a shared function to handle the payment initialization process.
-func NewPayment(session types.Session, inOrOut byte) {
+func NewPayment(srv *server.Server, session types.Session, inOrOut byte) {
     // Retrieve the Datagram from the session
     datagram := session.Datagram
 
     // Extract username from the datagram
     username := datagram.Username
 
+    // Check the payment amount against the account policy
+    policy, err := database.LoadAccountPolicy(srv.Config, username)
+    if err != nil {
+        log.Printf("Error loading account policy for user %s: %v", username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Failed to load account policy.")
+        return
+    }
+    if err := policy.CheckPayment(types.NewArgumentReader(datagram).Amount()); err != nil {
+        log.Printf("Payment for user %s not allowed by account policy: %v", username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Not allowed by account policy: "+err.Error()+".")
+        return
+    }
+
     // Generate the payment identifier and initiate the payment
-    payments.GenerateAndInitiatePayment(datagram, inOrOut)
+    if err := payments.GenerateAndInitiatePayment(srv, datagram, inOrOut); err != nil {
+        log.Printf("Error initializing payment for user %s: %v", username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Invalid currency code.")
+        return
+    }
 
     log.Printf("Payment initialized for user %s.", username)
 
     // Send success response
-    if err := comm.SendSuccessResponse(session.Addr, []byte("Payment initialized successfully.")); err != nil {
+    if err := srv.Endpoint.SendSuccessResponse(session.Addr, []byte("Payment initialized successfully.")); err != nil {
         log.Printf("Failed to send success response to user %s: %v", username, err)
         return
     }
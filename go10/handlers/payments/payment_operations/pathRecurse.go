@@ -6,15 +6,16 @@ import (
     "ripple/handlers"
     "ripple/commands"
     "ripple/pathfinding"
+    "ripple/server"
 )
 
 // PathRecurse sends a PathFindingRecurse command to the specified peer using the depth and identifier from the datagram.
-func PathRecurse(datagram *types.Datagram, peer pathfinding.PeerAccount, depth uint32) {
+func PathRecurse(srv *server.Server, datagram *types.Datagram, peer pathfinding.PeerAccount, depth uint32) {
     // Create the arguments slice by appending the depth to the identifier from the datagram
     arguments := append(datagram.Arguments[:32], types.Uint32ToBytes(depth)...)
 
     // Prepare, sign, and send the datagram using the helper function from the handlers package
-    if err := handlers.PrepareAndSendDatagram(commands.ServerPayments_PathRecurse, datagram.Username, peer.ServerAddress, peer.Username, arguments); err != nil {
+    if err := handlers.PrepareAndSendDatagram(srv, commands.ServerPayments_PathRecurse, datagram.Username, peer.ServerAddress, peer.Username, arguments); err != nil {
         log.Printf("Failed to prepare and send PathRecurse command from %s to peer %s at server %s: %v", datagram.Username, peer.Username, peer.ServerAddress, err)
         return
     }
@@ -5,23 +5,25 @@ import (
     "ripple/types"
     "ripple/database/db_pathfinding"
     "ripple/handlers/payments"
+    "ripple/server"
 )
 
-// StartFindPath initiates a pathfinding request to all connected peers.
-func StartFindPath(username, identifier string, amount uint32, inOrOut byte) {
+// StartFindPath initiates a pathfinding request in a currency to all connected peers.
+func StartFindPath(srv *server.Server, username, identifier, currency string, amount types.Amount, inOrOut byte) {
     // Retrieve the list of connected peers
-    peers, err := db_pathfinding.GetPeers(username)
+    peers, err := db_pathfinding.GetPeers(srv.Config, username)
     if err != nil {
         log.Printf("Failed to retrieve peers for user %s: %v", username, err)
         return
     }
 
-    arguments := append([]byte(identifier), types.Uint32ToBytes(amount)...)
+    // The arguments hold the identifier, the amount and the currency, in that order
+    arguments := types.NewArgumentWriter().Identifier(identifier).Amount(amount).Currency(currency).Bytes()
     command := payments.GetFindPathCommand(inOrOut)
 
     for _, peer := range peers {
         // Use the new helper function to check the trustline and send the datagram
-        if err := CheckTrustlineAndSendFindPathDatagram(command, username, peer.ServerAddress, peer.Username, amount, inOrOut, arguments); err != nil {
+        if err := CheckTrustlineAndSendFindPathDatagram(srv, command, username, peer.ServerAddress, peer.Username, currency, amount, inOrOut, arguments); err != nil {
             log.Printf("Error processing datagram: %v", err)
             continue
         }
@@ -3,9 +3,10 @@ package server_payments
 import (
     "ripple/types"
     "ripple/handlers/payments/payment_operations"
+    "ripple/server"
 )
 
 // FindPathIn processes a pathfinding request from the seller to the buyer
-func FindPathIn(session types.Session) {
-    payment_operations.FindPath(session.Datagram, types.Incoming)
+func FindPathIn(srv *server.Server, session types.Session) {
+    payment_operations.FindPath(srv, session.Datagram, types.Incoming)
 }
@@ -3,9 +3,10 @@ package server_payments
 import (
     "ripple/types"
     "ripple/handlers/payments/payment_operations"
+    "ripple/server"
 )
 
 // FindPathOut processes a pathfinding request from the buyer to the seller
-func FindPathOut(session types.Session) {
-    payment_operations.FindPath(session.Datagram, types.Outgoing)
+func FindPathOut(srv *server.Server, session types.Session) {
+    payment_operations.FindPath(srv, session.Datagram, types.Outgoing)
 }
@@ -4,13 +4,13 @@ import (
     "log"
 
     "ripple/types"
-    "ripple/pathfinding"
     "ripple/handlers/payments"
     "ripple/handlers/payments/payment_operations"
+    "ripple/server"
 )
 
-// PathRecurse processes a pathfinding recurse command
-func PathRecurse(session types.Session) {
+// PathRecurse processes a pathfinding recurse command, if the account policy allows routing the path amount
+func PathRecurse(srv *server.Server, session types.Session) {
     datagram := session.Datagram
 
     // Inline extraction of the path identifier and depth from datagram arguments
@@ -18,7 +18,7 @@ func PathRecurse(session types.Session) {
     incomingDepth := types.BytesToUint32(datagram.Arguments[32:36]) // Assuming depth is in bytes 32-36
 
     // Find the account using the username from the datagram
-    account := pathfinding.GetPathManager().Find(datagram.Username)
+    account := srv.Paths.Find(datagram.Username)
     if account == nil {
         log.Printf("Account not found for user: %s", datagram.Username)
         return
@@ -31,6 +31,12 @@ func PathRecurse(session types.Session) {
         return
     }
 
+    // Check the path amount against the account policy before passing the recurse on
+    if err := payment_operations.CheckRoutingPolicy(srv, datagram.Username, path.Amount); err != nil {
+        log.Printf("Not routing path recurse for path %s: %v", pathIdentifier, err)
+        return
+    }
+
     // Validate the depth first
     if incomingDepth != path.Depth {
         log.Printf("Depth mismatch for path %s: expected %d, got %d", pathIdentifier, path.Depth, incomingDepth)
@@ -45,7 +51,7 @@ func PathRecurse(session types.Session) {
     if account.Payment != nil && account.Payment.Identifier == pathIdentifier {
         log.Printf("Reached the root for path %s, sending out new FindPath requests", pathIdentifier)
         // Use the InOrOut field from the Payment object to determine the direction
-        payment_operations.StartFindPath(datagram.Username, pathIdentifier, path.Amount, account.Payment.InOrOut)
+        payment_operations.StartFindPath(srv, datagram.Username, pathIdentifier, path.Currency, path.Amount, account.Payment.InOrOut)
         return
     }
 
@@ -62,5 +68,5 @@ func PathRecurse(session types.Session) {
     }
 
     // Forward the command to the appropriate peer
-    payment_operations.PathRecurse(datagram, targetPeer, path.Depth)
+    payment_operations.PathRecurse(srv, datagram, targetPeer, path.Depth)
 }
@@ -0,0 +1,70 @@
+package client_trustlines
+
+import (
+    "log"
+
+    "ripple/commands"
+    "ripple/database"
+    "ripple/handlers"
+    "ripple/handlers/trustlines"
+    "ripple/types"
+    "ripple/server"
+)
+
+// ClosePeer handles the client request to end the relationship with a peer.
+// Arguments[0] set to 1 writes off what the peer still owes the account, the outgoing credit line. It only forgives
+// the peer's debt: what the account owes the peer must be settled, or written off by the peer, before the peer is closed.
+func ClosePeer(srv *server.Server, session types.Session) {
+    datagram := session.Datagram
+    writeOff := datagram.Arguments[0] == 1
+
+    // Set both trustlines to zero so no new credit can form while settling
+    if err := trustlines.ZeroTrustlines(srv, datagram); err != nil {
+        log.Printf("Error zeroing trustlines in ClosePeer for user %s: %v", datagram.Username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Failed to zero trustlines.")
+        return
+    }
+
+    // Wait until the credit lines are settled, apart from the peer's debt if the client explicitly writes it off
+    settled, err := trustlines.CheckCreditlinesSettled(srv, datagram, writeOff)
+    if err != nil {
+        log.Printf("Error checking credit lines in ClosePeer for user %s: %v", datagram.Username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Failed to check credit lines.")
+        return
+    }
+    if !settled {
+        log.Printf("Credit lines not settled for user %s with peer %s at %s, close pending.", datagram.Username, datagram.PeerUsername, datagram.PeerServerAddress)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Trustlines set to zero, but credit lines are not settled. Retry when settled, or write off what the peer owes.")
+        return
+    }
+
+    // Tell the peer server before archiving. The datagram is signed when queued, since the peer secret key is
+    // archived with the peer directory, and delivered from the outbox afterwards.
+    onDone := func(err error) {
+        if err != nil {
+            log.Printf("ClosePeer command for user %s was not delivered to peer %s at %s: %v", datagram.Username, datagram.PeerUsername, datagram.PeerServerAddress, err)
+            return
+        }
+        log.Printf("ClosePeer command for user %s delivered to peer %s at %s.", datagram.Username, datagram.PeerUsername, datagram.PeerServerAddress)
+    }
+    if err := handlers.PrepareAndSendDatagramWithCallback(srv, commands.ServerTrustlines_ClosePeer, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername, datagram.Arguments[:1], onDone); err != nil {
+        log.Printf("Failed to send ClosePeer command for user %s to peer %s: %v", datagram.Username, datagram.PeerUsername, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Failed to notify peer server.")
+        return
+    }
+
+    // Archive the peer directory so it is no longer used for pathfinding
+    if err := database.ArchivePeerDir(srv.Config, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername); err != nil {
+        log.Printf("Error archiving peer directory for user %s: %v", datagram.Username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Failed to archive peer.")
+        return
+    }
+
+    // Send success response to the client
+    if err := srv.Endpoint.SendSuccessResponse(session.Addr, []byte("Peer closed successfully.")); err != nil {
+        log.Printf("Failed to send success response in ClosePeer for user %s: %v", datagram.Username, err)
+        return
+    }
+
+    log.Printf("Peer %s at %s closed successfully for user %s.", datagram.PeerUsername, datagram.PeerServerAddress, datagram.Username)
+}
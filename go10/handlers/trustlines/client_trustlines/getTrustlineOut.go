@@ -3,28 +3,47 @@ package client_trustlines
 import (
     "log"
 
-    "ripple/comm"
     "ripple/database/db_trustlines"
     "ripple/types"
+    "ripple/server"
 )
 
 // GetTrustlineOut handles fetching the outbound trustline information
-func GetTrustlineOut(session types.Session) {
+// Arguments[0:8] holds the currency code, empty for the default unit of account.
+// The response holds the amount sized by the argument layout version of the request, and in the current
+// layout also the scale of the currency.
+func GetTrustlineOut(srv *server.Server, session types.Session) {
     datagram := session.Datagram
 
+    currency, err := types.BytesToCurrency(datagram.Arguments[:types.CurrencySize])
+    if err != nil {
+        log.Printf("Invalid currency in GetTrustlineOut for user %s: %v", datagram.Username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Invalid currency code.")
+        return
+    }
+
     // Fetch the outbound trustline
-    trustline, err := db_trustlines.GetTrustlineOutFromDatagram(datagram)
+    trustline, err := db_trustlines.GetTrustlineOutFromDatagram(srv.Config, datagram, currency)
     if err != nil {
         log.Printf("Error reading outbound trustline for user %s: %v", datagram.Username, err)
-        comm.SendErrorResponse(session.Addr, "Error reading outbound trustline.")
+        srv.Endpoint.SendErrorResponse(session.Addr, "Error reading outbound trustline.")
         return
     }
 
-    // Prepare success response using the main utility function
-    responseData := types.Uint32ToBytes(trustline)
+    // Prepare success response in the layout version of the request
+    version := types.GetArgumentsVersion(datagram)
+    responseData, err := types.AmountToBytes(trustline, version)
+    if err != nil {
+        log.Printf("Error serializing outbound trustline for user %s: %v", datagram.Username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Trustline does not fit the request layout.")
+        return
+    }
+    if version != types.ArgumentsVersionLegacy {
+        responseData = append(responseData, srv.Config.GetCurrencyScale(currency))
+    }
 
     // Send the success response back to the client
-    if err := comm.SendSuccessResponse(session.Addr, responseData); err != nil {
+    if err := srv.Endpoint.SendSuccessResponse(session.Addr, responseData); err != nil {
         log.Printf("Error sending success response to user %s: %v", datagram.Username, err)
         return
     }
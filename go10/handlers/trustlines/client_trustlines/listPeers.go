@@ -0,0 +1,47 @@
+package client_trustlines
+
+import (
+    "log"
+
+    "ripple/handlers/trustlines"
+    "ripple/types"
+    "ripple/server"
+)
+
+// ListPeers handles the client request to list all peers with a trustline summary per currency.
+// The list is paginated, Arguments[0:4] holds the page to fetch, starting at 0.
+// The response holds the total number of summaries followed by up to PeersPerPage summaries.
+func ListPeers(srv *server.Server, session types.Session) {
+    datagram := session.Datagram
+    page := types.BytesToUint32(datagram.Arguments[:4])
+
+    // Retrieve the trustline of every connected peer in every currency
+    peerTrustlines, err := trustlines.GetPeerTrustlines(srv, datagram.Username)
+    if err != nil {
+        log.Printf("Failed to retrieve peer trustlines for user %s: %v", datagram.Username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Failed to retrieve peers.")
+        return
+    }
+
+    responseData := types.Uint32ToBytes(uint32(len(peerTrustlines)))
+
+    // Pages past the end return only the summary count
+    start := int(page) * trustlines.PeersPerPage
+    for i := start; i < len(peerTrustlines) && i < start+trustlines.PeersPerPage; i++ {
+        summary, err := trustlines.SerializePeerSummary(srv, datagram.Username, peerTrustlines[i].Peer, peerTrustlines[i].Currency)
+        if err != nil {
+            log.Printf("Error summarizing peer for user %s: %v", datagram.Username, err)
+            srv.Endpoint.SendErrorResponse(session.Addr, "Failed to read peer trustlines.")
+            return
+        }
+        responseData = append(responseData, summary...)
+    }
+
+    // Send the page back to the client
+    if err := srv.Endpoint.SendSuccessResponse(session.Addr, responseData); err != nil {
+        log.Printf("Error sending success response to user %s: %v", datagram.Username, err)
+        return
+    }
+
+    log.Printf("Peer list page %d sent successfully to user %s.", page, datagram.Username)
+}
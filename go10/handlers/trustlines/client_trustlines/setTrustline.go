@@ -1,41 +1,86 @@
 package client_trustlines
 
 import (
-    "encoding/binary"
     "log"
 
-    "ripple/comm"
     "ripple/database/db_trustlines"
     "ripple/types"
     "ripple/handlers/trustlines"
+    "ripple/server"
 )
 
 // SetTrustline updates the trustline based on the given session.
-func SetTrustline(session types.Session) {
+// The arguments hold the trustline amount, an optional Unix timestamp at which the trustline expires (0 for no expiry)
+// and the currency code (empty for the default unit of account), in that order.
+func SetTrustline(srv *server.Server, session types.Session) {
     datagram := session.Datagram
 
-    // Retrieve the trustline amount from the Datagram
-    trustlineAmount := binary.BigEndian.Uint32(datagram.Arguments[:4])
+    // Retrieve the trustline amount, expiry and currency from the Datagram
+    reader := types.NewArgumentReader(datagram)
+    trustlineAmount := reader.Amount()
+    expiry := reader.Int64()
+    currency, err := reader.Currency()
+    if err != nil {
+        log.Printf("Invalid currency in SetTrustline for user %s: %v", datagram.Username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Invalid currency code.")
+        return
+    }
+
+    // Check the trustline against the account policy
+    if errorMessage, err := trustlines.CheckTrustlinePolicy(srv, datagram, trustlineAmount); err != nil {
+        log.Printf("Trustline for user %s not allowed by account policy: %v", datagram.Username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, errorMessage)
+        return
+    }
+
+    // Create the trustline directory the first time a currency is used
+    if err := db_trustlines.InitTrustline(srv.Config, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername, currency); err != nil {
+        log.Printf("Error initializing trustline for user %s: %v", datagram.Username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Failed to initialize trustline.")
+        return
+    }
 
     // Write the new trustline amount using the setter in db_trustlines
-    if err := db_trustlines.SetTrustlineOutFromDatagram(datagram, trustlineAmount); err != nil {
+    if err := db_trustlines.SetTrustlineOutFromDatagram(srv.Config, datagram, currency, trustlineAmount); err != nil {
         log.Printf("Error writing trustline to file for user %s: %v", datagram.Username, err)
-        comm.SendErrorResponse(session.Addr, "Failed to write trustline.")
+        srv.Endpoint.SendErrorResponse(session.Addr, "Failed to write trustline.")
+        return
+    }
+
+    // Write the expiry, which also clears any expiry from a previous trustline
+    if err := db_trustlines.SetExpiryOut(srv.Config, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername, currency, expiry); err != nil {
+        log.Printf("Error writing trustline expiry to file for user %s: %v", datagram.Username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Failed to write trustline expiry.")
         return
     }
 
     // Increment the sync_counter using the function in trustlines package
-    if err := trustlines.IncrementSyncCounter(datagram); err != nil {
+    if err := trustlines.IncrementSyncCounter(srv, datagram, currency); err != nil {
         log.Printf("Error incrementing sync_counter for user %s: %v", datagram.Username, err)
-        comm.SendErrorResponse(session.Addr, "Failed to update sync counter.")
+        srv.Endpoint.SendErrorResponse(session.Addr, "Failed to update sync counter.")
         return
     }
 
     // Log success
     log.Printf("Trustline and sync counter updated successfully for user %s.", datagram.Username)
 
+    // A trustline lowered below what the peer already owes is accepted, but the line is over limit
+    // and has no capacity for routing until the credit line shrinks below the new trustline.
+    overLimit, err := db_trustlines.IsOverLimit(srv.Config, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername, currency, trustlineAmount, types.Outgoing)
+    if err != nil {
+        log.Printf("Error checking credit line for user %s: %v", datagram.Username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Trustline updated, but failed to check credit line.")
+        return
+    }
+
+    response := "Trustline updated successfully."
+    if overLimit {
+        log.Printf("Trustline for user %s to peer %s at %s is below the credit line and over limit.", datagram.Username, datagram.PeerUsername, datagram.PeerServerAddress)
+        response = "Trustline updated. The credit line exceeds it, so the line is over limit and can only shrink."
+    }
+
     // Send success response
-    if err := comm.SendSuccessResponse(session.Addr, []byte("Trustline updated successfully.")); err != nil {
+    if err := srv.Endpoint.SendSuccessResponse(session.Addr, []byte(response)); err != nil {
         log.Printf("Failed to send success response to user %s: %v", datagram.Username, err)
         return
     }
@@ -5,45 +5,54 @@ import (
     "log"
 
     "ripple/commands"
-    "ripple/comm"
     "ripple/database/db_trustlines"
     "ripple/handlers"
     "ripple/types"
+    "ripple/server"
 )
 
 // SyncTrustlineIn handles the client request to sync the inbound trustline from the peer server.
-func SyncTrustlineIn(session types.Session) {
+// Arguments[0:8] holds the currency code, empty for the default unit of account.
+func SyncTrustlineIn(srv *server.Server, session types.Session) {
     datagram := session.Datagram
 
+    currency, err := types.BytesToCurrency(datagram.Arguments[:types.CurrencySize])
+    if err != nil {
+        log.Printf("Invalid currency in SyncTrustlineIn for user %s: %v", datagram.Username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Invalid currency code.")
+        return
+    }
+
     // Prepare the datagram
-    dgOut, err := handlers.PrepareDatagramResponse(datagram)
+    dgOut, err := handlers.PrepareDatagramResponse(srv, datagram)
     if err != nil {
         log.Printf("Error preparing datagram for user %s: %v", datagram.Username, err)
-        comm.SendErrorResponse(session.Addr, "Error preparing datagram.")
+        srv.Endpoint.SendErrorResponse(session.Addr, "Error preparing datagram.")
         return
     }
 
     // Retrieve the current sync_in value
-    syncIn, err := db_trustlines.GetSyncIn(datagram)
+    syncIn, err := db_trustlines.GetSyncIn(srv.Config, datagram, currency)
     if err != nil {
         log.Printf("Error getting sync_in for user %s: %v", datagram.Username, err)
-        comm.SendErrorResponse(session.Addr, "Failed to read sync_in value.")
+        srv.Endpoint.SendErrorResponse(session.Addr, "Failed to read sync_in value.")
         return
     }
 
     dgOut.Command = commands.ServerTrustlines_GetTrustline
-    // Include the sync_in value in the datagram's Arguments[0:4]
+    // Include the sync_in value in the datagram's Arguments[0:4] and the currency in Arguments[4:12]
     binary.BigEndian.PutUint32(dgOut.Arguments[0:4], syncIn)
+    copy(dgOut.Arguments[4:12], types.CurrencyToBytes(currency))
 
     // Send the GetTrustline command to the peer server
-    if err := comm.SignAndSendDatagram(dgOut, datagram.PeerServerAddress); err != nil {
+    if err := srv.Outbox.SealAndSend(dgOut, datagram.PeerServerAddress, nil); err != nil {
         log.Printf("Failed to send GetTrustline command for user %s to peer %s: %v", datagram.Username, datagram.PeerUsername, err)
-        comm.SendErrorResponse(session.Addr, "Failed to send GetTrustline command.")
+        srv.Endpoint.SendErrorResponse(session.Addr, "Failed to send GetTrustline command.")
         return
     }
 
     // Send success response to the client
-    if err := comm.SendSuccessResponse(session.Addr, []byte("Trustline sync request sent successfully.")); err != nil {
+    if err := srv.Endpoint.SendSuccessResponse(session.Addr, []byte("Trustline sync request sent successfully.")); err != nil {
         log.Printf("Failed to send success response to user %s: %v", datagram.Username, err)
         return
     }
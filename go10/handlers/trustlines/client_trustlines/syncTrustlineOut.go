@@ -1,61 +1,75 @@
 package client_trustlines
 
 import (
-    "encoding/binary"
     "log"
 
     "ripple/commands"
-    "ripple/comm"
     "ripple/database/db_trustlines"
     "ripple/handlers"
     "ripple/types"
     "ripple/handlers/trustlines"
+    "ripple/server"
 )
 
 // SyncTrustlineOut handles the client request to sync the outbound trustline to the peer server.
-func SyncTrustlineOut(session types.Session) {
+// Arguments[0:8] holds the currency code, empty for the default unit of account.
+func SyncTrustlineOut(srv *server.Server, session types.Session) {
     datagram := session.Datagram
 
+    currency, err := types.BytesToCurrency(datagram.Arguments[:types.CurrencySize])
+    if err != nil {
+        log.Printf("Invalid currency in SyncTrustlineOut for user %s: %v", datagram.Username, err)
+        srv.Endpoint.SendErrorResponse(session.Addr, "Invalid currency code.")
+        return
+    }
+
     // Prepare the datagram
-    dgOut, err := handlers.PrepareDatagramResponse(datagram)
+    dgOut, err := handlers.PrepareDatagramResponse(srv, datagram)
     if err != nil {
         log.Printf("Error preparing datagram for user %s: %v", datagram.Username, err)
-        comm.SendErrorResponse(session.Addr, "Error preparing datagram.")
+        srv.Endpoint.SendErrorResponse(session.Addr, "Error preparing datagram.")
         return
     }
 
     // Retrieve the syncCounter and sync status
-    syncCounter, isSynced, err := trustlines.GetSyncStatus(datagram)
+    syncCounter, isSynced, err := trustlines.GetSyncStatus(srv, datagram, currency)
     if err != nil {
         log.Printf("Failed to retrieve sync status in SyncTrustlineOut for user %s: %v", datagram.Username, err)
-        comm.SendErrorResponse(session.Addr, "Failed to retrieve sync status.")
+        srv.Endpoint.SendErrorResponse(session.Addr, "Failed to retrieve sync status.")
         return
     }
 
     if isSynced {
         // Trustline is already synced, so prepare a SetTimestamp command
         dgOut.Command = commands.ServerTrustlines_SetTimestamp
+        copy(dgOut.Arguments[:8], types.CurrencyToBytes(currency))
     } else {
         // Trustline is not synced, proceed with sending the trustline
-        trustline, err := db_trustlines.GetTrustlineOutFromDatagram(datagram)
+        trustline, err := db_trustlines.GetTrustlineOutFromDatagram(srv.Config, datagram, currency)
         if err != nil {
             log.Printf("Error getting trustline for user %s in SyncTrustlineOut: %v", datagram.Username, err)
-            comm.SendErrorResponse(session.Addr, "Failed to retrieve trustline.")
+            srv.Endpoint.SendErrorResponse(session.Addr, "Failed to retrieve trustline.")
+            return
+        }
+        expiry, err := db_trustlines.GetExpiryOut(srv.Config, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername, currency)
+        if err != nil {
+            log.Printf("Error getting trustline expiry for user %s in SyncTrustlineOut: %v", datagram.Username, err)
+            srv.Endpoint.SendErrorResponse(session.Addr, "Failed to retrieve trustline expiry.")
             return
         }
         dgOut.Command = commands.ServerTrustlines_SetTrustline
-        binary.BigEndian.PutUint32(dgOut.Arguments[:4], trustline)
-        binary.BigEndian.PutUint32(dgOut.Arguments[4:8], syncCounter)
+        arguments := types.NewArgumentWriter().Amount(trustline).Uint32(syncCounter).Int64(expiry).Currency(currency)
+        copy(dgOut.Arguments[:], arguments.Bytes())
     }
 
     // Send the prepared datagram
-    if err := comm.SignAndSendDatagram(dgOut, datagram.PeerServerAddress); err != nil {
+    if err := srv.Outbox.SealAndSend(dgOut, datagram.PeerServerAddress, nil); err != nil {
         log.Printf("Failed to send datagram in SyncTrustlineOut for user %s: %v", datagram.Username, err)
         return
     }
 
     // Send success response to the client
-    if err := comm.SendSuccessResponse(session.Addr, []byte("Outbound trustline sync request processed successfully.")); err != nil {
+    if err := srv.Endpoint.SendSuccessResponse(session.Addr, []byte("Outbound trustline sync request processed successfully.")); err != nil {
         log.Printf("Failed to send success response in SyncTrustlineOut for user %s: %v", datagram.Username, err)
         return
     }
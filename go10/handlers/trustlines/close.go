@@ -0,0 +1,69 @@
+package trustlines
+
+import (
+    "fmt"
+    "ripple/types"
+    "ripple/database"
+    "ripple/database/db_trustlines"
+    "ripple/server"
+)
+
+// ZeroTrustlines sets both the inbound and outbound trustline to zero in every currency and increments the sync_counter,
+// so the peer learns about the closed outbound trustlines the next time it syncs.
+func ZeroTrustlines(srv *server.Server, datagram *types.Datagram) error {
+    currencies, err := database.GetCurrencies(srv.Config, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername)
+    if err != nil {
+        return fmt.Errorf("Error getting currencies for user %s: %v", datagram.Username, err)
+    }
+
+    for _, currency := range currencies {
+        if err := db_trustlines.SetTrustlineOutFromDatagram(srv.Config, datagram, currency, 0); err != nil {
+            return fmt.Errorf("Error zeroing outbound trustline for user %s: %v", datagram.Username, err)
+        }
+
+        if err := db_trustlines.SetTrustlineInFromDatagram(srv.Config, datagram, currency, 0); err != nil {
+            return fmt.Errorf("Error zeroing inbound trustline for user %s: %v", datagram.Username, err)
+        }
+
+        if err := IncrementSyncCounter(srv, datagram, currency); err != nil {
+            return fmt.Errorf("Error incrementing sync_counter for user %s: %v", datagram.Username, err)
+        }
+    }
+
+    return nil
+}
+
+// CheckCreditlinesSettled checks that neither the incoming nor the outgoing credit line has an outstanding balance in any currency.
+// The incoming credit line, what the account owes the peer, counts as settled once the peer has written it off, and the
+// outgoing credit line, what the peer owes the account, once the account writes it off. An account cannot write off its own debt.
+func CheckCreditlinesSettled(srv *server.Server, datagram *types.Datagram, writeOff bool) (bool, error) {
+    currencies, err := database.GetCurrencies(srv.Config, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername)
+    if err != nil {
+        return false, fmt.Errorf("Error getting currencies for user %s: %v", datagram.Username, err)
+    }
+
+    writtenOff, err := database.GetPeerWriteOff(srv.Config, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername)
+    if err != nil {
+        return false, fmt.Errorf("Error getting write-off for user %s: %v", datagram.Username, err)
+    }
+    var directions []byte
+    if !writtenOff {
+        directions = append(directions, types.Incoming)
+    }
+    if !writeOff {
+        directions = append(directions, types.Outgoing)
+    }
+
+    for _, currency := range currencies {
+        for _, inOrOut := range directions {
+            creditline, err := db_trustlines.GetCreditline(srv.Config, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername, currency, inOrOut)
+            if err != nil {
+                return false, fmt.Errorf("Error getting creditline for user %s: %v", datagram.Username, err)
+            }
+            if creditline != 0 {
+                return false, nil
+            }
+        }
+    }
+    return true, nil
+}
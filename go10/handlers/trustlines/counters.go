@@ -4,19 +4,20 @@ import (
     "fmt"
     "ripple/types"
     "ripple/database/db_trustlines"
+    "ripple/server"
 )
 
 // IncrementSyncCounter retrieves the current sync_counter, increments it, and updates the database.
 // It returns an error if something goes wrong during the process.
-func IncrementSyncCounter(datagram *types.Datagram) error {
+func IncrementSyncCounter(srv *server.Server, datagram *types.Datagram, currency string) error {
     // Retrieve the current value of sync_counter from the database.
-    syncCounter, err := db_trustlines.GetSyncCounter(datagram)
+    syncCounter, err := db_trustlines.GetSyncCounter(srv.Config, datagram, currency)
     if err != nil {
         return err  // Return error if unable to fetch the sync_counter.
     }
 
     // Increment the counter and update it in the database within the same function call.
-    if err := db_trustlines.SetSyncCounter(datagram, syncCounter + 1); err != nil {
+    if err := db_trustlines.SetSyncCounter(srv.Config, datagram, currency, syncCounter + 1); err != nil {
         return err  // Return error if unable to update the sync_counter.
     }
 
@@ -25,15 +26,15 @@ func IncrementSyncCounter(datagram *types.Datagram) error {
 }
 
 // GetSyncStatus retrieves the syncCounter and syncOut values and returns the syncCounter and whether they are equal.
-func GetSyncStatus(datagram *types.Datagram) (uint32, bool, error) {
+func GetSyncStatus(srv *server.Server, datagram *types.Datagram, currency string) (uint32, bool, error) {
     // Retrieve the current syncCounter value
-    syncCounter, err := db_trustlines.GetSyncCounter(datagram)
+    syncCounter, err := db_trustlines.GetSyncCounter(srv.Config, datagram, currency)
     if err != nil {
         return 0, false, fmt.Errorf("Error getting syncCounter for user %s: %v", datagram.Username, err)
     }
 
     // Retrieve the current syncOut value
-    syncOut, err := db_trustlines.GetSyncOut(datagram)
+    syncOut, err := db_trustlines.GetSyncOut(srv.Config, datagram, currency)
     if err != nil {
         return 0, false, fmt.Errorf("Error getting syncOut for user %s: %v", datagram.Username, err)
     }
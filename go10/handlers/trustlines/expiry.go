@@ -0,0 +1,55 @@
+package trustlines
+
+import (
+    "log"
+    "time"
+    "ripple/types"
+    "ripple/database/db_trustlines"
+    "ripple/server"
+)
+
+// ExpireTrustlines sets every expired outbound trustline of a username, in any currency, to zero, clears its expiry
+// and increments the sync_counter, so the peer sees the change the next time it syncs.
+func ExpireTrustlines(srv *server.Server, username string) error {
+    peerTrustlines, err := GetPeerTrustlines(srv, username)
+    if err != nil {
+        return err
+    }
+
+    now := time.Now().Unix()
+    for _, peerTrustline := range peerTrustlines {
+        peer, currency := peerTrustline.Peer, peerTrustline.Currency
+        expiry, err := db_trustlines.GetExpiryOut(srv.Config, username, peer.ServerAddress, peer.Username, currency)
+        if err != nil {
+            log.Printf("Error getting trustline expiry for user %s with peer %s at %s: %v", username, peer.Username, peer.ServerAddress, err)
+            continue
+        }
+        if expiry == 0 || now < expiry {
+            continue
+        }
+
+        // The db_trustlines setters locate the trustline directory from a datagram
+        datagram := &types.Datagram{
+            Username:          username,
+            PeerUsername:      peer.Username,
+            PeerServerAddress: peer.ServerAddress,
+        }
+
+        if err := db_trustlines.SetTrustlineOutFromDatagram(srv.Config, datagram, currency, 0); err != nil {
+            log.Printf("Error zeroing expired trustline for user %s with peer %s at %s: %v", username, peer.Username, peer.ServerAddress, err)
+            continue
+        }
+        if err := db_trustlines.SetExpiryOut(srv.Config, username, peer.ServerAddress, peer.Username, currency, 0); err != nil {
+            log.Printf("Error clearing trustline expiry for user %s with peer %s at %s: %v", username, peer.Username, peer.ServerAddress, err)
+            continue
+        }
+        if err := IncrementSyncCounter(srv, datagram, currency); err != nil {
+            log.Printf("Error incrementing sync_counter for user %s with peer %s at %s: %v", username, peer.Username, peer.ServerAddress, err)
+            continue
+        }
+
+        log.Printf("Trustline for user %s to peer %s at %s expired and was set to zero.", username, peer.Username, peer.ServerAddress)
+    }
+
+    return nil
+}
@@ -0,0 +1,74 @@
+package trustlines
+
+import (
+    "fmt"
+    "ripple/types"
+    "ripple/database"
+    "ripple/database/db_trustlines"
+    "ripple/server"
+)
+
+// CheckTrustlinePolicy checks a new outbound trustline against the account policy, including the maximum number of
+// peers the account may extend trustlines to. It returns an error message string for the client (empty if allowed)
+// and an error object for detailed information if the trustline is not allowed.
+func CheckTrustlinePolicy(srv *server.Server, datagram *types.Datagram, amount types.Amount) (string, error) {
+    policy, err := database.LoadAccountPolicy(srv.Config, datagram.Username)
+    if err != nil {
+        return "Failed to load account policy.", fmt.Errorf("error loading account policy for user %s: %v", datagram.Username, err)
+    }
+    if err := policy.CheckTrustline(amount); err != nil {
+        return "Not allowed by account policy: " + err.Error() + ".", err
+    }
+    if policy.MaxPeers == 0 || amount == 0 {
+        return "", nil
+    }
+
+    peerTrustlines, err := GetPeerTrustlines(srv, datagram.Username)
+    if err != nil {
+        return "Failed to check account policy.", err
+    }
+
+    // Count the other peers the account already extends a trustline to, in any currency
+    trusted := make(map[string]bool)
+    for _, peerTrustline := range peerTrustlines {
+        peer := peerTrustline.Peer
+        trustline, err := db_trustlines.GetTrustlineOut(srv.Config, datagram.Username, peer.ServerAddress, peer.Username, peerTrustline.Currency)
+        if err != nil {
+            return "Failed to check account policy.", err
+        }
+        if trustline == 0 {
+            continue
+        }
+        if peer.Username == datagram.PeerUsername && peer.ServerAddress == datagram.PeerServerAddress {
+            // The peer already counts towards the limit
+            return "", nil
+        }
+        trusted[peer.ServerAddress+"/"+peer.Username] = true
+    }
+
+    if len(trusted) >= policy.MaxPeers {
+        err := fmt.Errorf("trustlines to %d peers reach the maximum of %d set by the account policy", len(trusted), policy.MaxPeers)
+        return "Not allowed by account policy: " + err.Error() + ".", err
+    }
+    return "", nil
+}
+
+// IsCurrencyAccepted checks whether a peer may open a trustline to an account in a currency: the default unit of account,
+// a currency configured for the server, or one the account already has a trustline in, with this peer or another.
+// Anything else is refused, so a peer cannot create trustline directories in currencies of its choosing.
+func IsCurrencyAccepted(srv *server.Server, username, currency string) (bool, error) {
+    if currency == "" || srv.Config.IsCurrencyConfigured(currency) {
+        return true, nil
+    }
+
+    peerTrustlines, err := GetPeerTrustlines(srv, username)
+    if err != nil {
+        return false, err
+    }
+    for _, peerTrustline := range peerTrustlines {
+        if peerTrustline.Currency == currency {
+            return true, nil
+        }
+    }
+    return false, nil
+}
@@ -0,0 +1,30 @@
+package server_trustlines
+
+import (
+    "log"
+    "ripple/types"
+    "ripple/database"
+    "ripple/handlers/events"
+    "ripple/server"
+)
+
+// ClosePeer handles a peer server closing its relationship with a local account.
+// Arguments[0] set to 1 means the peer wrote off any credit lines that have not been settled.
+// A peer can only give up its own side: a write-off settles what the local account owes the peer, while the
+// trustlines, what the peer owes and the peer directory are kept until the local user closes the peer themselves.
+func ClosePeer(srv *server.Server, session types.Session) {
+    datagram := session.Datagram
+    writeOff := datagram.Arguments[0] == 1
+
+    if writeOff {
+        if err := database.SetPeerWriteOff(srv.Config, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername); err != nil {
+            log.Printf("Error recording write-off in ClosePeer for user %s: %v", datagram.Username, err)
+            return
+        }
+    }
+
+    log.Printf("Peer %s at %s closed by peer for user %s (write-off: %t), close pending.", datagram.PeerUsername, datagram.PeerServerAddress, datagram.Username, writeOff)
+
+    // Let a waiting client know the peer is leaving, so the local user can close it
+    events.PushPeerEvent(srv, datagram, events.EventPeerClosed, 0, "")
+}
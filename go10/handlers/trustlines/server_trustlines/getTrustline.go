@@ -2,29 +2,48 @@ package server_trustlines
 
 import (
     "log"
-    "encoding/binary"
 
-    "ripple/comm"
     "ripple/types"
+    "ripple/database"
     "ripple/handlers"
     "ripple/handlers/trustlines"
     "ripple/database/db_trustlines"
     "ripple/commands"
+    "ripple/server"
 )
 
 // GetTrustline handles the request to get the current trustline amount from another server
-func GetTrustline(session types.Session) {
+func GetTrustline(srv *server.Server, session types.Session) {
     datagram := session.Datagram
 
+    // Extract the currency from the datagram's Arguments[4:12]
+    currency, err := types.BytesToCurrency(datagram.Arguments[4:12])
+    if err != nil {
+        log.Printf("Invalid currency in GetTrustline for user %s: %v", datagram.Username, err)
+        return
+    }
+
+    // Only currencies that already have a trustline with the peer are served, so a peer cannot create directories
+    // by asking about currencies. There is nothing to sync in any other currency.
+    exists, err := database.CheckTrustlineExists(srv.Config, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername, currency)
+    if err != nil {
+        log.Printf("Error checking trustline in GetTrustline for user %s: %v", datagram.Username, err)
+        return
+    }
+    if !exists {
+        log.Printf("Dropped GetTrustline for user %s from peer %s at %s in unknown currency %q", datagram.Username, datagram.PeerUsername, datagram.PeerServerAddress, currency)
+        return
+    }
+
     // Retrieve the syncCounter and local sync status
-    syncCounter, isSyncedLocally, err := trustlines.GetSyncStatus(datagram)
+    syncCounter, isSyncedLocally, err := trustlines.GetSyncStatus(srv, datagram, currency)
     if err != nil {
         log.Printf("Failed to retrieve sync status in GetTrustline for user %s: %v", datagram.Username, err)
         return
     }
 
     // Prepare the datagram
-    dg, err := handlers.PrepareDatagramResponse(datagram)
+    dg, err := handlers.PrepareDatagramResponse(srv, datagram)
     if err != nil {
         log.Printf("Error preparing datagram in GetTrustline for user %s: %v", datagram.Username, err)
         return
@@ -37,21 +56,28 @@ func GetTrustline(session types.Session) {
         // The peer is not synced, prepare to send trustline data to synchronize
         dg.Command = commands.ServerTrustlines_SetTrustline
 
-        trustline, err := db_trustlines.GetTrustlineOutFromDatagram(session.Datagram)
+        trustline, err := db_trustlines.GetTrustlineOutFromDatagram(srv.Config, session.Datagram, currency)
         if err != nil {
             log.Printf("Error getting trustline for user %s in GetTrustline: %v", session.Datagram.Username, err)
             return
         }
     
-        binary.BigEndian.PutUint32(dg.Arguments[:4], trustline)
-        binary.BigEndian.PutUint32(dg.Arguments[4:8], syncCounter)
+        expiry, err := db_trustlines.GetExpiryOut(srv.Config, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername, currency)
+        if err != nil {
+            log.Printf("Error getting trustline expiry for user %s in GetTrustline: %v", datagram.Username, err)
+            return
+        }
+
+        arguments := types.NewArgumentWriter().Amount(trustline).Uint32(syncCounter).Int64(expiry).Currency(currency)
+        copy(dg.Arguments[:], arguments.Bytes())
     } else {
         // Use the SetTimestamp command to the peer to acknowledge synchronization
         dg.Command = commands.ServerTrustlines_SetTimestamp
+        copy(dg.Arguments[:8], types.CurrencyToBytes(currency))
         if !isSyncedLocally {
             // The peer is synced, but the local server is not aware
             // Update the local sync_out to match the sync_counter
-            if err := db_trustlines.SetSyncOut(datagram, syncCounter); err != nil {
+            if err := db_trustlines.SetSyncOut(srv.Config, datagram, currency, syncCounter); err != nil {
                 log.Printf("Error updating sync_out in GetTrustline for user %s: %v", datagram.Username, err)
                 return
             }
@@ -59,7 +85,7 @@ func GetTrustline(session types.Session) {
     }
 
     // Send the prepared datagram
-    if err := comm.SignAndSendDatagram(dg, datagram.PeerServerAddress); err != nil {
+    if err := srv.Outbox.SealAndSend(dg, datagram.PeerServerAddress, nil); err != nil {
         log.Printf("Failed to sign and send datagram in GetTrustline for user %s: %v", session.Datagram.Username, err)
         return
     }
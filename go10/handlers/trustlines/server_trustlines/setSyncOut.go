@@ -4,17 +4,23 @@ import (
     "log"
     "ripple/types"
     "ripple/database/db_trustlines"
+    "ripple/server"
 )
 
 // SetSyncOut handles updating the sync_out counter from a received context
-func SetSyncOut(session types.Session) {
+func SetSyncOut(srv *server.Server, session types.Session) {
     datagram := session.Datagram
 
-    // Load the new sync_out value from the Arguments in the Datagram
+    // Load the new sync_out value and the currency from the Arguments in the Datagram
     syncOut := types.BytesToUint32(datagram.Arguments[:4])
+    currency, err := types.BytesToCurrency(datagram.Arguments[4:12])
+    if err != nil {
+        log.Printf("Invalid currency in SetSyncOut for user %s: %v", datagram.Username, err)
+        return
+    }
 
     // Retrieve the previous sync_out value
-    prevSyncOut, err := db_trustlines.GetSyncOut(datagram)
+    prevSyncOut, err := db_trustlines.GetSyncOut(srv.Config, datagram, currency)
     if err != nil {
         log.Printf("Error getting previous sync_out for user %s: %v", datagram.Username, err)
         return
@@ -28,7 +34,7 @@ func SetSyncOut(session types.Session) {
     }
 
     // Write the new sync_out value
-    if err := db_trustlines.SetSyncOut(datagram, syncOut); err != nil {
+    if err := db_trustlines.SetSyncOut(srv.Config, datagram, currency, syncOut); err != nil {
         log.Printf("Error writing sync_out to file for user %s: %v", datagram.Username, err)
         return
     }
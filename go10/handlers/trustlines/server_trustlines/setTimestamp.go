@@ -5,17 +5,25 @@ import (
     "time"
     "ripple/types"
     "ripple/database/db_trustlines"
+    "ripple/server"
 )
 
 // SetTimestamp handles updating the sync timestamp for trustlines
-func SetTimestamp(session types.Session) {
+func SetTimestamp(srv *server.Server, session types.Session) {
     datagram := session.Datagram
 
+    // Load the currency from the Arguments in the Datagram
+    currency, err := types.BytesToCurrency(datagram.Arguments[:types.CurrencySize])
+    if err != nil {
+        log.Printf("Invalid currency in SetTimestamp for user %s: %v", datagram.Username, err)
+        return
+    }
+
     // Retrieve the current timestamp
     timestamp := time.Now().Unix()
 
     // Write the new timestamp using the setter in db_trustlines
-    if err := db_trustlines.SetTimestamp(datagram, timestamp); err != nil {
+    if err := db_trustlines.SetTimestamp(srv.Config, datagram, currency, timestamp); err != nil {
         log.Printf("Error writing timestamp for user %s: %v", datagram.Username, err)
         return
     }
@@ -4,56 +4,96 @@ import (
     "log"
     "time"
     "ripple/handlers"
+    "ripple/handlers/events"
+    "ripple/handlers/trustlines"
     "ripple/commands"
     "ripple/types"
     "ripple/database/db_trustlines"
+    "ripple/server"
 )
 
 // SetTrustline handles setting or updating a trustline from another server's perspective.
-func SetTrustline(session types.Session) {
+// The arguments hold the trustline amount, the sync counter, the expiry and the currency code, in that order.
+func SetTrustline(srv *server.Server, session types.Session) {
     datagram := session.Datagram
 
+    // Retrieve the trustline amount, sync counter, expiry and currency from the Datagram
+    reader := types.NewArgumentReader(datagram)
+    trustlineAmount := reader.Amount()
+    syncIn := reader.Uint32()
+    expiry := reader.Int64()
+    currency, err := reader.Currency()
+    if err != nil {
+        log.Printf("Invalid currency in SetTrustline for user %s: %v", datagram.Username, err)
+        return
+    }
+
+    // Only currencies the server or the account already uses are accepted from a peer
+    accepted, err := trustlines.IsCurrencyAccepted(srv, datagram.Username, currency)
+    if err != nil {
+        log.Printf("Error checking currency in SetTrustline for user %s: %v", datagram.Username, err)
+        return
+    }
+    if !accepted {
+        log.Printf("Dropped SetTrustline for user %s from peer %s at %s in unknown currency %q", datagram.Username, datagram.PeerUsername, datagram.PeerServerAddress, currency)
+        return
+    }
+
+    // Create the trustline directory the first time the peer extends a trustline in a currency
+    if err := db_trustlines.InitTrustline(srv.Config, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername, currency); err != nil {
+        log.Printf("Error initializing trustline for user %s: %v", datagram.Username, err)
+        return
+    }
+
     // Retrieve the sync_in value using the new getter
-    prevSyncIn, err := db_trustlines.GetSyncIn(datagram)
+    prevSyncIn, err := db_trustlines.GetSyncIn(srv.Config, datagram, currency)
     if err != nil {
         log.Printf("Error getting sync_in for user %s: %v", datagram.Username, err)
         return
     }
 
-    // Retrieve the syncIn counter from the Datagram
-    syncInBytes := datagram.Arguments[4:8]
-    syncIn := types.BytesToUint32(syncInBytes)
-
     if syncIn > prevSyncIn {
-
-        // Retrieve the trustline amount from the Datagram
-        trustlineAmount := types.BytesToUint32(datagram.Arguments[:4])
-    
         // Update the trustline, sync_in, and timestamp
-        if err := db_trustlines.SetTrustlineInFromDatagram(datagram, trustlineAmount); err != nil {
+        if err := db_trustlines.SetTrustlineInFromDatagram(srv.Config, datagram, currency, trustlineAmount); err != nil {
             log.Printf("Error writing trustline to file for user %s: %v", datagram.Username, err)
             return
         }
     
-        if err := db_trustlines.SetSyncIn(datagram, syncIn); err != nil {
+        if err := db_trustlines.SetExpiryIn(srv.Config, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername, currency, expiry); err != nil {
+            log.Printf("Error writing trustline expiry to file for user %s: %v", datagram.Username, err)
+            return
+        }
+    
+        if err := db_trustlines.SetSyncIn(srv.Config, datagram, currency, syncIn); err != nil {
             log.Printf("Error writing sync_in to file for user %s: %v", datagram.Username, err)
             return
         }
     
         log.Printf("Trustline and sync_in updated successfully for user %s.", datagram.Username)
+
+        // A lowered trustline is accepted even if the credit line exceeds it, routing treats it as over limit
+        if overLimit, err := db_trustlines.IsOverLimit(srv.Config, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername, currency, trustlineAmount, types.Incoming); err != nil {
+            log.Printf("Error checking credit line for user %s: %v", datagram.Username, err)
+        } else if overLimit {
+            log.Printf("Inbound trustline for user %s from peer %s at %s is below the credit line and over limit.", datagram.Username, datagram.PeerUsername, datagram.PeerServerAddress)
+        }
     
         // Prepare and send the datagram to sync the peer's out counter
-        if err := handlers.PrepareAndSendDatagram(commands.ServerTrustlines_SetSyncOut, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername, syncInBytes); err != nil {
+        arguments := types.NewArgumentWriter().Uint32(syncIn).Currency(currency)
+        if err := handlers.PrepareAndSendDatagram(srv, commands.ServerTrustlines_SetSyncOut, datagram.Username, datagram.PeerServerAddress, datagram.PeerUsername, arguments.Bytes()); err != nil {
             log.Printf("Failed to sign and send datagram for user %s: %v", datagram.Username, err)
             return
         }
     
-        log.Printf("Trustline update and datagram sent successfully for user %s.", datagram.Username)        
+        log.Printf("Trustline update and datagram sent successfully for user %s.", datagram.Username)
+
+        // Let a waiting client know about the new trustline
+        events.PushPeerEvent(srv, datagram, events.EventTrustlineIn, trustlineAmount, currency)
     } else {
         log.Printf("Sync_in is synchronized with the peer's most recent trustline_out for user %s.", datagram.Username)
     }
 
-    if err := db_trustlines.SetTimestamp(datagram, time.Now().Unix()); err != nil {
+    if err := db_trustlines.SetTimestamp(srv.Config, datagram, currency, time.Now().Unix()); err != nil {
         log.Printf("Error writing timestamp to file for user %s: %v", datagram.Username, err)
         return
     }
@@ -0,0 +1,89 @@
+package trustlines
+
+import (
+    "encoding/binary"
+    "fmt"
+    "ripple/types"
+    "ripple/pathfinding"
+    "ripple/database"
+    "ripple/database/db_pathfinding"
+    "ripple/database/db_trustlines"
+    "ripple/server"
+)
+
+// PeersPerPage is how many peer summaries fit in one ListPeers response.
+// A summary is at most 195 bytes, with a server address of up to 128 bytes, so two of them and the 4-byte
+// summary count stay within a single datagram.
+const PeersPerPage = 2
+
+// SerializePeerSummary constructs a byte array with the peer identifier, currency, currency scale, trustline in and out,
+// sync state and the last sync timestamp of a peer in one currency. The peer server address is prefixed with its
+// 1-byte length, so addresses longer than 32 bytes are not truncated.
+func SerializePeerSummary(srv *server.Server, username string, peer pathfinding.PeerAccount, currency string) ([]byte, error) {
+    // The db_trustlines getters locate the trustline directory from a datagram
+    datagram := &types.Datagram{
+        Username:          username,
+        PeerUsername:      peer.Username,
+        PeerServerAddress: peer.ServerAddress,
+    }
+
+    trustlineIn, err := db_trustlines.GetTrustlineInFromDatagram(srv.Config, datagram, currency)
+    if err != nil {
+        return nil, fmt.Errorf("Error getting inbound trustline for peer %s at %s: %v", peer.Username, peer.ServerAddress, err)
+    }
+
+    trustlineOut, err := db_trustlines.GetTrustlineOutFromDatagram(srv.Config, datagram, currency)
+    if err != nil {
+        return nil, fmt.Errorf("Error getting outbound trustline for peer %s at %s: %v", peer.Username, peer.ServerAddress, err)
+    }
+
+    _, isSynced, err := GetSyncStatus(srv, datagram, currency)
+    if err != nil {
+        return nil, err
+    }
+
+    timestamp, err := db_trustlines.GetTimestamp(srv.Config, datagram, currency)
+    if err != nil {
+        return nil, fmt.Errorf("Error getting timestamp for peer %s at %s: %v", peer.Username, peer.ServerAddress, err)
+    }
+
+    buffer := append(types.PadStringTo32Bytes(peer.Username), byte(len(peer.ServerAddress)))
+    buffer = append(buffer, peer.ServerAddress...)
+    buffer = append(buffer, types.CurrencyToBytes(currency)...)
+    buffer = append(buffer, srv.Config.GetCurrencyScale(currency))
+    buffer = binary.BigEndian.AppendUint64(buffer, uint64(trustlineIn))
+    buffer = binary.BigEndian.AppendUint64(buffer, uint64(trustlineOut))
+    if isSynced {
+        buffer = append(buffer, 1)
+    } else {
+        buffer = append(buffer, 0)
+    }
+    buffer = binary.BigEndian.AppendUint64(buffer, uint64(timestamp))
+    return buffer, nil
+}
+
+// PeerTrustline identifies the trustline of a peer in one currency
+type PeerTrustline struct {
+    Peer     pathfinding.PeerAccount
+    Currency string
+}
+
+// GetPeerTrustlines retrieves the trustline of every peer in every currency
+func GetPeerTrustlines(srv *server.Server, username string) ([]PeerTrustline, error) {
+    peers, err := db_pathfinding.GetPeers(srv.Config, username)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to retrieve peers for user %s: %v", username, err)
+    }
+
+    var peerTrustlines []PeerTrustline
+    for _, peer := range peers {
+        currencies, err := database.GetCurrencies(srv.Config, username, peer.ServerAddress, peer.Username)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to retrieve currencies for peer %s at %s: %v", peer.Username, peer.ServerAddress, err)
+        }
+        for _, currency := range currencies {
+            peerTrustlines = append(peerTrustlines, PeerTrustline{Peer: peer, Currency: currency})
+        }
+    }
+    return peerTrustlines, nil
+}
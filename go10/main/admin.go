@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"ripple/config"
+	"ripple/database"
+	"ripple/database/db_pathfinding"
+	"ripple/types"
+)
+
+// adminUsage describes the account management subcommands of the server binary
+const adminUsage = `Usage: ripple <command> [arguments]
+
+Commands:
+  create <username>              create an account and print its secret key once
+  list                           list accounts with their peer counts
+  suspend <username>             reject all datagrams for an account
+  unsuspend <username>           reinstate a suspended account
+  remove [-force] <username>     move an account to the removed directory, -force also if it still has peers
+
+Without a command, the server is started.`
+
+// runAdminCommand runs an account management subcommand and returns the exit code
+func runAdminCommand(cfg *config.Config, args []string) int {
+	if err := adminCommand(cfg, args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// adminCommand dispatches an account management subcommand
+func adminCommand(cfg *config.Config, args []string) error {
+	command, args := args[0], args[1:]
+	switch command {
+	case "create":
+		return adminCreate(cfg, args)
+	case "list":
+		return adminList(cfg)
+	case "suspend":
+		return adminSuspend(cfg, args, true)
+	case "unsuspend":
+		return adminSuspend(cfg, args, false)
+	case "remove":
+		return adminRemove(cfg, args)
+	case "help", "-h", "-help", "--help":
+		fmt.Println(adminUsage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", command, adminUsage)
+	}
+}
+
+// parseUsername checks that exactly one valid username was given
+func parseUsername(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("expected one username\n\n%s", adminUsage)
+	}
+	if err := types.ValidateUsername("username", args[0]); err != nil {
+		return "", err
+	}
+	return args[0], nil
+}
+
+// adminCreate creates an account with a freshly generated secret key, which is printed once for the account holder
+func adminCreate(cfg *config.Config, args []string) error {
+	username, err := parseUsername(args)
+	if err != nil {
+		return err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("failed to generate secret key: %w", err)
+	}
+	secretKey := []byte(hex.EncodeToString(key))
+
+	if err := database.CreateAccount(cfg, username, secretKey); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created account %s.\n", username)
+	fmt.Printf("Secret key (shown once, give it to the account holder): %s\n", secretKey)
+	return nil
+}
+
+// adminList lists all accounts with their number of peers and whether they are suspended
+func adminList(cfg *config.Config) error {
+	usernames, err := database.GetAccounts(cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, username := range usernames {
+		peers, err := db_pathfinding.GetPeers(cfg, username)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve peers for %s: %w", username, err)
+		}
+		suspended, err := database.IsAccountSuspended(cfg, username)
+		if err != nil {
+			return fmt.Errorf("failed to check suspension for %s: %w", username, err)
+		}
+
+		status := ""
+		if suspended {
+			status = " (suspended)"
+		}
+		fmt.Printf("%s\t%d peers%s\n", username, len(peers), status)
+	}
+	return nil
+}
+
+// adminSuspend suspends or reinstates an account
+func adminSuspend(cfg *config.Config, args []string, suspended bool) error {
+	username, err := parseUsername(args)
+	if err != nil {
+		return err
+	}
+
+	if err := database.SetAccountSuspended(cfg, username, suspended); err != nil {
+		return err
+	}
+
+	if suspended {
+		fmt.Printf("Suspended account %s.\n", username)
+	} else {
+		fmt.Printf("Reinstated account %s.\n", username)
+	}
+	return nil
+}
+
+// adminRemove moves an account to the removed directory. Accounts that still have peers, and so may still
+// have trustlines and credit lines, are only removed with -force.
+func adminRemove(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("remove", flag.ContinueOnError)
+	force := flags.Bool("force", false, "remove the account even if it still has peers")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	username, err := parseUsername(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	peers, err := db_pathfinding.GetPeers(cfg, username)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve peers for %s: %w", username, err)
+	}
+	if len(peers) > 0 && !*force {
+		return fmt.Errorf("account %s still has %d peers, close them first or use -force", username, len(peers))
+	}
+
+	if err := database.RemoveAccount(cfg, username); err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed account %s to %s.\n", username, database.GetRemovedDir(cfg))
+	return nil
+}
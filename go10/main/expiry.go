@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+	"ripple/config"
+	"ripple/database"
+	"ripple/handlers/trustlines"
+	"ripple/server"
+)
+
+// runExpiryTask periodically sets expired trustlines to zero until the server shuts down. Each account is expired
+// through the SessionManager, so it cannot race with a session renewing the same trustline.
+func runExpiryTask(srv *server.Server, sessionManager *SessionManager, shutdownFlag *int32) {
+	ticker := time.NewTicker(config.ExpiryCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if atomic.LoadInt32(shutdownFlag) != 0 {
+			return
+		}
+
+		usernames, err := database.GetAccounts(srv.Config)
+		if err != nil {
+			log.Printf("Error retrieving accounts for trustline expiry: %v", err)
+			continue
+		}
+
+		for _, username := range usernames {
+			username := username
+			sessionManager.RunForAccount(username, func() {
+				if err := trustlines.ExpireTrustlines(srv, username); err != nil {
+					log.Printf("Error expiring trustlines: %v", err)
+				}
+			})
+		}
+	}
+}
@@ -1,15 +1,17 @@
 package main
 
 import (
+    "ripple/server"
     "ripple/types"
     "ripple/handlers/trustlines/client_trustlines"
     "ripple/handlers/trustlines/server_trustlines"
     "ripple/handlers/payments/client_payments"
     "ripple/handlers/payments/server_payments"
+    "ripple/handlers/events/client_events"
 )
 
-// CommandHandler defines the type for command handling functions
-type CommandHandler func(session types.Session)
+// CommandHandler defines the type for command handling functions, given the server instance the session arrived at
+type CommandHandler func(srv *server.Server, session types.Session)
 
 // CommandHandlers maps command bytes to handler functions
 var commandHandlers = [256]CommandHandler{
@@ -21,6 +23,9 @@ var commandHandlers = [256]CommandHandler{
     5:   client_payments.NewPaymentOut,      // Client Command
     6:   client_payments.NewPaymentIn,       // Client Command
     7:   client_payments.GetPayment,         // Client Command
+    8:   client_trustlines.ClosePeer,        // Client Command
+    9:   client_trustlines.ListPeers,        // Client Command
+    10:  client_events.Subscribe,            // Client Command
 
     127: server_trustlines.SetTrustline,     // Server Command
     128: server_trustlines.GetTrustline,     // Server Command
@@ -29,5 +34,6 @@ var commandHandlers = [256]CommandHandler{
     131: server_payments.FindPathOut,        // Server Command
     132: server_payments.FindPathIn    ,     // Server Command
     133: server_payments.PathRecurse  ,      // Server Command
+    134: server_trustlines.ClosePeer,        // Server Command
     // Other indices are nil by default
 }
@@ -4,46 +4,116 @@ import (
 	"log"
 	"fmt"
 	"net"
-	"ripple/pathfinding"
+	"os"
 	"ripple/config"
+	"ripple/database"
+	"ripple/server"
+	"ripple/transport"
+	"sync"
 )
 
 func main() {
 
-	if err := config.InitConfig(); err != nil {
+	cfg, err := config.InitConfig()
+	if err != nil {
 		log.Fatalf("Configuration failed: %v", err)
 	}
 
-	// Initialize the session manager
-	sessionManager := NewSessionManager()
+	// Run an account management command instead of the server if one is given
+	if len(os.Args) > 1 {
+		os.Exit(runAdminCommand(cfg, os.Args[1:]))
+	}
 
-	// Initialize the path manager
-	pathfinding.InitPathManager()
+	// Initialize the server instance
+	srv := server.NewServer(cfg)
 
-	// Set up the UDP server
-	addr := net.UDPAddr{
-		Port: config.Port,
-		IP:   net.ParseIP("0.0.0.0"),
-	}
-	conn, err := net.ListenUDP("udp", &addr)
+	// Listen on the configured transports
+	transports, err := listen(cfg)
 	if err != nil {
 		fmt.Printf("Failed to listen on port %d: %v\n", config.Port, err)
 		return
 	}
 
-	fmt.Printf("Listening on port %d at server address %s.\n", config.Port, config.GetServerAddress())
+	fmt.Printf("Listening on port %d at server address %s.\n", config.Port, cfg.GetServerAddress())
 
 	// Initialize the shutdown flag
 	var shutdownFlag int32
 
-	go shutdownHandler(conn, &shutdownFlag)
+	go shutdownHandler(transports, &shutdownFlag)
 
-	// Start the server loop
-	runServerLoop(conn, sessionManager, &shutdownFlag)
+	if err := runServer(srv, transports, &shutdownFlag); err != nil {
+		fmt.Printf("Failed to start the server: %v\n", err)
+		return
+	}
+	metrics.logSummary()
+
+	// Final console message before shutting down
+	fmt.Println("Server shutdown complete.")
+}
+
+// runServer runs a server instance on its transports until the shutdown flag is set and the transports are closed.
+// The transports are not opened here, so instances can be run on any network, several in one process.
+func runServer(srv *server.Server, transports []transport.Transport, shutdownFlag *int32) error {
+	// Restore the counters written since the last checkpoint of the journal, in case the server did not shut down cleanly
+	if err := database.RecoverJournal(srv.Config); err != nil {
+		return fmt.Errorf("failed to recover the counter journal: %w", err)
+	}
+
+	// Initialize the session manager
+	sessionManager := NewSessionManager(srv)
+
+	// Send everything from the listening sockets, with ACKs routed to the waiting senders by the transports
+	for _, serverTransport := range transports {
+		srv.Endpoint.AddTransport(serverTransport)
+	}
+
+	// Start delivering server-to-server datagrams, including those queued before a restart
+	if err := srv.Outbox.Start(); err != nil {
+		return fmt.Errorf("failed to load the outbox: %w", err)
+	}
+
+	// Start the background task that expires time-limited trustlines
+	go runExpiryTask(srv, sessionManager, shutdownFlag)
+
+	// Start a server loop for each transport, feeding the same SessionManager
+	var loops sync.WaitGroup
+	for _, serverTransport := range transports {
+		loops.Add(1)
+		go func(serverTransport transport.Transport) {
+			defer loops.Done()
+			runServerLoop(srv, serverTransport, sessionManager, shutdownFlag)
+		}(serverTransport)
+	}
+	loops.Wait()
 
 	sessionManager.wg.Wait()
 	log.Println("All sessions and queues have been processed. Exiting.")
+	return nil
+}
 
-	// Final console message before shutting down
-	fmt.Println("Server shutdown complete.")
+// listen opens the listening sockets of the transports in the configured transport mode, all on the same port
+func listen(cfg *config.Config) ([]transport.Transport, error) {
+	var transports []transport.Transport
+	mode := cfg.GetTransportMode()
+
+	if mode == config.TransportModeUDP || mode == config.TransportModeBoth {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: config.Port, IP: net.ParseIP("0.0.0.0")})
+		if err != nil {
+			return nil, err
+		}
+		transports = append(transports, transport.NewUDPTransport(conn))
+	}
+
+	if mode == config.TransportModeTCP || mode == config.TransportModeBoth {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", config.Port))
+		if err != nil {
+			for _, t := range transports {
+				t.Close()
+			}
+			return nil, err
+		}
+		transports = append(transports, transport.NewTCPTransport(listener))
+	}
+
+	return transports, nil
 }
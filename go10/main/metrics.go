@@ -0,0 +1,19 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// serverMetrics counts what the server loop drops, so quiet duplicates and genuine replays can be told apart
+type serverMetrics struct {
+	duplicatesDropped uint64 // Retransmissions of accepted datagrams, re-ACKed and dropped
+	replaysRejected   uint64 // Datagrams rejected by the counter check
+}
+
+var metrics serverMetrics
+
+// logSummary logs the counts
+func (m *serverMetrics) logSummary() {
+	log.Printf("Dropped %d retransmitted duplicates and rejected %d replayed datagrams.", atomic.LoadUint64(&m.duplicatesDropped), atomic.LoadUint64(&m.replaysRejected))
+}
@@ -1,58 +1,110 @@
 package main
 
 import (
+	"errors"
 	"log"
-	"net"
 	"sync/atomic"
 	"ripple/auth"
-	"ripple/comm"
+	"ripple/server"
+	"ripple/transport"
 	"ripple/types"
+	"ripple/udpr"
 )
 
-// runServerLoop runs the main server loop, processing incoming datagrams
-func runServerLoop(conn *net.UDPConn, sessionManager *SessionManager, shutdownFlag *int32) {
-	buffer := make([]byte, 393) // Combined buffer size (389 data + 4 ACK)
+// runServerLoop runs the main server loop of a server instance, processing the datagrams received on one of its transports
+func runServerLoop(srv *server.Server, serverTransport transport.Transport, sessionManager *SessionManager, shutdownFlag *int32) {
+	duplicates := udpr.NewDuplicateCache()
+	reassembler := udpr.NewReassembler()
 
 	for {
-		n, remoteAddr, err := conn.ReadFromUDP(buffer)
+		packet, err := serverTransport.Receive()
 		if err != nil {
-			// Check if the error is because of a shutdown (e.g., conn.Close() was called)
+			// Check if the error is because of a shutdown (e.g., the transport was closed)
 			if atomic.LoadInt32(shutdownFlag) != 0 {
 				log.Println("Server is shutting down...")
 				return
 			}
 			// Handle other errors (unexpected issues)
-			log.Printf("Error reading from UDP connection: %v", err)
+			log.Printf("Error reading from the transport: %v", err)
 			continue
 		}
+		remoteAddr := packet.Addr
+		n := len(packet.Data)
 
-		if n != len(buffer) {
-			log.Printf("Unexpected datagram size: received %d bytes, expected %d bytes", n, len(buffer))
+		// Plaintext, encrypted and identity signed datagrams in either layout and fragments of messages are told apart by their size
+		if !types.IsDatagramSize(n) && n != udpr.FragmentSize {
+			log.Printf("Unexpected datagram size: received %d bytes from %s", n, remoteAddr.String())
 			continue
 		}
 
 		log.Printf("Received %d bytes from %s", n, remoteAddr.String())
 
-		// Extract the ACK part (first 4 bytes)
-		ackBuffer := buffer[:4]
-		
-		// Extract the datagram part (remaining bytes)
-		dataBuffer := buffer[4:]
+		// The transmission identifier, which the ACK echoes
+		ackBuffer := packet.ID
+
+		// The datagram part
+		dataBuffer := packet.Data
 
 		// Send an acknowledgment
-		if err := comm.SendAck(conn, remoteAddr, ackBuffer); err != nil {
+		if err := serverTransport.Ack(packet); err != nil {
 			log.Printf("Failed to send ACK: %v", err)
 			continue
 		}
 
+		// A retransmission of a datagram already accepted, sent because the ACK was lost, is dropped once ACKed again
+		sender := remoteAddr.String()
+		if duplicates.IsDuplicate(sender, ackBuffer, dataBuffer) {
+			atomic.AddUint64(&metrics.duplicatesDropped, 1)
+			continue
+		}
+
+		// A fragment is held until the rest of its message has arrived, and the message is then validated as a whole.
+		// Fragments are remembered as seen once the message is accepted, since they are only authenticated as part of it.
+		isMessage := udpr.IsFragment(dataBuffer)
+		var fragments []udpr.ReceivedFragment
+		if isMessage {
+			message, received, err := reassembler.Add(sender, ackBuffer, dataBuffer)
+			if err != nil {
+				log.Printf("Error reassembling message from %s: %v", sender, err)
+				continue
+			}
+			if message == nil {
+				continue
+			}
+			fragments = received
+			if !types.IsMessage(message) {
+				log.Printf("Reassembled message from %s is too small: %d bytes", sender, len(message))
+				continue
+			}
+			dataBuffer = message
+		}
+
+		// Reject malformed usernames and server addresses before they are used to locate anything on disk
+		if err := types.ValidateDatagramIdentifiers(dataBuffer); err != nil {
+			log.Printf("Error validating datagram from %s: %v", remoteAddr.String(), err)
+			continue
+		}
+
 		// Parse the datagram
 		datagram := types.DeserializeDatagram(dataBuffer)
 
 		// Validate the datagram
-		if err := auth.ValidateDatagram(dataBuffer, datagram); err != nil {
-			log.Printf("Error validating datagram: %v", err)
+		if err := auth.ValidateDatagram(srv.Config, dataBuffer, datagram); err != nil {
+			if errors.Is(err, auth.ErrReplayDetected) {
+				atomic.AddUint64(&metrics.replaysRejected, 1)
+				log.Printf("Rejected replayed datagram from %s: %v", sender, err)
+			} else {
+				log.Printf("Error validating datagram: %v", err)
+			}
 			continue
 		}
+		if isMessage {
+			for _, fragment := range fragments {
+				duplicates.MarkSeen(sender, fragment.ID, fragment.Data)
+			}
+		} else {
+			duplicates.MarkSeen(sender, ackBuffer, dataBuffer)
+		}
 
 		// Create a new session
 		session := &types.Session{
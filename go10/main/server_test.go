@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"sync/atomic"
+	"testing"
+	"time"
+	"ripple/commands"
+	"ripple/database"
+	"ripple/database/db_trustlines"
+	"ripple/server"
+	"ripple/testutil"
+	"ripple/transport"
+	"ripple/types"
+)
+
+// testSecretKey is the secret key of every account and of every pair of peer accounts in the tests
+var testSecretKey = []byte("0123456789abcdef0123456789abcdef")
+
+// newTestServer creates a server instance with a data directory of its own, holding an account with one peer account
+// at another server, and runs it on a memory network until the test ends.
+func newTestServer(t *testing.T, network *transport.MemoryNetwork, serverAddress, username, peerServerAddress, peerUsername string) *server.Server {
+	t.Helper()
+
+	datadir := testutil.NewDataDir(t, serverAddress)
+	testutil.AddAccount(t, datadir, username, testSecretKey)
+	testutil.AddPeer(t, datadir, username, peerServerAddress, peerUsername, testSecretKey, 1)
+
+	cfg := testutil.LoadConfig(t, datadir)
+	serverTransport, err := network.Listen(serverAddress + ":2012")
+	if err != nil {
+		t.Fatalf("listening at %s: %v", serverAddress, err)
+	}
+
+	srv := server.NewServer(cfg)
+	var shutdownFlag int32
+	done := make(chan error, 1)
+	go func() {
+		done <- runServer(srv, []transport.Transport{serverTransport}, &shutdownFlag)
+	}()
+	t.Cleanup(func() {
+		atomic.StoreInt32(&shutdownFlag, 1)
+		serverTransport.Close()
+		if err := <-done; err != nil {
+			t.Errorf("running %s: %v", serverAddress, err)
+		}
+	})
+	return srv
+}
+
+// signClientDatagram serializes a client datagram and signs it with HMAC and the account secret key
+func signClientDatagram(t *testing.T, dg *types.Datagram) []byte {
+	t.Helper()
+	dg.Arguments[types.SignatureVersionIndex] = types.SignatureVersionHMAC
+	buf, err := types.SerializeDatagram(dg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, testSecretKey)
+	mac.Write(buf[:len(buf)-32])
+	copy(buf[len(buf)-32:], mac.Sum(nil))
+	return buf
+}
+
+// TestTwoServers sets a trustline from an account on one server to an account on another, both running in the
+// test on a memory network, and syncs it: alpha sends it to beta, which records it and confirms it back to alpha.
+func TestTwoServers(t *testing.T) {
+	network := transport.NewMemoryNetwork(1, transport.Conditions{Delay: 10 * time.Millisecond, Jitter: 5 * time.Millisecond})
+	alpha := newTestServer(t, network, "alpha.test", "alice", "beta.test", "bob")
+	beta := newTestServer(t, network, "beta.test", "bob", "alpha.test", "alice")
+
+	client, err := network.Listen("client.test:2012")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Deliver the packets in flight until the test ends, the servers send from goroutines of their own
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+				network.Flush()
+			}
+		}
+	}()
+
+	// Acknowledge the responses of alpha, and hand them to the test
+	responses := make(chan []byte, 16)
+	go func() {
+		for {
+			packet, err := client.Receive()
+			if err != nil {
+				return
+			}
+			client.Ack(packet)
+			responses <- packet.Data
+		}
+	}()
+
+	alphaAddr, err := client.Resolve("alpha.test:2012")
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(command byte, counter uint32, arguments []byte) {
+		t.Helper()
+		dg := &types.Datagram{Command: command, Username: "alice", PeerUsername: "bob", PeerServerAddress: "beta.test", Counter: counter}
+		copy(dg.Arguments[:], arguments)
+		if err := client.SendReliable(alphaAddr, signClientDatagram(t, dg), 10*time.Second); err != nil {
+			t.Fatalf("sending command %d: %v", command, err)
+		}
+		select {
+		case response := <-responses:
+			if response[0] != 0 {
+				t.Fatalf("command %d failed: %s", command, types.BytesToString(response[1:]))
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("no response to command %d", command)
+		}
+	}
+
+	send(commands.ClientTrustlines_SetTrustline, 1, types.NewArgumentWriter().Amount(500).Int64(0).Currency("").Bytes())
+	send(commands.ClientTrustlines_SyncTrustlineOut, 2, types.NewArgumentWriter().Currency("").Bytes())
+
+	// Wait for beta to confirm the trustline, which alpha records as the sync_out of the trustline
+	outgoing := &types.Datagram{Username: "alice", PeerUsername: "bob", PeerServerAddress: "beta.test"}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		syncOut, err := db_trustlines.GetSyncOut(alpha.Config, outgoing, "")
+		if err == nil && syncOut == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("alpha did not receive the confirmation of the trustline: sync_out %d, %v", syncOut, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	trustlineIn, err := db_trustlines.GetTrustlineIn(beta.Config, "bob", "alpha.test", "alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trustlineIn != 500 {
+		t.Errorf("trustline from alice at beta is %d, want 500", trustlineIn)
+	}
+	if _, err := database.LoadSecretKey(beta.Config, "alice"); err == nil {
+		t.Errorf("account of alpha found in the data directory of beta")
+	}
+	if network.Now() == 0 {
+		t.Errorf("virtual time did not advance on the memory network")
+	}
+}
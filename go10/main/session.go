@@ -4,45 +4,62 @@ import (
 	"log"
 	"sync"
 	"ripple/auth"
-	"ripple/comm"
+	"ripple/database"
+	"ripple/server"
 	"ripple/types"
 )
 
-// SessionManager manages sessions and their state
+// SessionManager manages sessions and their state. Everything that changes the state of an account, sessions
+// and background tasks alike, runs through it, one at a time per account. Each server instance has its own.
 type SessionManager struct {
+	srv            *server.Server
 	activeHandlers map[string]bool
-	queues         map[string][]*types.Session
+	queues         map[string][]func()
 	mu             sync.Mutex
 	wg             sync.WaitGroup
 }
 
-// NewSessionManager creates a new SessionManager
-func NewSessionManager() *SessionManager {
+// NewSessionManager creates a new SessionManager for a server instance
+func NewSessionManager(srv *server.Server) *SessionManager {
 	return &SessionManager{
+		srv:            srv,
 		activeHandlers: make(map[string]bool),
-		queues:         make(map[string][]*types.Session),
+		queues:         make(map[string][]func()),
 	}
 }
 
 // RouteSession routes a new session or queues it if a handler is already active
 func (sm *SessionManager) RouteSession(session *types.Session) {
+	sm.RunForAccount(session.Datagram.Username, func() {
+		sm.handleSession(session)
+	})
+}
+
+// RunForAccount runs a task for an account once no session or other task is active for it, so it cannot
+// interleave with a handler changing the same files
+func (sm *SessionManager) RunForAccount(username string, task func()) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
 	sm.wg.Add(1)
 
-	username := session.Datagram.Username
 	if !sm.activeHandlers[username] {
-		// No active handler, process session immediately
+		// No active handler, process the task immediately
 		sm.activeHandlers[username] = true
-		go sm.handleSession(session)
+		go sm.run(username, task)
 	} else {
-		// Active handler exists, queue the session
-		sm.queues[username] = append(sm.queues[username], session)
+		// Active handler exists, queue the task
+		sm.queues[username] = append(sm.queues[username], task)
 	}
 }
 
-// CloseSession processes the next session in the queue after a session finishes
+// run runs a task and then triggers the next one
+func (sm *SessionManager) run(username string, task func()) {
+	defer sm.CloseSession(username)
+	task()
+}
+
+// CloseSession processes the next task in the queue after a task finishes
 func (sm *SessionManager) CloseSession(username string) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
@@ -50,24 +67,23 @@ func (sm *SessionManager) CloseSession(username string) {
 	sm.wg.Done()
 
 	if queue, exists := sm.queues[username]; exists && len(queue) > 0 {
-		// Process the next session in the queue
-		nextSession := queue[0]
+		// Process the next task in the queue
+		next := queue[0]
 		sm.queues[username] = queue[1:]
-		go sm.handleSession(nextSession)
+		go sm.run(username, next)
 	} else {
-		// No more sessions in the queue, mark handler as inactive
+		// No more tasks in the queue, mark handler as inactive
 		delete(sm.activeHandlers, username)
+		delete(sm.queues, username)
 	}
 }
 
-// handleSession processes a session and then triggers the next one
+// handleSession processes a session
 func (sm *SessionManager) handleSession(session *types.Session) {
 	datagram := session.Datagram
 	command := datagram.Command
 	username := datagram.Username
 
-	defer sm.CloseSession(username)
-
 	// Handle the session here (processing logic)
 	log.Printf("Handling session for user: %s\n", username)
 
@@ -75,18 +91,36 @@ func (sm *SessionManager) handleSession(session *types.Session) {
 	// If this is a client connection, check that peer account exists
 	// But only if the command included a peer.
 	if command&0x80 == 0 && datagram.PeerUsername != "" { // Bit 7 (MSB) is 0
-	    if errorMessage, err := auth.ValidatePeerExists(datagram); err != nil {
+	    if errorMessage, err := auth.ValidatePeerExists(sm.srv.Config, datagram); err != nil {
 	        log.Printf("Error validating peer existence for user %s: %v", username, err)
-	        comm.SendErrorResponse(session.Addr, errorMessage)
+	        sm.srv.Endpoint.SendErrorResponse(session.Addr, errorMessage)
 	        return
 	    }
 	}
 	
+	// If this is a client connection, check that the account policy allows the command
+	if command&0x80 == 0 {
+		// Pushed events follow the client to the address it last sent from
+		sm.srv.Endpoint.RefreshSubscription(username, session.Addr)
+
+		policy, err := database.LoadAccountPolicy(sm.srv.Config, username)
+		if err != nil {
+			log.Printf("Error loading account policy for user %s: %v", username, err)
+			sm.srv.Endpoint.SendErrorResponse(session.Addr, "Failed to load account policy.")
+			return
+		}
+		if !policy.AllowsCommand(command) {
+			log.Printf("Command %d not allowed by account policy for user %s", command, username)
+			sm.srv.Endpoint.SendErrorResponse(session.Addr, "Command not allowed by account policy.")
+			return
+		}
+	}
+
 	handler := commandHandlers[command]
 	if handler == nil {
 		log.Printf("Unknown command: %d\n", command)
 		return
 	}
 	
-	handler(*session)
+	handler(sm.srv, *session)
 }
@@ -0,0 +1,269 @@
+package outbox
+
+import (
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "ripple/auth"
+    "ripple/comm"
+    "ripple/commands"
+    "ripple/config"
+    "ripple/database"
+    "ripple/types"
+)
+
+const (
+    initialBackoff = 30 * time.Second // Wait after the first failed delivery
+    maxBackoff     = 10 * time.Minute // Longest wait between deliveries
+    maxDeliveries  = 16               // Deliveries in progress at once, across all destinations
+)
+
+// message is a signed datagram waiting to be delivered, persisted in the outbox directory of its destination server
+// unless it is only useful for a short while
+type message struct {
+    filename string // Empty if the message is only kept in memory
+    data     []byte
+    queuedAt time.Time
+    maxAge   time.Duration // How long the message is retried before it is dropped
+    onDone   func(error)   // Called once delivered or given up on, nil if nobody is waiting
+}
+
+// destination is the queue of messages to one server, delivered in order, one message at a time. Once its next message
+// can be sent it waits in the ready list for one of the delivery workers, and while the server is unreachable it waits
+// on a timer without holding a worker, so servers that are unreachable do not hold up the others.
+type destination struct {
+    serverAddress string
+    queue         []*message
+    scheduled     bool          // In the ready list, being delivered by a worker, or backing off
+    backoff       time.Duration // Wait after the next failed delivery, zero until a delivery fails
+}
+
+// Outbox queues the server-to-server datagrams of a server instance, in the outbox directory of its data directory,
+// and delivers them through its endpoint
+type Outbox struct {
+    sequence       uint64 // First, so it is aligned for atomic access on 32-bit platforms
+    config         *config.Config
+    endpoint       *comm.Endpoint
+    initialBackoff time.Duration // Wait after the first failed delivery, shortened by the tests
+    maxDeliveries  int           // Delivery workers running at once, lowered by the tests
+    mu             sync.Mutex
+    destinations   map[string]*destination
+    ready          []*destination // Destinations whose next message can be sent, in the order they became ready
+    workers        int            // Delivery workers running
+}
+
+// NewOutbox initializes the Outbox of a server instance. Nothing is delivered before Start is called.
+func NewOutbox(cfg *config.Config, endpoint *comm.Endpoint) *Outbox {
+    return &Outbox{
+        config:         cfg,
+        endpoint:       endpoint,
+        initialBackoff: initialBackoff,
+        maxDeliveries:  maxDeliveries,
+        destinations:   make(map[string]*destination),
+    }
+}
+
+// isPathfinding checks if a datagram is a pathfinding request, which is useless once the pathfinding entries it is for
+// have expired, so it is kept in memory and dropped after PathFindingTimeout instead of persisted for OutboxMaxAge
+func isPathfinding(dg *types.Datagram) bool {
+    switch dg.Command {
+    case commands.ServerPayments_FindPathOut, commands.ServerPayments_FindPathIn, commands.ServerPayments_PathRecurse:
+        return true
+    }
+    return false
+}
+
+// GetOutboxDir constructs the outbox directory of a destination server and returns it
+func (o *Outbox) GetOutboxDir(serverAddress string) string {
+    return filepath.Join(o.config.GetDataDir(), "outbox", types.EncodeServerAddress(serverAddress))
+}
+
+// SealAndSend signs or encrypts a datagram and queues it for delivery to a peer server. It returns once the datagram
+// is on disk, so it is delivered even if the server restarts, except for pathfinding requests, which are only queued
+// in memory. onDone, if not nil, is called with the outcome.
+func (o *Outbox) SealAndSend(dg *types.Datagram, peerServerAddress string, onDone func(error)) error {
+    data, err := auth.SealDatagram(o.config, dg, peerServerAddress)
+    if err != nil {
+        return fmt.Errorf("failed to create signed datagram: %w", err)
+    }
+    if isPathfinding(dg) {
+        o.enqueue(peerServerAddress, &message{data: data, queuedAt: time.Now(), maxAge: config.PathFindingTimeout, onDone: onDone})
+        return nil
+    }
+    return o.Send(peerServerAddress, data, onDone)
+}
+
+// Send queues serialized data for delivery to a server, and persists it first.
+func (o *Outbox) Send(serverAddress string, data []byte, onDone func(error)) error {
+    outboxDir := o.GetOutboxDir(serverAddress)
+    if err := os.MkdirAll(outboxDir, 0755); err != nil {
+        return fmt.Errorf("error creating outbox directory %s: %w", outboxDir, err)
+    }
+
+    // Name files so they sort in the order they were queued
+    filename := fmt.Sprintf("%020d-%06d.dg", time.Now().UnixNano(), atomic.AddUint64(&o.sequence, 1)%1000000)
+    if err := database.WriteFileDurable(outboxDir, filename, data); err != nil {
+        return fmt.Errorf("error writing to outbox: %w", err)
+    }
+
+    o.enqueue(serverAddress, &message{filename: filename, data: data, queuedAt: time.Now(), maxAge: config.OutboxMaxAge, onDone: onDone})
+    return nil
+}
+
+// Start queues the messages left in the outbox by a previous run, and starts delivering them.
+func (o *Outbox) Start() error {
+    outboxRoot := filepath.Join(o.config.GetDataDir(), "outbox")
+    serverDirs, err := os.ReadDir(outboxRoot)
+    if os.IsNotExist(err) {
+        return nil
+    } else if err != nil {
+        return fmt.Errorf("unable to read directory %s: %v", outboxRoot, err)
+    }
+
+    for _, serverDir := range serverDirs {
+        if !serverDir.IsDir() {
+            continue
+        }
+        serverAddress, err := types.DecodeServerAddress(serverDir.Name())
+        if err != nil {
+            return err
+        }
+        outboxDir := o.GetOutboxDir(serverAddress)
+        entries, err := os.ReadDir(outboxDir)
+        if err != nil {
+            return fmt.Errorf("unable to read directory %s: %v", outboxDir, err)
+        }
+
+        var filenames []string
+        for _, entry := range entries {
+            if filepath.Ext(entry.Name()) == ".dg" {
+                filenames = append(filenames, entry.Name())
+            }
+        }
+        sort.Strings(filenames)
+
+        for _, filename := range filenames {
+            data, err := database.ReadFile(outboxDir, filename)
+            if err != nil {
+                return err
+            }
+            queuedAt := time.Now()
+            if info, err := os.Stat(filepath.Join(outboxDir, filename)); err == nil {
+                queuedAt = info.ModTime()
+            }
+            o.enqueue(serverAddress, &message{filename: filename, data: data, queuedAt: queuedAt, maxAge: config.OutboxMaxAge})
+        }
+        if len(filenames) > 0 {
+            log.Printf("Queued %d datagrams left in the outbox for %s", len(filenames), serverAddress)
+        }
+    }
+    return nil
+}
+
+// enqueue adds a message to the queue of its destination, and schedules the destination if it is idle
+func (o *Outbox) enqueue(serverAddress string, msg *message) {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+
+    dest, exists := o.destinations[serverAddress]
+    if !exists {
+        dest = &destination{serverAddress: serverAddress}
+        o.destinations[serverAddress] = dest
+    }
+    dest.queue = append(dest.queue, msg)
+    if !dest.scheduled {
+        dest.scheduled = true
+        o.makeReady(dest)
+    }
+}
+
+// makeReady adds a destination to the ready list, and starts a delivery worker unless maxDeliveries are running.
+// It is called with o.mu held.
+func (o *Outbox) makeReady(dest *destination) {
+    o.ready = append(o.ready, dest)
+    if o.workers < o.maxDeliveries {
+        o.workers++
+        go o.work()
+    }
+}
+
+// work delivers the next message of whichever destination is ready first, until no destination is ready
+func (o *Outbox) work() {
+    for {
+        o.mu.Lock()
+        if len(o.ready) == 0 {
+            o.workers--
+            o.mu.Unlock()
+            return
+        }
+        dest := o.ready[0]
+        o.ready = o.ready[1:]
+        msg := dest.queue[0]
+        o.mu.Unlock()
+
+        o.deliver(dest, msg)
+    }
+}
+
+// deliver sends the next message queued for a server. Once it is delivered or given up on, the destination is ready
+// again with its following message, at the back of the ready list so the other destinations get their turn, and
+// while the server is unreachable it is ready again after a backoff.
+func (o *Outbox) deliver(dest *destination, msg *message) {
+    serverAddress := dest.serverAddress
+
+    // A message that waited behind others for longer than it is useful is dropped without sending it
+    var err error
+    if time.Since(msg.queuedAt) < msg.maxAge {
+        err = o.endpoint.SendWithResolvedAddress(serverAddress, msg.data, comm.LowImportance)
+    } else {
+        err = fmt.Errorf("expired after %v in the outbox", msg.maxAge)
+    }
+
+    if err != nil && time.Since(msg.queuedAt) < msg.maxAge {
+        o.mu.Lock()
+        if dest.backoff == 0 {
+            dest.backoff = o.initialBackoff
+        }
+        backoff := dest.backoff
+        if dest.backoff *= 2; dest.backoff > maxBackoff {
+            dest.backoff = maxBackoff
+        }
+        o.mu.Unlock()
+
+        log.Printf("Delivery to %s failed, retrying in %v: %v", serverAddress, backoff, err)
+        time.AfterFunc(backoff, func() {
+            o.mu.Lock()
+            o.makeReady(dest)
+            o.mu.Unlock()
+        })
+        return
+    }
+    if err != nil {
+        log.Printf("Giving up on delivering datagram to %s queued at %v: %v", serverAddress, msg.queuedAt, err)
+    }
+
+    // Delivered or given up on, remove it from the outbox
+    if msg.filename != "" {
+        if removeErr := os.Remove(filepath.Join(o.GetOutboxDir(serverAddress), msg.filename)); removeErr != nil {
+            log.Printf("Error removing delivered datagram from the outbox for %s: %v", serverAddress, removeErr)
+        }
+    }
+    o.mu.Lock()
+    dest.queue = dest.queue[1:]
+    dest.backoff = 0
+    if len(dest.queue) > 0 {
+        o.makeReady(dest)
+    } else {
+        dest.scheduled = false
+    }
+    o.mu.Unlock()
+    if msg.onDone != nil {
+        msg.onDone(err)
+    }
+}
@@ -0,0 +1,264 @@
+package outbox
+
+import (
+    "bytes"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "ripple/comm"
+    "ripple/config"
+    "ripple/testutil"
+    "ripple/transport"
+    "ripple/types"
+)
+
+// newTestOutbox creates an outbox for a server at alpha.test on a memory network, retrying after a few milliseconds
+func newTestOutbox(t *testing.T, network *transport.MemoryNetwork, datadir string) *Outbox {
+    t.Helper()
+
+    testutil.WriteFile(t, datadir, "server_address.txt", "alpha.test")
+    cfg := testutil.LoadConfig(t, datadir)
+    serverTransport, err := network.Listen("alpha.test:2012")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { serverTransport.Close() })
+
+    // Route the ACKs to the waiting sends
+    go func() {
+        for {
+            if _, err := serverTransport.Receive(); err != nil {
+                return
+            }
+        }
+    }()
+
+    endpoint := comm.NewEndpoint(cfg)
+    endpoint.AddTransport(serverTransport)
+    o := NewOutbox(cfg, endpoint)
+    o.initialBackoff = 10 * time.Millisecond
+    return o
+}
+
+// runNetwork delivers the packets in flight on a memory network until the test ends
+func runNetwork(t *testing.T, network *transport.MemoryNetwork) {
+    stop := make(chan struct{})
+    t.Cleanup(func() { close(stop) })
+    go func() {
+        for {
+            select {
+            case <-stop:
+                return
+            case <-time.After(time.Millisecond):
+                network.Flush()
+            }
+        }
+    }()
+}
+
+// listenTestPeer listens at beta.test on a memory network, and hands the datagrams it receives to the test
+func listenTestPeer(t *testing.T, network *transport.MemoryNetwork) <-chan []byte {
+    t.Helper()
+
+    peerTransport, err := network.Listen("beta.test:2012")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { peerTransport.Close() })
+
+    received := make(chan []byte, 16)
+    go func() {
+        for {
+            packet, err := peerTransport.Receive()
+            if err != nil {
+                return
+            }
+            peerTransport.Ack(packet)
+            received <- packet.Data
+        }
+    }()
+    return received
+}
+
+// testDatagram returns the data of a datagram in the tests, told apart by its first byte
+func testDatagram(index byte) []byte {
+    data := make([]byte, types.DatagramSize)
+    data[0] = index
+    return data
+}
+
+// expectDatagrams waits for datagrams to arrive in order
+func expectDatagrams(t *testing.T, received <-chan []byte, want ...[]byte) {
+    t.Helper()
+    for _, data := range want {
+        select {
+        case got := <-received:
+            if !bytes.Equal(got, data) {
+                t.Fatalf("received datagram %d, want %d", got[0], data[0])
+            }
+        case <-time.After(10 * time.Second):
+            t.Fatalf("datagram %d not delivered", data[0])
+        }
+    }
+}
+
+// waitForEmptyOutbox waits until the outbox directory of a server holds no datagrams
+func waitForEmptyOutbox(t *testing.T, o *Outbox, serverAddress string) {
+    t.Helper()
+    deadline := time.Now().Add(10 * time.Second)
+    for {
+        entries, err := os.ReadDir(o.GetOutboxDir(serverAddress))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(entries) == 0 {
+            return
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("%d datagrams left in the outbox", len(entries))
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestOutboxDeliversInOrder(t *testing.T) {
+    network := transport.NewMemoryNetwork(1, transport.Conditions{Jitter: 5 * time.Millisecond})
+    o := newTestOutbox(t, network, t.TempDir())
+    received := listenTestPeer(t, network)
+    runNetwork(t, network)
+
+    done := make(chan error, 3)
+    for index := byte(1); index <= 3; index++ {
+        if err := o.Send("beta.test", testDatagram(index), func(err error) { done <- err }); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    expectDatagrams(t, received, testDatagram(1), testDatagram(2), testDatagram(3))
+    for i := 0; i < 3; i++ {
+        if err := <-done; err != nil {
+            t.Errorf("delivery reported %v", err)
+        }
+    }
+    waitForEmptyOutbox(t, o, "beta.test")
+}
+
+func TestOutboxRetriesUnreachableServer(t *testing.T) {
+    network := transport.NewMemoryNetwork(1, transport.Conditions{})
+    o := newTestOutbox(t, network, t.TempDir())
+    runNetwork(t, network)
+
+    done := make(chan error, 1)
+    if err := o.Send("beta.test", testDatagram(1), func(err error) { done <- err }); err != nil {
+        t.Fatal(err)
+    }
+
+    // Nobody listens at beta.test yet, so the first deliveries fail and are retried
+    time.Sleep(50 * time.Millisecond)
+    select {
+    case err := <-done:
+        t.Fatalf("delivery to an unreachable server finished with %v", err)
+    default:
+    }
+
+    received := listenTestPeer(t, network)
+    expectDatagrams(t, received, testDatagram(1))
+    if err := <-done; err != nil {
+        t.Errorf("delivery reported %v", err)
+    }
+    waitForEmptyOutbox(t, o, "beta.test")
+}
+
+func TestOutboxDeliversAfterRestart(t *testing.T) {
+    datadir := t.TempDir()
+
+    // Queue datagrams while beta.test is unreachable, then stop the server before they are delivered
+    network := transport.NewMemoryNetwork(1, transport.Conditions{})
+    o := newTestOutbox(t, network, datadir)
+    o.initialBackoff = time.Hour
+    for index := byte(1); index <= 2; index++ {
+        if err := o.Send("beta.test", testDatagram(index), nil); err != nil {
+            t.Fatal(err)
+        }
+    }
+    entries, err := os.ReadDir(o.GetOutboxDir("beta.test"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(entries) != 2 {
+        t.Fatalf("%d datagrams persisted in the outbox, want 2", len(entries))
+    }
+
+    // A new outbox for the same data directory delivers them on another network, where beta.test is reachable
+    restarted := transport.NewMemoryNetwork(2, transport.Conditions{})
+    received := listenTestPeer(t, restarted)
+    runNetwork(t, restarted)
+    o = newTestOutbox(t, restarted, datadir)
+    if err := o.Start(); err != nil {
+        t.Fatal(err)
+    }
+
+    expectDatagrams(t, received, testDatagram(1), testDatagram(2))
+    waitForEmptyOutbox(t, o, "beta.test")
+}
+
+func TestOutboxDropsExpiredDatagrams(t *testing.T) {
+    network := transport.NewMemoryNetwork(1, transport.Conditions{})
+    o := newTestOutbox(t, network, t.TempDir())
+    received := listenTestPeer(t, network)
+    runNetwork(t, network)
+
+    // A datagram left in the outbox for longer than it is kept is removed without sending it
+    outboxDir := o.GetOutboxDir("beta.test")
+    if err := os.MkdirAll(outboxDir, 0755); err != nil {
+        t.Fatal(err)
+    }
+    expiredPath := filepath.Join(outboxDir, "00000000000000000001-000001.dg")
+    if err := os.WriteFile(expiredPath, testDatagram(1), 0644); err != nil {
+        t.Fatal(err)
+    }
+    queuedAt := time.Now().Add(-config.OutboxMaxAge - time.Minute)
+    if err := os.Chtimes(expiredPath, queuedAt, queuedAt); err != nil {
+        t.Fatal(err)
+    }
+    if err := o.Start(); err != nil {
+        t.Fatal(err)
+    }
+    if err := o.Send("beta.test", testDatagram(2), nil); err != nil {
+        t.Fatal(err)
+    }
+
+    expectDatagrams(t, received, testDatagram(2))
+    waitForEmptyOutbox(t, o, "beta.test")
+}
+
+func TestOutboxUnreachableServerHoldsNoWorker(t *testing.T) {
+    network := transport.NewMemoryNetwork(1, transport.Conditions{})
+    o := newTestOutbox(t, network, t.TempDir())
+    o.maxDeliveries = 1
+    o.initialBackoff = time.Hour
+    received := listenTestPeer(t, network)
+    runNetwork(t, network)
+
+    // Nobody listens at gamma.test, so its datagram backs off for an hour, without the only worker waiting for it
+    if err := o.Send("gamma.test", testDatagram(1), nil); err != nil {
+        t.Fatal(err)
+    }
+    for index := byte(2); index <= 3; index++ {
+        if err := o.Send("beta.test", testDatagram(index), nil); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    expectDatagrams(t, received, testDatagram(2), testDatagram(3))
+    waitForEmptyOutbox(t, o, "beta.test")
+
+    o.mu.Lock()
+    workers := o.workers
+    o.mu.Unlock()
+    if workers > 1 {
+        t.Errorf("%d delivery workers running, want at most 1", workers)
+    }
+}
@@ -3,19 +3,9 @@ package pathfinding
 import (
     "sync"
     "time"
-)
-
-var pathManager *PathManager
-
-func GetPathManager() *PathManager {
-    return pathManager
-}
 
-func InitPathManager() {
-    pathManager = &PathManager{
-        Accounts: make(map[string]*Account), // Properly initialize the map.
-    }
-}
+    "ripple/types"
+)
 
 // PathManager manages all Account entries in a system.
 type PathManager struct {
@@ -23,6 +13,13 @@ type PathManager struct {
     mu       sync.Mutex          // Protects the Accounts map.
 }
 
+// NewPathManager initializes the PathManager of a server instance
+func NewPathManager() *PathManager {
+    return &PathManager{
+        Accounts: make(map[string]*Account), // Properly initialize the map.
+    }
+}
+
 // Add creates a new account every time, overwriting any existing one.
 func (pm *PathManager) Add(username string) *Account {
     pm.mu.Lock()
@@ -64,8 +61,8 @@ func (pm *PathManager) Cleanup() {
 }
 
 // Add creates and adds a new Path to an Account and returns it.
-func (account *Account) Add(identifier string, amount uint32, incoming, outgoing PeerAccount) *Path {
-    newPath := NewPath(identifier, amount, incoming, outgoing)
+func (account *Account) Add(identifier string, amount types.Amount, currency string, incoming, outgoing PeerAccount) *Path {
+    newPath := NewPath(identifier, amount, currency, incoming, outgoing)
     account.Paths[identifier] = newPath
     return newPath
 }
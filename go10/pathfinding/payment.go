@@ -1,5 +1,7 @@
 package pathfinding
 
+import "ripple/types"
+
 func (pm *PathManager) CleanupCacheAndFetchAccount(username string) *Account {
     // Cleanup all accounts first
     pm.Cleanup()
@@ -13,7 +15,7 @@ func (pm *PathManager) CleanupCacheAndFetchAccount(username string) *Account {
 }
 
 // InitiatePayment sets up or updates payment details for an account, creating the account if necessary.
-func (pm *PathManager) InitiatePayment(username string, payment *Payment, amount uint32) {
+func (pm *PathManager) InitiatePayment(username string, payment *Payment, amount types.Amount) {
     // Fetch or create the account, with any necessary cleanup
     account := pm.CleanupCacheAndFetchAccount(username)
 
@@ -26,5 +28,5 @@ func (pm *PathManager) InitiatePayment(username string, payment *Payment, amount
     account.Payment = payment
 
     // Add or update the related Path entry with a new timestamp
-    account.Add(payment.Identifier, amount, PeerAccount{}, PeerAccount{})  // No PeerAccount details needed
+    account.Add(payment.Identifier, amount, payment.Currency, PeerAccount{}, PeerAccount{})  // No PeerAccount details needed
 }
@@ -24,19 +24,21 @@ func NewPeerAccount(username, serverAddress string) PeerAccount {
 type Path struct {
     Identifier   string          // Identifier for the path
     Timeout      time.Time       // Direct expiration time for the path
-    Amount       uint32
+    Amount       types.Amount
+    Currency     string          // Currency the path is searched for, empty for the default unit of account
     Incoming     PeerAccount     // Details of the incoming peer
     Outgoing     PeerAccount     // Details of the outgoing peer
     Commit       bool
     Depth        uint32
 }
 
-// NewPath is a constructor for creating a Path struct based on an identifier, incoming and outgoing PeerAccount, and amount.
-func NewPath(identifier string, amount uint32, incoming, outgoing PeerAccount) *Path {
+// NewPath is a constructor for creating a Path struct based on an identifier, incoming and outgoing PeerAccount, amount and currency.
+func NewPath(identifier string, amount types.Amount, currency string, incoming, outgoing PeerAccount) *Path {
     return &Path{
         Identifier:   identifier,
         Timeout:      time.Now().Add(config.PathFindingTimeout), // Set the Timeout using PathFindingTimeout
         Amount:       amount,                                   // Set the amount
+        Currency:     currency,
         Incoming:     incoming,
         Outgoing:     outgoing,
     }
@@ -65,10 +67,11 @@ type Payment struct {
     Counterpart PeerAccount
     InOrOut     byte  // 0 for incoming, 1 for outgoing, stored as a single byte
     Nonce       uint32
+    Currency    string
 }
 
 // NewPayment is a constructor for creating a Payment struct based on an identifier, datagram, and inOrOut value.
-func NewPayment(datagram *types.Datagram, identifier string, inOrOut byte, nonce uint32) *Payment {
+func NewPayment(datagram *types.Datagram, identifier string, inOrOut byte, nonce uint32, currency string) *Payment {
     // Initialize and return the Payment struct, using NewPeerAccount for the Counterpart field
     return &Payment{
         Identifier: identifier,
@@ -78,5 +81,6 @@ func NewPayment(datagram *types.Datagram, identifier string, inOrOut byte, nonce
         ),
         InOrOut: inOrOut,
         Nonce: nonce,
+        Currency: currency,
     }
 }
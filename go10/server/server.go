@@ -0,0 +1,30 @@
+package server
+
+import (
+    "ripple/comm"
+    "ripple/config"
+    "ripple/outbox"
+    "ripple/pathfinding"
+)
+
+// Server is one server instance: its configuration and data directory, the endpoint it sends and receives on,
+// the outbox of its server-to-server datagrams and its path finding state. Handlers are given the instance a
+// datagram arrived at, so several instances can run in one process.
+type Server struct {
+    Config   *config.Config
+    Endpoint *comm.Endpoint
+    Outbox   *outbox.Outbox
+    Paths    *pathfinding.PathManager
+}
+
+// NewServer initializes a server instance with a loaded configuration. Its endpoint has no transports yet,
+// and its outbox delivers nothing until started.
+func NewServer(cfg *config.Config) *Server {
+    endpoint := comm.NewEndpoint(cfg)
+    return &Server{
+        Config:   cfg,
+        Endpoint: endpoint,
+        Outbox:   outbox.NewOutbox(cfg, endpoint),
+        Paths:    pathfinding.NewPathManager(),
+    }
+}
@@ -0,0 +1,63 @@
+// Package testutil creates the data directories of server instances for the tests of the other packages
+package testutil
+
+import (
+    "os"
+    "path/filepath"
+    "strconv"
+    "testing"
+
+    "ripple/config"
+    "ripple/types"
+)
+
+// NewDataDir creates a data directory for a server at serverAddress, removed when the test ends
+func NewDataDir(t testing.TB, serverAddress string) string {
+    t.Helper()
+    datadir := t.TempDir()
+    WriteFile(t, datadir, "server_address.txt", serverAddress)
+    return datadir
+}
+
+// WriteFile writes a file at a path in a data directory, creating the directories it is in
+func WriteFile(t testing.TB, datadir, path, contents string) {
+    t.Helper()
+    fullPath := filepath.Join(datadir, path)
+    if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(fullPath, []byte(contents), 0644); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// AddAccount creates an account with a secret key and a counter of 0
+func AddAccount(t testing.TB, datadir, username string, secretKey []byte) {
+    t.Helper()
+    accountDir := filepath.Join("accounts", username)
+    WriteFile(t, datadir, filepath.Join(accountDir, "secretkey.txt"), string(secretKey))
+    WriteFile(t, datadir, filepath.Join(accountDir, "counter.txt"), "0")
+}
+
+// AddPeer creates a peer account of an account, with its counter_in at 0 and its counter_out at counterOut, and
+// returns its directory in the data directory. The secret key is only written if it is not nil.
+func AddPeer(t testing.TB, datadir, username, peerServerAddress, peerUsername string, secretKey []byte, counterOut uint32) string {
+    t.Helper()
+    peerDir := filepath.Join("accounts", username, "peers", types.EncodeServerAddress(peerServerAddress), peerUsername)
+    if secretKey != nil {
+        WriteFile(t, datadir, filepath.Join(peerDir, "secretkey.txt"), string(secretKey))
+    }
+    WriteFile(t, datadir, filepath.Join(peerDir, "counter_in.txt"), "0")
+    WriteFile(t, datadir, filepath.Join(peerDir, "counter_out.txt"), strconv.FormatUint(uint64(counterOut), 10))
+    return filepath.Join(datadir, peerDir)
+}
+
+// LoadConfig loads the configuration of a data directory, and fails the test if it does not load
+func LoadConfig(t testing.TB, datadir string) *config.Config {
+    t.Helper()
+    cfg, err := config.LoadConfig(datadir)
+    if err != nil {
+        t.Fatalf("loading configuration from %s: %v", datadir, err)
+    }
+    return cfg
+}
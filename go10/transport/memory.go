@@ -0,0 +1,260 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by a closed in-memory connection
+var ErrClosed = errors.New("connection closed")
+
+// memoryQueueSize is how many packets a connection holds before further packets are dropped, like a full socket buffer
+const memoryQueueSize = 1024
+
+// Conditions are the faults a MemoryNetwork injects into every packet
+type Conditions struct {
+	Loss   float64       // Probability that a packet is lost
+	Delay  time.Duration // Delay of every packet
+	Jitter time.Duration // Extra delay of up to Jitter, which reorders packets sent close together
+}
+
+// MemoryNetwork connects transports in one process, so several servers can run together in tests and simulations.
+// Packets are not delivered as they are sent, but held until the network is stepped: Step delivers the packet due
+// first and advances the virtual clock of the network to its due time, Flush delivers everything in flight.
+// Whether a packet is lost and how long it is delayed is drawn from a hash of the seed, the packet and how often
+// the same packet has been sent before, not from the order in which goroutines happen to send, so a run that
+// sends the same packets gets the same faults and the same order of delivery.
+type MemoryNetwork struct {
+	mu          sync.Mutex
+	seed        int64
+	conditions  Conditions
+	conns       map[string]*memoryConn
+	now         time.Duration // Virtual time, the due time of the last packet delivered
+	pending     []memoryPacket
+	occurrences map[string]uint64 // Times each packet has been sent, so retransmissions draw faults of their own
+}
+
+// NewMemoryNetwork initializes a MemoryNetwork with the conditions and the seed of its faults
+func NewMemoryNetwork(seed int64, conditions Conditions) *MemoryNetwork {
+	return &MemoryNetwork{
+		seed:        seed,
+		conditions:  conditions,
+		conns:       make(map[string]*memoryConn),
+		occurrences: make(map[string]uint64),
+	}
+}
+
+// SetConditions changes the faults injected into packets sent from now on
+func (n *MemoryNetwork) SetConditions(conditions Conditions) {
+	n.mu.Lock()
+	n.conditions = conditions
+	n.mu.Unlock()
+}
+
+// Listen creates the Transport of a server listening at an address on the network, in the "host:port"
+// form that server addresses resolve to.
+func (n *MemoryNetwork) Listen(hostPort string) (Transport, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, exists := n.conns[hostPort]; exists {
+		return nil, fmt.Errorf("address %s already in use", hostPort)
+	}
+	conn := &memoryConn{
+		network: n,
+		addr:    memoryAddr(hostPort),
+		queue:   make(chan memoryPacket, memoryQueueSize),
+		closed:  make(chan struct{}),
+	}
+	n.conns[hostPort] = conn
+	return newReliableTransport("memory", conn, n.resolve), nil
+}
+
+// resolve looks up an address that a connection listens at
+func (n *MemoryNetwork) resolve(hostPort string) (net.Addr, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, exists := n.conns[hostPort]; !exists {
+		return nil, fmt.Errorf("failed to resolve address '%s': no such host", hostPort)
+	}
+	return memoryAddr(hostPort), nil
+}
+
+// Now returns the virtual time of the network, how far Step has advanced it
+func (n *MemoryNetwork) Now() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.now
+}
+
+// Pending returns the number of packets in flight
+func (n *MemoryNetwork) Pending() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.pending)
+}
+
+// Step delivers the packet in flight that is due first, and advances the virtual clock to its due time.
+// Packets due at the same time are delivered in the order of their addresses and contents. It returns false
+// if nothing was in flight. Like UDP, a packet to an address nobody listens at is dropped.
+func (n *MemoryNetwork) Step() bool {
+	n.mu.Lock()
+	if len(n.pending) == 0 {
+		n.mu.Unlock()
+		return false
+	}
+	first := 0
+	for i := 1; i < len(n.pending); i++ {
+		if n.pending[i].before(n.pending[first]) {
+			first = i
+		}
+	}
+	packet := n.pending[first]
+	n.pending = append(n.pending[:first], n.pending[first+1:]...)
+	if packet.due > n.now {
+		n.now = packet.due
+	}
+	conn, exists := n.conns[string(packet.to)]
+	n.mu.Unlock()
+
+	if exists {
+		conn.deliver(packet)
+	}
+	return true
+}
+
+// Flush delivers every packet in flight, in the order Step would, and returns how many were delivered.
+// Packets sent in response while it runs are delivered too.
+func (n *MemoryNetwork) Flush() int {
+	delivered := 0
+	for n.Step() {
+		delivered++
+	}
+	return delivered
+}
+
+// send puts a packet in flight, due after the delay drawn for it, unless it is lost. Like UDP, a lost packet
+// is not reported to the sender.
+func (n *MemoryNetwork) send(from memoryAddr, to net.Addr, data []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	key := string(from) + "\x00" + to.String() + "\x00" + string(data)
+	occurrence := n.occurrences[key]
+	n.occurrences[key] = occurrence + 1
+
+	if n.draw(key, occurrence, 'l') < n.conditions.Loss {
+		return
+	}
+	delay := n.conditions.Delay
+	if n.conditions.Jitter > 0 {
+		delay += time.Duration(n.draw(key, occurrence, 'j') * float64(n.conditions.Jitter))
+	}
+	n.pending = append(n.pending, memoryPacket{
+		from: from,
+		to:   memoryAddr(to.String()),
+		data: append([]byte{}, data...),
+		due:  n.now + delay,
+	})
+}
+
+// draw returns a number in [0, 1) for a fault of a packet, derived from the seed, the packet and its occurrence
+func (n *MemoryNetwork) draw(key string, occurrence uint64, fault byte) float64 {
+	var header [17]byte
+	binary.BigEndian.PutUint64(header[0:8], uint64(n.seed))
+	binary.BigEndian.PutUint64(header[8:16], occurrence)
+	header[16] = fault
+	hash := fnv.New64a()
+	hash.Write(header[:])
+	hash.Write([]byte(key))
+	return float64(hash.Sum64()>>11) / (1 << 53)
+}
+
+// remove stops a closed connection from receiving packets, and frees its address
+func (n *MemoryNetwork) remove(conn *memoryConn) {
+	n.mu.Lock()
+	if n.conns[string(conn.addr)] == conn {
+		delete(n.conns, string(conn.addr))
+	}
+	n.mu.Unlock()
+}
+
+// memoryAddr is an address on a MemoryNetwork
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return "memory" }
+func (a memoryAddr) String() string  { return string(a) }
+
+// memoryPacket is a packet in flight on a MemoryNetwork
+type memoryPacket struct {
+	from memoryAddr
+	to   memoryAddr
+	data []byte
+	due  time.Duration
+}
+
+// before reports whether a packet is delivered before another: the one due first, or else by addresses and contents
+func (p memoryPacket) before(other memoryPacket) bool {
+	if p.due != other.due {
+		return p.due < other.due
+	}
+	if p.from != other.from {
+		return p.from < other.from
+	}
+	if p.to != other.to {
+		return p.to < other.to
+	}
+	return bytes.Compare(p.data, other.data) < 0
+}
+
+// memoryConn is a packet socket on a MemoryNetwork
+type memoryConn struct {
+	network   *MemoryNetwork
+	addr      memoryAddr
+	queue     chan memoryPacket
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// deliver queues a packet for ReadFrom, and drops it if the queue is full or the connection is closed
+func (c *memoryConn) deliver(packet memoryPacket) {
+	select {
+	case <-c.closed:
+	case c.queue <- packet:
+	default:
+	}
+}
+
+func (c *memoryConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case <-c.closed:
+		return 0, nil, ErrClosed
+	case packet := <-c.queue:
+		return copy(p, packet.data), packet.from, nil
+	}
+}
+
+func (c *memoryConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, ErrClosed
+	default:
+	}
+	c.network.send(c.addr, addr, p)
+	return len(p), nil
+}
+
+func (c *memoryConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.network.remove(c)
+	})
+	return nil
+}
@@ -0,0 +1,227 @@
+package transport
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queued returns the number of packets delivered to a transport on a memory network and not yet received
+func queued(t Transport) int {
+	return len(t.(*reliableTransport).conn.(*memoryConn).queue)
+}
+
+// sendConcurrently sends numbered packets from one address to another, each from a goroutine of its own
+func sendConcurrently(network *MemoryNetwork, from, to string, count int) {
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := binary.BigEndian.AppendUint32(nil, uint32(i))
+			network.send(memoryAddr(from), memoryAddr(to), append(data, "packet"...))
+		}(i)
+	}
+	wg.Wait()
+}
+
+// deliveryOrder flushes a network and returns the numbers of the packets received, in the order they were delivered
+func deliveryOrder(t *testing.T, network *MemoryNetwork, receiver Transport) []uint32 {
+	t.Helper()
+	var order []uint32
+	for delivered := network.Flush(); delivered > 0; delivered-- {
+		packet, err := receiver.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		order = append(order, binary.BigEndian.Uint32(packet.ID))
+	}
+	return order
+}
+
+func TestMemoryNetworkIsDeterministic(t *testing.T) {
+	conditions := Conditions{Loss: 0.3, Delay: 10 * time.Millisecond, Jitter: 50 * time.Millisecond}
+
+	var runs [][]uint32
+	for run := 0; run < 3; run++ {
+		network := NewMemoryNetwork(42, conditions)
+		receiver, err := network.Listen("beta.test:2012")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sendConcurrently(network, "alpha.test:2012", "beta.test:2012", 200)
+		runs = append(runs, deliveryOrder(t, network, receiver))
+	}
+
+	first := runs[0]
+	if len(first) == 0 || len(first) == 200 {
+		t.Fatalf("%d of 200 packets delivered with a loss of %v", len(first), conditions.Loss)
+	}
+	reordered := false
+	for i := 1; i < len(first); i++ {
+		if first[i] < first[i-1] {
+			reordered = true
+		}
+	}
+	if !reordered {
+		t.Error("packets were not reordered by the jitter")
+	}
+	for _, run := range runs[1:] {
+		if len(run) != len(first) {
+			t.Fatalf("runs delivered %d and %d packets", len(first), len(run))
+		}
+		for i := range run {
+			if run[i] != first[i] {
+				t.Fatalf("runs differ at delivery %d: packet %d and %d", i, first[i], run[i])
+			}
+		}
+	}
+
+	// Another seed draws other faults
+	network := NewMemoryNetwork(43, conditions)
+	receiver, err := network.Listen("beta.test:2012")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendConcurrently(network, "alpha.test:2012", "beta.test:2012", 200)
+	other := deliveryOrder(t, network, receiver)
+	same := len(other) == len(first)
+	for i := 0; same && i < len(other); i++ {
+		same = other[i] == first[i]
+	}
+	if same {
+		t.Error("another seed delivered the same packets in the same order")
+	}
+}
+
+func TestMemoryNetworkDeliversOnSteps(t *testing.T) {
+	network := NewMemoryNetwork(1, Conditions{Delay: 100 * time.Millisecond})
+	receiver, err := network.Listen("beta.test:2012")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	network.send("alpha.test:2012", memoryAddr("beta.test:2012"), []byte("first packet"))
+	network.send("alpha.test:2012", memoryAddr("beta.test:2012"), []byte("second packet"))
+	network.send("alpha.test:2012", memoryAddr("gamma.test:2012"), []byte("nobody listens"))
+	if network.Pending() != 3 || queued(receiver) != 0 {
+		t.Fatalf("%d packets in flight and %d delivered before a step, want 3 and 0", network.Pending(), queued(receiver))
+	}
+
+	if !network.Step() {
+		t.Fatal("nothing delivered by a step")
+	}
+	if network.Now() != 100*time.Millisecond {
+		t.Errorf("virtual time %v after a step, want %v", network.Now(), 100*time.Millisecond)
+	}
+	if queued(receiver) != 1 {
+		t.Errorf("%d packets delivered by a step, want 1", queued(receiver))
+	}
+
+	// Packets sent after a step are due after the new virtual time
+	network.send("alpha.test:2012", memoryAddr("beta.test:2012"), []byte("third packet"))
+	if delivered := network.Flush(); delivered != 3 {
+		t.Errorf("flush delivered %d packets, want 3", delivered)
+	}
+	if network.Now() != 200*time.Millisecond {
+		t.Errorf("virtual time %v after a flush, want %v", network.Now(), 200*time.Millisecond)
+	}
+	if queued(receiver) != 3 {
+		t.Errorf("%d packets delivered to the listener, want 3, the packet to nobody is dropped", queued(receiver))
+	}
+	if network.Step() {
+		t.Error("step delivered a packet with nothing in flight")
+	}
+}
+
+func TestMemoryNetworkListen(t *testing.T) {
+	network := NewMemoryNetwork(1, Conditions{})
+	listener, err := network.Listen("beta.test:2012")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := network.Listen("beta.test:2012"); err == nil {
+		t.Error("two transports listen at the same address")
+	}
+	if _, err := listener.Resolve("gamma.test:2012"); err == nil {
+		t.Error("address nobody listens at resolved")
+	}
+
+	// Closing frees the address, and makes Receive return
+	listener.Close()
+	if _, err := listener.Receive(); err == nil {
+		t.Error("receive on a closed transport succeeded")
+	}
+	if _, err := network.Listen("beta.test:2012"); err != nil {
+		t.Errorf("listening at a freed address: %v", err)
+	}
+}
+
+func TestMemoryTransportRetransmitsLostPackets(t *testing.T) {
+	network := NewMemoryNetwork(7, Conditions{Loss: 1, Delay: time.Millisecond})
+	sender, err := network.Listen("alpha.test:2012")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	receiver, err := network.Listen("beta.test:2012")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+				network.Flush()
+			}
+		}
+	}()
+
+	// The sender receives to route its ACKs, the receiver acknowledges everything
+	go func() {
+		for {
+			if _, err := sender.Receive(); err != nil {
+				return
+			}
+		}
+	}()
+	received := make(chan []byte, 16)
+	go func() {
+		for {
+			packet, err := receiver.Receive()
+			if err != nil {
+				return
+			}
+			receiver.Ack(packet)
+			received <- packet.Data
+		}
+	}()
+
+	addr, err := sender.Resolve("beta.test:2012")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every packet is lost until the first transmission has gone, so only a retransmission can arrive
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		network.SetConditions(Conditions{Delay: time.Millisecond})
+	}()
+	if err := sender.SendReliable(addr, []byte("datagram"), 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-received:
+		if string(data) != "datagram" {
+			t.Errorf("received %q, want %q", data, "datagram")
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("retransmission not received")
+	}
+}
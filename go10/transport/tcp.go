@@ -0,0 +1,316 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Over TCP, each packet is a frame prefixed with its 2-byte length, so the same datagrams and ACKs
+// are sent as over UDP, and the same retransmission and duplicate detection apply. A server that dials
+// a connection first sends a hello frame with the port it listens on, so the server it dialled sends
+// to it on the same connection rather than dialling one of its own.
+const (
+	frameLengthSize  = 2
+	helloFrameSize   = 2 // The listening port, shorter than any packet
+	tcpDialTimeout   = 10 * time.Second
+	tcpWriteTimeout  = 10 * time.Second
+	tcpIdleTimeout   = 10 * time.Minute // Connections nothing is read from for this long are closed
+	maxAcceptedConns = 512              // Accepted connections open at once, further ones are closed right away
+
+	tcpFirstFrameTimeout    = 10 * time.Second // Accepted connections that send no frame for this long are closed
+	maxAcceptedConnsPerHost = 16               // Accepted connections open at once from one IP address
+)
+
+// tcpConn is a packet socket over TCP connections. There is one connection per remote address, reused for
+// everything sent to it, whether dialled by this server or accepted from a peer server or client, so
+// responses to a client go back on the connection it opened.
+type tcpConn struct {
+	listener net.Listener
+	incoming chan tcpPacket
+	closed   chan struct{}
+
+	mu                sync.Mutex
+	conns             map[string]*tcpStream
+	accepted          int            // Accepted connections open, limited to maxAcceptedConns
+	perHost           map[string]int // Accepted connections open per remote IP address, limited to maxAcceptedConnsPerHost
+	firstFrameTimeout time.Duration  // tcpFirstFrameTimeout, shorter in the tests
+	closeOnce         sync.Once
+}
+
+// tcpPacket is a frame read from a connection
+type tcpPacket struct {
+	from net.Addr
+	data []byte
+}
+
+// tcpStream is a connection, with frames written to it one at a time. It is registered under its remote
+// address, and under the address the remote server listens at once it has said so in a hello frame.
+type tcpStream struct {
+	conn     net.Conn
+	from     net.Addr // The address frames are received from, the listening address of a server once it has said so
+	writeMu  sync.Mutex
+	accepted bool
+	counted  bool   // Counted in the accepted connections open
+	host     string // The remote IP address it is counted under
+	keys     []string
+}
+
+// NewTCPTransport creates the Transport of a TCP listener, and starts accepting connections
+func NewTCPTransport(listener net.Listener) Transport {
+	c := &tcpConn{
+		listener:          listener,
+		incoming:          make(chan tcpPacket, memoryQueueSize),
+		closed:            make(chan struct{}),
+		conns:             make(map[string]*tcpStream),
+		perHost:           make(map[string]int),
+		firstFrameTimeout: tcpFirstFrameTimeout,
+	}
+	go c.acceptLoop()
+	return newReliableTransport("tcp", c, func(hostPort string) (net.Addr, error) {
+		addr, err := net.ResolveTCPAddr("tcp", hostPort)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve address '%s': %w", hostPort, err)
+		}
+		return addr, nil
+	})
+}
+
+// acceptLoop accepts connections until the listener is closed
+func (c *tcpConn) acceptLoop() {
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			select {
+			case <-c.closed:
+				return
+			default:
+			}
+			log.Printf("Error accepting TCP connection: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		host := remoteHost(conn)
+		c.mu.Lock()
+		full := c.accepted >= maxAcceptedConns
+		hostFull := c.perHost[host] >= maxAcceptedConnsPerHost
+		c.mu.Unlock()
+		if full {
+			log.Printf("Closing TCP connection from %s, %d accepted connections are already open", conn.RemoteAddr().String(), maxAcceptedConns)
+			conn.Close()
+			continue
+		}
+		if hostFull {
+			log.Printf("Closing TCP connection from %s, %d accepted connections from %s are already open", conn.RemoteAddr().String(), maxAcceptedConnsPerHost, host)
+			conn.Close()
+			continue
+		}
+		c.addStream(conn, true)
+	}
+}
+
+// addStream registers a connection under its remote address and starts reading frames from it. An accepted
+// connection replaces an earlier one from the same address, which is closed, and a dialled connection gives way
+// to one that another send dialled in the meantime.
+func (c *tcpConn) addStream(conn net.Conn, accepted bool) *tcpStream {
+	stream := &tcpStream{conn: conn, from: conn.RemoteAddr(), accepted: accepted}
+	key := conn.RemoteAddr().String()
+
+	c.mu.Lock()
+	if previous, exists := c.conns[key]; exists {
+		if !accepted {
+			c.mu.Unlock()
+			conn.Close()
+			return previous
+		}
+		previous.conn.Close()
+	}
+	c.conns[key] = stream
+	stream.keys = append(stream.keys, key)
+	if accepted {
+		c.accepted++
+		stream.host = remoteHost(conn)
+		c.perHost[stream.host]++
+		stream.counted = true
+	}
+	c.mu.Unlock()
+
+	go c.readLoop(stream)
+	return stream
+}
+
+// addAlias registers an accepted connection under the address its server listens at, unless a connection
+// to that address is already open, so what is sent to the server goes back on the connection it opened.
+// It is only called from the read loop of the connection, which is the only reader of stream.from.
+func (c *tcpConn) addAlias(stream *tcpStream, port uint16) {
+	host, _, err := net.SplitHostPort(stream.conn.RemoteAddr().String())
+	if err != nil || port == 0 {
+		return
+	}
+	key := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	c.mu.Lock()
+	if _, exists := c.conns[key]; !exists {
+		c.conns[key] = stream
+		stream.keys = append(stream.keys, key)
+		// Frames are then received from the address replies are sent to, so ACKs match the transmissions they acknowledge
+		if addr, err := net.ResolveTCPAddr("tcp", key); err == nil {
+			stream.from = addr
+		}
+	}
+	c.mu.Unlock()
+}
+
+// readLoop reads frames from a connection until it fails, and then forgets the connection
+func (c *tcpConn) readLoop(stream *tcpStream) {
+	defer c.removeStream(stream)
+
+	header := make([]byte, frameLengthSize)
+	// An accepted connection has to send its first frame soon, so connections that say nothing do not hold a place
+	timeout := tcpIdleTimeout
+	if stream.accepted {
+		c.mu.Lock()
+		timeout = c.firstFrameTimeout
+		c.mu.Unlock()
+	}
+	for {
+		stream.conn.SetReadDeadline(time.Now().Add(timeout))
+		if _, err := io.ReadFull(stream.conn, header); err != nil {
+			return
+		}
+		length := int(binary.BigEndian.Uint16(header))
+		if length == 0 || length > receiveBufferSize {
+			log.Printf("Closing TCP connection from %s after a frame of %d bytes", stream.conn.RemoteAddr().String(), length)
+			return
+		}
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(stream.conn, frame); err != nil {
+			return
+		}
+		timeout = tcpIdleTimeout
+		if length == helloFrameSize {
+			if stream.accepted {
+				c.addAlias(stream, binary.BigEndian.Uint16(frame))
+			}
+			continue
+		}
+
+		select {
+		case c.incoming <- tcpPacket{from: stream.from, data: frame}:
+		case <-c.closed:
+			return
+		default:
+			// Dropped like a packet on a full socket buffer, the sender retransmits it
+		}
+	}
+}
+
+// removeStream closes a connection and forgets it, under the addresses it has not been replaced at
+func (c *tcpConn) removeStream(stream *tcpStream) {
+	stream.conn.Close()
+	c.mu.Lock()
+	for _, key := range stream.keys {
+		if c.conns[key] == stream {
+			delete(c.conns, key)
+		}
+	}
+	if stream.counted {
+		// Counted down once, also when a failed write removes it before its read loop does
+		c.accepted--
+		if c.perHost[stream.host]--; c.perHost[stream.host] == 0 {
+			delete(c.perHost, stream.host)
+		}
+		stream.counted = false
+	}
+	c.mu.Unlock()
+}
+
+// remoteHost returns the IP address a connection comes from
+func remoteHost(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// getStream returns the connection to an address, and dials one if there is none
+func (c *tcpConn) getStream(addr net.Addr) (*tcpStream, error) {
+	c.mu.Lock()
+	stream, exists := c.conns[addr.String()]
+	c.mu.Unlock()
+	if exists {
+		return stream, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", addr.String(), tcpDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr.String(), err)
+	}
+
+	// Say which port this server listens on, before the connection is shared with other sends
+	if listenAddr, ok := c.listener.Addr().(*net.TCPAddr); ok {
+		hello := binary.BigEndian.AppendUint16(nil, helloFrameSize)
+		hello = binary.BigEndian.AppendUint16(hello, uint16(listenAddr.Port))
+		conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+		if _, err := conn.Write(hello); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to write to %s: %w", addr.String(), err)
+		}
+	}
+	return c.addStream(conn, false), nil
+}
+
+func (c *tcpConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case <-c.closed:
+		return 0, nil, ErrClosed
+	case packet := <-c.incoming:
+		return copy(p, packet.data), packet.from, nil
+	}
+}
+
+func (c *tcpConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, ErrClosed
+	default:
+	}
+
+	stream, err := c.getStream(addr)
+	if err != nil {
+		return 0, err
+	}
+
+	frame := binary.BigEndian.AppendUint16(make([]byte, 0, frameLengthSize+len(p)), uint16(len(p)))
+	frame = append(frame, p...)
+	stream.writeMu.Lock()
+	stream.conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+	_, err = stream.conn.Write(frame)
+	stream.writeMu.Unlock()
+	if err != nil {
+		// Forget the broken connection, so a retransmission dials a new one
+		c.removeStream(stream)
+		return 0, fmt.Errorf("failed to write to %s: %w", addr.String(), err)
+	}
+	return len(p), nil
+}
+
+func (c *tcpConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.listener.Close()
+		c.mu.Lock()
+		for _, stream := range c.conns {
+			stream.conn.Close()
+		}
+		c.mu.Unlock()
+	})
+	return nil
+}
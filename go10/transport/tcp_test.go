@@ -0,0 +1,235 @@
+package transport
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// listenTCP creates a TCP transport on a free port of the loopback address
+func listenTCP(t *testing.T) Transport {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcpTransport := NewTCPTransport(listener)
+	t.Cleanup(func() { tcpTransport.Close() })
+	return tcpTransport
+}
+
+// tcpConnOf returns the packet socket of a TCP transport
+func tcpConnOf(t Transport) *tcpConn {
+	return t.(*reliableTransport).conn.(*tcpConn)
+}
+
+// receiveAndAck acknowledges every packet a transport receives, and hands them to the test
+func receiveAndAck(t Transport) <-chan *Packet {
+	received := make(chan *Packet, 16)
+	go func() {
+		for {
+			packet, err := t.Receive()
+			if err != nil {
+				return
+			}
+			t.Ack(packet)
+			received <- packet
+		}
+	}()
+	return received
+}
+
+// expectPacket waits for a packet with the given data
+func expectPacket(t *testing.T, received <-chan *Packet, data string) *Packet {
+	t.Helper()
+	select {
+	case packet := <-received:
+		if string(packet.Data) != data {
+			t.Fatalf("received %q, want %q", packet.Data, data)
+		}
+		return packet
+	case <-time.After(10 * time.Second):
+		t.Fatalf("%q not received", data)
+		return nil
+	}
+}
+
+func TestTCPTransportRepliesOnDialledConnection(t *testing.T) {
+	alpha := listenTCP(t)
+	beta := listenTCP(t)
+	alphaReceived := receiveAndAck(alpha)
+	betaReceived := receiveAndAck(beta)
+
+	betaAddr, err := alpha.Resolve(tcpConnOf(beta).listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alpha.SendReliable(betaAddr, []byte("request"), 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	expectPacket(t, betaReceived, "request")
+
+	// beta sends to the address alpha listens at over the connection alpha dialled, without dialling its own
+	alphaAddr, err := beta.Resolve(tcpConnOf(alpha).listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := beta.SendReliable(alphaAddr, []byte("response"), 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	expectPacket(t, alphaReceived, "response")
+
+	for name, c := range map[string]*tcpConn{"alpha": tcpConnOf(alpha), "beta": tcpConnOf(beta)} {
+		c.mu.Lock()
+		streams := make(map[*tcpStream]bool)
+		for _, stream := range c.conns {
+			streams[stream] = true
+		}
+		c.mu.Unlock()
+		if len(streams) != 1 {
+			t.Errorf("%s has %d connections open, want 1", name, len(streams))
+		}
+	}
+}
+
+func TestTCPTransportRepliesToClientConnection(t *testing.T) {
+	server := listenTCP(t)
+	received := receiveAndAck(server)
+
+	// A client behind NAT does not listen, it gets the ACK and the response back on the connection it opened
+	client, err := net.Dial("tcp", tcpConnOf(server).listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+
+	writeFrame := func(data []byte) {
+		frame := binary.BigEndian.AppendUint16(nil, uint16(len(data)))
+		if _, err := client.Write(append(frame, data...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readFrame := func() []byte {
+		header := make([]byte, frameLengthSize)
+		if _, err := io.ReadFull(client, header); err != nil {
+			t.Fatal(err)
+		}
+		frame := make([]byte, binary.BigEndian.Uint16(header))
+		if _, err := io.ReadFull(client, frame); err != nil {
+			t.Fatal(err)
+		}
+		return frame
+	}
+
+	writeFrame(append([]byte{0, 0, 0, 9}, "request"...))
+	if ack := readFrame(); string(ack) != string([]byte{0, 0, 0, 9}) {
+		t.Fatalf("received %v, want the ACK of the request", ack)
+	}
+	packet := expectPacket(t, received, "request")
+
+	go server.SendReliable(packet.Addr, []byte("response"), 10*time.Second)
+	response := readFrame()
+	if string(response[4:]) != "response" {
+		t.Fatalf("received %q, want the response", response[4:])
+	}
+	writeFrame(response[:4])
+}
+
+func TestTCPTransportLimitsAcceptedConnections(t *testing.T) {
+	server := listenTCP(t)
+	c := tcpConnOf(server)
+	c.mu.Lock()
+	c.accepted = maxAcceptedConns
+	c.mu.Unlock()
+
+	// A connection over the limit is closed right away
+	conn, err := net.Dial("tcp", c.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("connection over the limit: read returned %v, want %v", err, io.EOF)
+	}
+}
+
+func TestTCPTransportLimitsAcceptedConnectionsPerHost(t *testing.T) {
+	server := listenTCP(t)
+	c := tcpConnOf(server)
+	c.mu.Lock()
+	c.perHost["127.0.0.1"] = maxAcceptedConnsPerHost
+	c.mu.Unlock()
+
+	// A connection over the limit of its IP address is closed right away, however few others are open
+	conn, err := net.Dial("tcp", c.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("connection over the limit of its host: read returned %v, want %v", err, io.EOF)
+	}
+
+	c.mu.Lock()
+	accepted := c.accepted
+	c.mu.Unlock()
+	if accepted != 0 {
+		t.Errorf("%d accepted connections counted, want 0", accepted)
+	}
+}
+
+func TestTCPTransportClosesSilentConnections(t *testing.T) {
+	server := listenTCP(t)
+	c := tcpConnOf(server)
+	c.mu.Lock()
+	c.firstFrameTimeout = 100 * time.Millisecond
+	c.mu.Unlock()
+
+	// A connection that sends nothing is closed after the first frame timeout, long before the idle timeout
+	conn, err := net.Dial("tcp", c.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("silent connection: read returned %v, want %v", err, io.EOF)
+	}
+
+	// Its place is given back
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		c.mu.Lock()
+		accepted, perHost := c.accepted, c.perHost["127.0.0.1"]
+		c.mu.Unlock()
+		if accepted == 0 && perHost == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d accepted connections and %d from the host still counted", accepted, perHost)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTCPTransportRejectsOversizedFrames(t *testing.T) {
+	server := listenTCP(t)
+	conn, err := net.Dial("tcp", tcpConnOf(server).listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, receiveBufferSize+1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("connection after an oversized frame: read returned %v, want %v", err, io.EOF)
+	}
+}
@@ -0,0 +1,114 @@
+package transport
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"ripple/udpr"
+)
+
+// receiveBufferSize is large enough for a packet in any format, with the 4-byte transmission identifier
+const receiveBufferSize = 2048
+
+// Packet is a transmission received from an address, with the identifier it is acknowledged with
+type Packet struct {
+	Addr net.Addr
+	ID   []byte
+	Data []byte
+}
+
+// Transport sends datagrams reliably and receives them, on one socket shared by the server loop,
+// the server-to-server sends and the client responses.
+type Transport interface {
+	// SendReliable sends data to an address, retransmitting it until it is acknowledged or the budget has passed
+	SendReliable(addr net.Addr, data []byte, budget time.Duration) error
+	// Receive waits for the next packet, acknowledgments are handed to the senders waiting for them
+	Receive() (*Packet, error)
+	// Ack acknowledges a received packet
+	Ack(packet *Packet) error
+	// Resolve resolves a host and port to an address on the transport
+	Resolve(hostPort string) (net.Addr, error)
+	// Close closes the socket, which makes Receive return an error
+	Close() error
+	// Network returns the name of the network of the addresses on the transport, such as "udp" or "tcp"
+	Network() string
+}
+
+// reliableTransport implements Transport with the retransmission and acknowledgment of udpr,
+// over a packet socket that may lose, delay and reorder packets.
+type reliableTransport struct {
+	network    string
+	conn       udpr.PacketConn
+	ackManager *udpr.AckManager
+	rttTable   *udpr.RTTTable
+	resolve    func(hostPort string) (net.Addr, error)
+}
+
+// newReliableTransport initializes a reliableTransport over a packet socket
+func newReliableTransport(network string, conn udpr.PacketConn, resolve func(hostPort string) (net.Addr, error)) *reliableTransport {
+	return &reliableTransport{
+		network:    network,
+		conn:       conn,
+		ackManager: udpr.NewAckManager(),
+		rttTable:   udpr.NewRTTTable(),
+		resolve:    resolve,
+	}
+}
+
+func (t *reliableTransport) SendReliable(addr net.Addr, data []byte, budget time.Duration) error {
+	return udpr.SendWithRetry(t.conn, t.ackManager, t.rttTable, addr, data, budget)
+}
+
+func (t *reliableTransport) Receive() (*Packet, error) {
+	buffer := make([]byte, receiveBufferSize)
+	for {
+		n, addr, err := t.conn.ReadFrom(buffer)
+		if err != nil {
+			return nil, err
+		}
+
+		// A 4-byte packet is an ACK for something sent from this socket, route it to the sender waiting for an ACK from its address
+		if n == udpr.AckSize {
+			if !t.ackManager.ReceivedAck(addr, buffer[:n]) {
+				log.Printf("Received ACK from %s that no sender is waiting for", addr.String())
+			}
+			continue
+		}
+		if n < udpr.AckSize {
+			log.Printf("Received %d byte packet from %s, too short to carry an identifier", n, addr.String())
+			continue
+		}
+
+		packet := append([]byte{}, buffer[:n]...)
+		return &Packet{Addr: addr, ID: packet[:udpr.AckSize], Data: packet[udpr.AckSize:]}, nil
+	}
+}
+
+func (t *reliableTransport) Ack(packet *Packet) error {
+	return udpr.SendAck(t.conn, packet.Addr, packet.ID)
+}
+
+func (t *reliableTransport) Resolve(hostPort string) (net.Addr, error) {
+	return t.resolve(hostPort)
+}
+
+func (t *reliableTransport) Close() error {
+	return t.conn.Close()
+}
+
+func (t *reliableTransport) Network() string {
+	return t.network
+}
+
+// NewUDPTransport creates the Transport of a UDP socket, resolving addresses with DNS
+func NewUDPTransport(conn *net.UDPConn) Transport {
+	return newReliableTransport("udp", conn, func(hostPort string) (net.Addr, error) {
+		addr, err := net.ResolveUDPAddr("udp", hostPort)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve address '%s': %w", hostPort, err)
+		}
+		return addr, nil
+	})
+}
@@ -0,0 +1,114 @@
+package types
+
+import (
+    "fmt"
+    "net"
+    "strconv"
+    "strings"
+)
+
+// DefaultPort is the port of a server address that does not give one
+const DefaultPort = 2012
+
+// SplitServerAddress splits a server address into its host and port. A server address is a domain name,
+// an IPv4 address or a bracketed IPv6 address, optionally followed by ':' and a port, which is the
+// default port when not given.
+func SplitServerAddress(address string, defaultPort int) (string, int, error) {
+    host, portString, hasPort := address, "", false
+    if strings.HasPrefix(address, "[") {
+        end := strings.IndexByte(address, ']')
+        if end < 0 {
+            return "", 0, fmt.Errorf("missing ']' in server address %q", address)
+        }
+        host = address[1:end]
+        if rest := address[end+1:]; rest != "" {
+            if rest[0] != ':' {
+                return "", 0, fmt.Errorf("unexpected %q after ']' in server address %q", rest, address)
+            }
+            portString, hasPort = rest[1:], true
+        }
+        if net.ParseIP(host) == nil || !strings.Contains(host, ":") {
+            return "", 0, fmt.Errorf("invalid IPv6 address in server address %q", address)
+        }
+    } else if strings.Count(address, ":") > 1 {
+        return "", 0, fmt.Errorf("IPv6 address in server address %q is not bracketed", address)
+    } else if i := strings.IndexByte(address, ':'); i >= 0 {
+        host, portString, hasPort = address[:i], address[i+1:], true
+    }
+
+    if !hasPort {
+        return host, defaultPort, nil
+    }
+    port, err := strconv.Atoi(portString)
+    if err != nil || port > 65535 || portString[0] < '1' || portString[0] > '9' {
+        return "", 0, fmt.Errorf("invalid port %q in server address %q", portString, address)
+    }
+    return host, port, nil
+}
+
+// CanonicalServerAddress returns the form of a server address that the same server is always stored under:
+// the host in lowercase, IPv6 addresses in their shortest form, and no port if it is the default port.
+// An address that does not parse is returned as it is, for validation to reject.
+func CanonicalServerAddress(address string) string {
+    host, port, err := SplitServerAddress(address, DefaultPort)
+    if err != nil {
+        return address
+    }
+    host = strings.ToLower(host)
+    if ip := net.ParseIP(host); ip != nil && strings.Contains(host, ":") {
+        host = "[" + ip.String() + "]"
+    }
+    if port == DefaultPort {
+        return host
+    }
+    return host + ":" + strconv.Itoa(port)
+}
+
+// EncodeServerAddress encodes the canonical form of a server address as a directory name, so "host" and
+// "host:2012" are the same directory. Letters, digits, '.' and '-' are kept, so domain names and IPv4
+// addresses are their own directory names, and every other byte is percent-encoded, so the ':' and
+// brackets of ports and IPv6 addresses are safe on any filesystem.
+func EncodeServerAddress(address string) string {
+    address = CanonicalServerAddress(address)
+    var encoded strings.Builder
+    for i := 0; i < len(address); i++ {
+        if c := address[i]; isAlphanumeric(c) || c == '.' || c == '-' {
+            encoded.WriteByte(c)
+        } else {
+            fmt.Fprintf(&encoded, "%%%02X", c)
+        }
+    }
+    return encoded.String()
+}
+
+// DecodeServerAddress decodes a directory name created by EncodeServerAddress
+func DecodeServerAddress(name string) (string, error) {
+    var decoded strings.Builder
+    for i := 0; i < len(name); i++ {
+        if name[i] != '%' {
+            decoded.WriteByte(name[i])
+            continue
+        }
+        if i+2 >= len(name) {
+            return "", fmt.Errorf("truncated escape in directory name %q", name)
+        }
+        value, err := strconv.ParseUint(name[i+1:i+3], 16, 8)
+        if err != nil {
+            return "", fmt.Errorf("invalid escape in directory name %q", name)
+        }
+        decoded.WriteByte(byte(value))
+        i += 2
+    }
+    return decoded.String(), nil
+}
+
+// PadServerAddress pads a server address to the width of its field, 32 bytes, or WideAddressFieldSize
+// for an address that only fits in the wide layout.
+func PadServerAddress(address string) []byte {
+    if len(address) <= AddressFieldSize {
+        return PadStringTo32Bytes(address)
+    }
+    padded := make([]byte, WideAddressFieldSize)
+    copy(padded, address)
+    return padded
+}
@@ -0,0 +1,142 @@
+package types
+
+import (
+    "testing"
+)
+
+func TestSplitServerAddress(t *testing.T) {
+    tests := []struct {
+        address string
+        host    string
+        port    int
+    }{
+        {"example.org", "example.org", 2012},
+        {"example.org:3000", "example.org", 3000},
+        {"192.0.2.1", "192.0.2.1", 2012},
+        {"192.0.2.1:65535", "192.0.2.1", 65535},
+        {"[2001:db8::1]", "2001:db8::1", 2012},
+        {"[2001:db8::1]:3000", "2001:db8::1", 3000},
+    }
+    for _, test := range tests {
+        host, port, err := SplitServerAddress(test.address, 2012)
+        if err != nil {
+            t.Errorf("%q: %v", test.address, err)
+            continue
+        }
+        if host != test.host || port != test.port {
+            t.Errorf("%q: got %q and %d, want %q and %d", test.address, host, port, test.host, test.port)
+        }
+    }
+}
+
+func TestSplitServerAddressRejectsInvalid(t *testing.T) {
+    for _, address := range []string{
+        "2001:db8::1",        // IPv6 address without brackets
+        "[2001:db8::1",       // Missing ']'
+        "[2001:db8::1]3000",  // Missing ':' before the port
+        "[192.0.2.1]",        // IPv4 address in brackets
+        "[example.org]:3000", // Domain name in brackets
+        "example.org:",       // Empty port
+        "example.org:0",      // Port zero
+        "example.org:03000",  // Leading zero
+        "example.org:65536",  // Port out of range
+        "example.org:+300",   // Sign
+        "example.org:http",   // Service name
+    } {
+        if _, _, err := SplitServerAddress(address, 2012); err == nil {
+            t.Errorf("%q accepted", address)
+        }
+    }
+}
+
+func TestCanonicalServerAddress(t *testing.T) {
+    tests := map[string]string{
+        "Example.ORG":                "example.org",
+        "example.org:2012":           "example.org",
+        "example.org:3000":           "example.org:3000",
+        "[2001:DB8:0:0:0:0:0:1]":     "[2001:db8::1]",
+        "[2001:db8:0000::0001]:2012": "[2001:db8::1]",
+        "[2001:db8::1]:3000":         "[2001:db8::1]:3000",
+        "not an address:":            "not an address:",
+    }
+    for address, want := range tests {
+        if got := CanonicalServerAddress(address); got != want {
+            t.Errorf("%q: got %q, want %q", address, got, want)
+        }
+    }
+}
+
+func TestEncodeServerAddress(t *testing.T) {
+    tests := map[string]string{
+        "example.org":        "example.org",
+        "example.org:2012":   "example.org",
+        "Example.org:3000":   "example.org%3A3000",
+        "192.0.2.1":          "192.0.2.1",
+        "[2001:db8::1]:3000": "%5B2001%3Adb8%3A%3A1%5D%3A3000",
+    }
+    for address, want := range tests {
+        encoded := EncodeServerAddress(address)
+        if encoded != want {
+            t.Errorf("%q: encoded as %q, want %q", address, encoded, want)
+        }
+        decoded, err := DecodeServerAddress(encoded)
+        if err != nil {
+            t.Errorf("%q: %v", encoded, err)
+        } else if decoded != CanonicalServerAddress(address) {
+            t.Errorf("%q: decoded as %q, want %q", encoded, decoded, CanonicalServerAddress(address))
+        }
+    }
+
+    for _, name := range []string{"example.org%3", "example.org%zz"} {
+        if _, err := DecodeServerAddress(name); err == nil {
+            t.Errorf("%q decoded", name)
+        }
+    }
+}
+
+func TestValidateServerAddress(t *testing.T) {
+    for _, address := range []string{"example.org", "a-b.example.org:3000", "192.0.2.1", "[2001:db8::1]:3000"} {
+        if err := ValidateServerAddress("server address", address); err != nil {
+            t.Errorf("%q rejected: %v", address, err)
+        }
+    }
+    for _, address := range []string{"", "example..org", "-example.org", "example-.org", "exa_mple.org", "../accounts", "example.org/x"} {
+        if err := ValidateServerAddress("server address", address); err == nil {
+            t.Errorf("%q accepted", address)
+        }
+    }
+}
+
+func TestPadServerAddress(t *testing.T) {
+    if padded := PadServerAddress("example.org"); len(padded) != AddressFieldSize || BytesToString(padded) != "example.org" {
+        t.Errorf("short address padded to %d bytes as %q", len(padded), BytesToString(padded))
+    }
+    wide := "[2001:db8:1234:5678:9abc:def0:1234:5678]:3000"
+    if padded := PadServerAddress(wide); len(padded) != WideAddressFieldSize || BytesToString(padded) != wide {
+        t.Errorf("long address padded to %d bytes as %q", len(padded), BytesToString(padded))
+    }
+}
+
+func TestWideDatagramRoundTrip(t *testing.T) {
+    dg := &Datagram{
+        Command:           0x81,
+        Username:          "alice",
+        PeerUsername:      "bob",
+        PeerServerAddress: "[2001:db8:1234:5678:9abc:def0:1234:5678]:3000",
+        Counter:           7,
+    }
+    buf, err := SerializeDatagram(dg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(buf) != WideDatagramSize {
+        t.Fatalf("datagram with a long server address is %d bytes, want %d", len(buf), WideDatagramSize)
+    }
+    if err := ValidateDatagramIdentifiers(buf); err != nil {
+        t.Fatal(err)
+    }
+    parsed := DeserializeDatagram(buf)
+    if parsed.PeerServerAddress != dg.PeerServerAddress || parsed.Username != dg.Username || parsed.Counter != dg.Counter {
+        t.Errorf("got %+v back, want %+v", parsed, dg)
+    }
+}
@@ -0,0 +1,67 @@
+package types
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "math"
+    "strconv"
+)
+
+// Amount is a quantity in base units of a currency. How many decimal places a base unit has
+// is the scale of the currency, which only matters for display since all arithmetic is on base units.
+type Amount uint64
+
+var (
+    // Predefined error for arithmetic that would wrap around
+    ErrAmountOverflow = errors.New("amount overflow")
+)
+
+// SubAmounts subtracts b from a, and returns ErrAmountOverflow if b is larger than a.
+func SubAmounts(a, b Amount) (Amount, error) {
+    if b > a {
+        return 0, ErrAmountOverflow
+    }
+    return a - b, nil
+}
+
+// AmountSize returns the size in bytes of an amount in the given argument layout version.
+func AmountSize(version byte) int {
+    if version == ArgumentsVersionLegacy {
+        return 4
+    }
+    return 8
+}
+
+// AmountToBytes converts an amount to a byte slice in the given argument layout version.
+// The legacy layout only holds 32-bit amounts.
+func AmountToBytes(amount Amount, version byte) ([]byte, error) {
+    if version == ArgumentsVersionLegacy {
+        if amount > math.MaxUint32 {
+            return nil, fmt.Errorf("amount %d does not fit the legacy 32-bit layout: %w", amount, ErrAmountOverflow)
+        }
+        return Uint32ToBytes(uint32(amount)), nil
+    }
+    return binary.BigEndian.AppendUint64(nil, uint64(amount)), nil
+}
+
+// BytesToAmount converts a byte slice to an amount in the given argument layout version.
+// It assumes the byte slice has at least AmountSize(version) bytes.
+func BytesToAmount(data []byte, version byte) Amount {
+    if version == ArgumentsVersionLegacy {
+        return Amount(BytesToUint32(data))
+    }
+    return Amount(binary.BigEndian.Uint64(data[:8]))
+}
+
+// FormatAmount formats an amount with the decimal places given by the scale of its currency.
+func FormatAmount(amount Amount, scale uint8) string {
+    digits := strconv.FormatUint(uint64(amount), 10)
+    if scale == 0 {
+        return digits
+    }
+    for len(digits) <= int(scale) {
+        digits = "0" + digits
+    }
+    return digits[:len(digits)-int(scale)] + "." + digits[len(digits)-int(scale):]
+}
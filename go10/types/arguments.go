@@ -0,0 +1,147 @@
+package types
+
+import "encoding/binary"
+
+// ArgumentsVersionIndex is the position of the argument layout version, the last byte of the arguments.
+// Peers and clients that predate versioned layouts leave it at zero.
+const ArgumentsVersionIndex = 255
+
+// SignatureVersionIndex is the position of the signature scheme version, the byte before the layout version.
+// It is covered by the signature, so it cannot be changed to downgrade the scheme without the key.
+const SignatureVersionIndex = 254
+
+// CapabilitiesIndex is the position of the capability flags a server advertises to its peers
+const CapabilitiesIndex = 253
+
+const (
+    CapabilityEncryption           = 1 << 0 // Accepts encrypted datagrams
+    CapabilitySignatureHMAC        = 1 << 1 // Verifies HMAC signatures
+    CapabilitySignatureDirectional = 1 << 2 // Verifies HMAC signatures with the keys derived per direction
+)
+
+const (
+    SignatureVersionLegacy      = 0 // sha256(data || key)
+    SignatureVersionHMAC        = 1 // HMAC-SHA256(key, data)
+    SignatureVersionEd25519     = 2 // Ed25519 with the server identity key, in a datagram of IdentityDatagramSize
+    SignatureVersionDirectional = 3 // HMAC-SHA256 with a key derived per direction, for server datagrams
+)
+
+const (
+    ArgumentsVersionLegacy   = 0 // Amounts are 32-bit
+    ArgumentsVersionAmount64 = 1 // Amounts are 64-bit
+)
+
+// CurrentArgumentsVersion is the argument layout used for datagrams created by this server
+const CurrentArgumentsVersion = ArgumentsVersionAmount64
+
+// GetArgumentsVersion returns the argument layout version of a datagram
+func GetArgumentsVersion(dg *Datagram) byte {
+    return dg.Arguments[ArgumentsVersionIndex]
+}
+
+// ArgumentReader reads the fields of datagram arguments in order.
+// Fields keep their order in every layout version, only the size of amounts differs.
+type ArgumentReader struct {
+    arguments []byte
+    offset    int
+    Version   byte
+}
+
+// NewArgumentReader creates an ArgumentReader for the arguments of a datagram
+func NewArgumentReader(dg *Datagram) *ArgumentReader {
+    return &ArgumentReader{
+        arguments: dg.Arguments[:CapabilitiesIndex],
+        Version:   GetArgumentsVersion(dg),
+    }
+}
+
+// next returns the next size bytes of the arguments and advances past them
+func (r *ArgumentReader) next(size int) []byte {
+    field := r.arguments[r.offset : r.offset+size]
+    r.offset += size
+    return field
+}
+
+// Byte reads a single byte
+func (r *ArgumentReader) Byte() byte {
+    return r.next(1)[0]
+}
+
+// Uint32 reads a 4-byte unsigned integer
+func (r *ArgumentReader) Uint32() uint32 {
+    return BytesToUint32(r.next(4))
+}
+
+// Int64 reads an 8-byte signed integer such as a Unix timestamp
+func (r *ArgumentReader) Int64() int64 {
+    return int64(binary.BigEndian.Uint64(r.next(8)))
+}
+
+// Amount reads an amount sized by the layout version
+func (r *ArgumentReader) Amount() Amount {
+    return BytesToAmount(r.next(AmountSize(r.Version)), r.Version)
+}
+
+// Currency reads and validates a currency code
+func (r *ArgumentReader) Currency() (string, error) {
+    return BytesToCurrency(r.next(CurrencySize))
+}
+
+// Identifier reads a null-padded 32-byte identifier
+func (r *ArgumentReader) Identifier() string {
+    return BytesToString(r.next(32))
+}
+
+// ArgumentWriter writes the fields of datagram arguments in order, in the current layout version.
+type ArgumentWriter struct {
+    arguments [256]byte
+    offset    int
+}
+
+// NewArgumentWriter creates an ArgumentWriter
+func NewArgumentWriter() *ArgumentWriter {
+    w := &ArgumentWriter{}
+    w.arguments[ArgumentsVersionIndex] = CurrentArgumentsVersion
+    return w
+}
+
+// put copies a field into the arguments and advances past it
+func (w *ArgumentWriter) put(field []byte) *ArgumentWriter {
+    w.offset += copy(w.arguments[w.offset:CapabilitiesIndex], field)
+    return w
+}
+
+// Byte writes a single byte
+func (w *ArgumentWriter) Byte(value byte) *ArgumentWriter {
+    return w.put([]byte{value})
+}
+
+// Uint32 writes a 4-byte unsigned integer
+func (w *ArgumentWriter) Uint32(value uint32) *ArgumentWriter {
+    return w.put(Uint32ToBytes(value))
+}
+
+// Int64 writes an 8-byte signed integer such as a Unix timestamp
+func (w *ArgumentWriter) Int64(value int64) *ArgumentWriter {
+    return w.put(binary.BigEndian.AppendUint64(nil, uint64(value)))
+}
+
+// Amount writes a 64-bit amount
+func (w *ArgumentWriter) Amount(amount Amount) *ArgumentWriter {
+    return w.put(binary.BigEndian.AppendUint64(nil, uint64(amount)))
+}
+
+// Currency writes a currency code
+func (w *ArgumentWriter) Currency(currency string) *ArgumentWriter {
+    return w.put(CurrencyToBytes(currency))
+}
+
+// Identifier writes a null-padded 32-byte identifier
+func (w *ArgumentWriter) Identifier(identifier string) *ArgumentWriter {
+    return w.put(PadStringTo32Bytes(identifier))
+}
+
+// Bytes returns the arguments, including the layout version. The capabilities and signature version are set when the datagram is signed.
+func (w *ArgumentWriter) Bytes() []byte {
+    return w.arguments[:]
+}
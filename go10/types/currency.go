@@ -0,0 +1,27 @@
+package types
+
+import "fmt"
+
+// CurrencySize is the size in bytes of a currency code in datagram arguments.
+// An empty currency code is the default unit of account, used by trustlines that predate currencies.
+const CurrencySize = 8
+
+// BytesToCurrency converts a null-padded currency code to a string, and checks that it is alphanumeric
+// since the currency code is used as a directory name.
+func BytesToCurrency(data []byte) (string, error) {
+    currency := BytesToString(data[:CurrencySize])
+    for i := 0; i < len(currency); i++ {
+        c := currency[i]
+        if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+            return "", fmt.Errorf("invalid currency code %q", currency)
+        }
+    }
+    return currency, nil
+}
+
+// CurrencyToBytes pads a currency code into a CurrencySize byte slice.
+func CurrencyToBytes(currency string) []byte {
+    paddedSlice := make([]byte, CurrencySize)
+    copy(paddedSlice, currency)
+    return paddedSlice
+}
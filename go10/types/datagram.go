@@ -1,7 +1,5 @@
 package types
 
-import "ripple/config"
-
 // Datagram holds the structure of the incoming data
 type Datagram struct {
     Command           byte
@@ -10,15 +8,16 @@ type Datagram struct {
     PeerServerAddress string
     Arguments         [256]byte
     Counter           uint32
+    Payload           []byte // Arguments beyond the fixed 256 bytes, only in messages sent as several fragments
     Signature         [32]byte
 }
 
-// NewDatagram creates a new Datagram instance with the specified parameters
-func NewDatagram(recipient, sender string, counter uint32) *Datagram {
+// NewDatagram creates a new Datagram instance with the specified parameters, from a sender at a server address
+func NewDatagram(recipient, sender, senderServerAddress string, counter uint32) *Datagram {
     return &Datagram{
         Username:          recipient,
         PeerUsername:      sender,
-        PeerServerAddress: config.GetServerAddress(),
+        PeerServerAddress: senderServerAddress,
         Counter:           counter,
     }
 }
@@ -0,0 +1,139 @@
+package types
+
+import (
+    "fmt"
+    "strings"
+)
+
+// MaxIdentifierLength is the size of the username fields in a datagram
+const MaxIdentifierLength = 32
+
+// MaxServerAddressLength is the size of the server address field in the wide datagram layout
+const MaxServerAddressLength = WideAddressFieldSize
+
+// reservedUsernames are the names of directories the server keeps in the data directory, which accounts cannot take
+var reservedUsernames = map[string]bool{
+    "accounts": true,
+    "archive":  true,
+    "peers":    true,
+}
+
+// IdentifierError describes why a username or server address in a datagram was rejected
+type IdentifierError struct {
+    Field  string
+    Value  string
+    Reason string
+}
+
+func (e *IdentifierError) Error() string {
+    return fmt.Sprintf("invalid %s %q: %s", e.Field, e.Value, e.Reason)
+}
+
+// ValidateUsername checks that a username is 1 to 32 characters of letters, digits, '_', '-' and '.',
+// does not start with '.', and is not a reserved name, so it can be used as a directory name.
+func ValidateUsername(field, username string) error {
+    if username == "" {
+        return &IdentifierError{field, username, "empty"}
+    }
+    if len(username) > MaxIdentifierLength {
+        return &IdentifierError{field, username, "too long"}
+    }
+    if username[0] == '.' {
+        return &IdentifierError{field, username, "starts with '.'"}
+    }
+    for i := 0; i < len(username); i++ {
+        c := username[i]
+        if !(isAlphanumeric(c) || c == '_' || c == '-' || c == '.') {
+            return &IdentifierError{field, username, fmt.Sprintf("invalid character %q", c)}
+        }
+    }
+    if reservedUsernames[strings.ToLower(username)] {
+        return &IdentifierError{field, username, "reserved name"}
+    }
+    return nil
+}
+
+// ValidateServerAddress checks that a server address is a domain name or IPv4 address, with labels of letters,
+// digits and '-' separated by '.' that do not start or end with '-', or a bracketed IPv6 address, optionally
+// followed by a port. Addresses longer than 32 characters only fit in the wide datagram layout.
+func ValidateServerAddress(field, address string) error {
+    if address == "" {
+        return &IdentifierError{field, address, "empty"}
+    }
+    if len(address) > MaxServerAddressLength {
+        return &IdentifierError{field, address, "too long"}
+    }
+    host, _, err := SplitServerAddress(address, 0)
+    if err != nil {
+        return &IdentifierError{field, address, err.Error()}
+    }
+    if strings.HasPrefix(address, "[") {
+        return nil // Checked by SplitServerAddress
+    }
+    for _, label := range strings.Split(host, ".") {
+        if label == "" {
+            return &IdentifierError{field, address, "empty label"}
+        }
+        if label[0] == '-' || label[len(label)-1] == '-' {
+            return &IdentifierError{field, address, "label starts or ends with '-'"}
+        }
+        for i := 0; i < len(label); i++ {
+            if c := label[i]; !(isAlphanumeric(c) || c == '-') {
+                return &IdentifierError{field, address, fmt.Sprintf("invalid character %q", c)}
+            }
+        }
+    }
+    return nil
+}
+
+// ValidateDatagramIdentifiers checks the usernames and server address in the header of a received datagram,
+// in any format, before they are used to locate anything on disk. Each field must be null-padded with
+// nothing after the padding, so it has exactly one representation. The peer username and server address
+// may both be empty in client datagrams for commands that do not involve a peer.
+func ValidateDatagramIdentifiers(buf []byte) error {
+    headerSize := HeaderSize(buf)
+    wideSinglePacket := IsWideDatagram(buf) && !IsMessage(buf)
+    if IsEncryptedDatagram(buf) {
+        buf = buf[1:]
+    }
+    command := buf[0]
+
+    fields := []struct {
+        name string
+        data []byte
+    }{
+        {"username", buf[1:33]},
+        {"peer username", buf[33:65]},
+        {"peer server address", buf[65:headerSize]},
+    }
+    values := make([]string, len(fields))
+    for i, field := range fields {
+        values[i] = BytesToString(field.data)
+        for _, b := range field.data[len(values[i]):] {
+            if b != 0 {
+                return &IdentifierError{field.name, values[i], "data after null padding"}
+            }
+        }
+    }
+
+    // An address that fits in the narrow layout is only sent in the wide layout as part of a message
+    if wideSinglePacket && len(values[2]) <= AddressFieldSize {
+        return &IdentifierError{"peer server address", values[2], "fits in the narrow layout"}
+    }
+
+    if err := ValidateUsername("username", values[0]); err != nil {
+        return err
+    }
+    if command&0x80 == 0 && values[1] == "" && values[2] == "" {
+        return nil
+    }
+    if err := ValidateUsername("peer username", values[1]); err != nil {
+        return err
+    }
+    return ValidateServerAddress("peer server address", values[2])
+}
+
+// isAlphanumeric checks whether a byte is an ASCII letter or digit
+func isAlphanumeric(c byte) bool {
+    return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,69 @@
+package types
+
+import (
+    "errors"
+    "testing"
+)
+
+// serializeTestDatagram serializes a datagram from alice to bob at a server address
+func serializeTestDatagram(t *testing.T, command byte, peerServerAddress string) []byte {
+    t.Helper()
+    buf, err := SerializeDatagram(&Datagram{Command: command, Username: "alice", PeerUsername: "bob", PeerServerAddress: peerServerAddress})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return buf
+}
+
+func TestValidateUsernameRejectsInvalid(t *testing.T) {
+    for _, username := range []string{"", ".alice", "..", "../alice", "al/ice", "al\\ice", "al ice", "alice\x00", "Accounts", "peers", "a23456789012345678901234567890123"} {
+        if err := ValidateUsername("username", username); err == nil {
+            t.Errorf("%q accepted", username)
+        }
+    }
+    for _, username := range []string{"alice", "a.b", "a_b-c", "a2345678901234567890123456789012"} {
+        if err := ValidateUsername("username", username); err != nil {
+            t.Errorf("%q rejected: %v", username, err)
+        }
+    }
+}
+
+func TestValidateDatagramIdentifiersRejectsInvalid(t *testing.T) {
+    tests := []struct {
+        name   string
+        modify func(buf []byte)
+    }{
+        {"path in the username", func(buf []byte) { copy(buf[1:33], "../accounts\x00") }},
+        {"data after the padding of the username", func(buf []byte) { buf[1+len("alice")+1] = 'x' }},
+        {"data after the padding of the peer username", func(buf []byte) { buf[33+len("bob")+1] = 'x' }},
+        {"invalid character in the peer server address", func(buf []byte) { copy(buf[65:], "beta/test") }},
+        {"reserved peer username", func(buf []byte) { copy(buf[33:65], "archive\x00") }},
+        {"empty peer of a server datagram", func(buf []byte) { copy(buf[33:65], make([]byte, 32)); copy(buf[65:97], make([]byte, 32)) }},
+    }
+    for _, test := range tests {
+        buf := serializeTestDatagram(t, 0x81, "beta.test")
+        test.modify(buf)
+        var identifierErr *IdentifierError
+        if err := ValidateDatagramIdentifiers(buf); !errors.As(err, &identifierErr) {
+            t.Errorf("%s: got %v, want an identifier error", test.name, err)
+        }
+    }
+}
+
+func TestValidateDatagramIdentifiersAcceptsClientDatagramWithoutPeer(t *testing.T) {
+    buf := serializeTestDatagram(t, 0x01, "")
+    copy(buf[33:65], make([]byte, 32))
+    if err := ValidateDatagramIdentifiers(buf); err != nil {
+        t.Errorf("client datagram without a peer rejected: %v", err)
+    }
+}
+
+func TestValidateDatagramIdentifiersRejectsNarrowAddressInWideLayout(t *testing.T) {
+    // A single wide datagram with an address that fits in the narrow layout would be a second encoding of it
+    buf := serializeTestDatagram(t, 0x81, "[2001:db8:1234:5678:9abc:def0:1234:5678]:3000")
+    copy(buf[65:65+WideAddressFieldSize], make([]byte, WideAddressFieldSize))
+    copy(buf[65:], "beta.test")
+    if err := ValidateDatagramIdentifiers(buf); err == nil {
+        t.Errorf("narrow address in the wide layout accepted")
+    }
+}
@@ -1,39 +1,199 @@
 package types
 
-import "encoding/binary"
+import (
+    "encoding/binary"
+    "fmt"
+)
+
+// DatagramSize is the size of a plaintext datagram
+const DatagramSize = 389
+
+// EncryptedDatagramSize is the size of an encrypted datagram. It starts with a format byte, followed by the
+// command, usernames and server address in the clear, so the receiver can find the key, then the key identifier,
+// the nonce, and the arguments and counter sealed with AES-GCM.
+const EncryptedDatagramSize = 1 + EncryptedHeaderSize + KeyIdentifierSize + NonceSize + 260 + 16
+
+const (
+    EncryptedHeaderSize = 97 // Command, Username, PeerUsername and PeerServerAddress
+    KeyIdentifierSize   = 8
+    NonceSize           = 12
+)
+
+// EncryptedFormatAESGCM is the format byte of datagrams encrypted with AES-256-GCM
+const EncryptedFormatAESGCM = 1
+
+// IdentityDatagramSize is the size of a datagram signed with the server identity key, where the 32-byte
+// signature is replaced by a 64-byte Ed25519 signature.
+const IdentityDatagramSize = DatagramSize - 32 + 64
+
+// A server address longer than the 32-byte field, such as an IPv6 address with a port, is sent in the wide layout,
+// where the server address field is WideAddressFieldSize bytes. Every format has a wide version that is
+// WideLayoutExtraSize bytes larger, and is told apart from the narrow one by its size.
+const (
+    AddressFieldSize     = 32
+    WideAddressFieldSize = 128
+    WideLayoutExtraSize  = WideAddressFieldSize - AddressFieldSize
+    WideHeaderSize       = EncryptedHeaderSize + WideLayoutExtraSize
+)
+
+const (
+    WideDatagramSize          = DatagramSize + WideLayoutExtraSize
+    WideEncryptedDatagramSize = EncryptedDatagramSize + WideLayoutExtraSize
+    WideIdentityDatagramSize  = IdentityDatagramSize + WideLayoutExtraSize
+)
+
+// A message is a datagram with a payload after the counter, for arguments that do not fit in the fixed 256 bytes.
+// It is too large for one packet, so it is sent in fragments and reassembled before it is parsed. Messages use the
+// wide layout, and the payload is prefixed with its length and padded to at least MinPayloadRegionSize, so a message
+// is always larger than the single-packet formats and told apart from them by its size. The signature covers the
+// whole message.
+const (
+    PayloadLengthSize    = 2
+    MinPayloadRegionSize = 64
+    MaxPayloadSize       = 32 * 1024
+)
+
+// MaxMessageSize is the size of the largest message, in the wide layout with the largest payload
+const MaxMessageSize = WideHeaderSize + 260 + PayloadLengthSize + MaxPayloadSize + 32
+
+// IsMessage checks whether a reassembled buffer is a message with a payload
+func IsMessage(buf []byte) bool {
+    return len(buf) > WideIdentityDatagramSize
+}
+
+// IsWideDatagram checks whether a buffer, signed or not, is in the wide layout
+func IsWideDatagram(buf []byte) bool {
+    switch len(buf) {
+    case WideDatagramSize, WideEncryptedDatagramSize, WideIdentityDatagramSize:
+        return true
+    }
+    return IsMessage(buf)
+}
+
+// IsIdentityDatagram checks whether a received buffer is signed with a server identity key
+func IsIdentityDatagram(buf []byte) bool {
+    return len(buf) == IdentityDatagramSize || len(buf) == WideIdentityDatagramSize
+}
+
+// IsEncryptedDatagram checks whether a received buffer is in the encrypted datagram format
+func IsEncryptedDatagram(buf []byte) bool {
+    return len(buf) == EncryptedDatagramSize || len(buf) == WideEncryptedDatagramSize
+}
+
+// IsDatagramSize checks whether a received buffer has the size of a datagram in one of the single-packet formats
+func IsDatagramSize(n int) bool {
+    switch n {
+    case DatagramSize, EncryptedDatagramSize, IdentityDatagramSize, WideDatagramSize, WideEncryptedDatagramSize, WideIdentityDatagramSize:
+        return true
+    }
+    return false
+}
+
+// HeaderSize returns the size of the command, usernames and server address of a buffer in either layout,
+// not counting the format byte of an encrypted datagram.
+func HeaderSize(buf []byte) int {
+    if IsWideDatagram(buf) {
+        return WideHeaderSize
+    }
+    return EncryptedHeaderSize
+}
+
+// SignatureVersionOffset returns the position of the signature scheme version in a serialized datagram
+func SignatureVersionOffset(buf []byte) int {
+    return HeaderSize(buf) + SignatureVersionIndex
+}
 
 // SerializeDatagram converts a Datagram struct to a byte slice.
+// A datagram with a payload is serialized as a message, with room for the signature at the end, and a datagram
+// with a server address longer than 32 bytes in the wide layout.
 func SerializeDatagram(dg *Datagram) ([]byte, error) {
+    if len(dg.Payload) > MaxPayloadSize {
+        return nil, fmt.Errorf("payload of %d bytes exceeds the maximum of %d bytes", len(dg.Payload), MaxPayloadSize)
+    }
+    if len(dg.PeerServerAddress) > WideAddressFieldSize {
+        return nil, fmt.Errorf("server address %q exceeds the maximum of %d bytes", dg.PeerServerAddress, WideAddressFieldSize)
+    }
+
     // Create the byte slice
-    data := make([]byte, 389)
+    headerSize := EncryptedHeaderSize
+    if len(dg.PeerServerAddress) > AddressFieldSize || len(dg.Payload) > 0 {
+        headerSize = WideHeaderSize
+    }
+    size := headerSize + 260 + 32
+    if len(dg.Payload) > 0 {
+        size += payloadRegionSize(len(dg.Payload))
+    }
+    data := make([]byte, size)
     data[0] = dg.Command // First byte is the Command
 
     // Copy Usernames and Server Address
     copy(data[1:], dg.Username)
     copy(data[33:], dg.PeerUsername)
-    copy(data[65:], dg.PeerServerAddress)
+    copy(data[65:headerSize], dg.PeerServerAddress)
+
+    // Copy the Arguments, which carry the argument layout and signature versions
+    copy(data[headerSize:headerSize+256], dg.Arguments[:])
 
     // Write the Counter
-    binary.BigEndian.PutUint32(data[353:], dg.Counter)
+    binary.BigEndian.PutUint32(data[headerSize+256:], dg.Counter)
+
+    // Write the length prefixed payload, if any
+    if len(dg.Payload) > 0 {
+        binary.BigEndian.PutUint16(data[headerSize+260:], uint16(len(dg.Payload)))
+        copy(data[headerSize+260+PayloadLengthSize:], dg.Payload)
+    }
 
     return data, nil
 }
 
+// payloadRegionSize returns the size of the length prefixed and padded payload of a message
+func payloadRegionSize(payloadLength int) int {
+    size := PayloadLengthSize + payloadLength
+    if size < MinPayloadRegionSize {
+        size = MinPayloadRegionSize
+    }
+    return size
+}
+
+// DeserializeDatagram parses a plaintext datagram, or the clear header of an encrypted datagram,
+// in which case the arguments and counter are filled in once the datagram is decrypted.
 func DeserializeDatagram(buf []byte) *Datagram {
+    headerSize := HeaderSize(buf)
+    if IsEncryptedDatagram(buf) {
+        buf = buf[1 : 1+headerSize]
+        return &Datagram{
+            Command:           buf[0],
+            Username:          BytesToString(buf[1:33]),
+            PeerUsername:      BytesToString(buf[33:65]),
+            PeerServerAddress: BytesToString(buf[65:headerSize]),
+        }
+    }
+
     // Assuming buf is already confirmed to be of the correct length
     datagram := &Datagram{
         Command:           buf[0],
         Username:          BytesToString(buf[1:33]),
         PeerUsername:      BytesToString(buf[33:65]),
-        PeerServerAddress: BytesToString(buf[65:97]),
+        PeerServerAddress: BytesToString(buf[65:headerSize]),
         Arguments:         [256]byte{},
-        Counter:           binary.BigEndian.Uint32(buf[353:357]),
+        Counter:           binary.BigEndian.Uint32(buf[headerSize+256 : headerSize+260]),
         Signature:         [32]byte{},
     }
 
     // Copy data into fixed-size arrays for Arguments and Signature
-    copy(datagram.Arguments[:], buf[97:353])
-    copy(datagram.Signature[:], buf[357:389])
+    copy(datagram.Arguments[:], buf[headerSize:headerSize+256])
+    if IsMessage(buf) {
+        // The payload length is covered by the signature, but is checked against the size before it is verified
+        region := buf[headerSize+260 : len(buf)-32]
+        length := int(binary.BigEndian.Uint16(region))
+        if length > len(region)-PayloadLengthSize {
+            length = len(region) - PayloadLengthSize
+        }
+        datagram.Payload = append([]byte{}, region[PayloadLengthSize:PayloadLengthSize+length]...)
+        copy(datagram.Signature[:], buf[len(buf)-32:])
+        return datagram
+    }
+    copy(datagram.Signature[:], buf[headerSize+260:]) // The first half of the signature in datagrams signed with an identity key
 
     return datagram
 }
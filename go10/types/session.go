@@ -4,5 +4,5 @@ import "net"
 
 type Session struct {
 	Datagram *Datagram // The datagram associated with this session
-	Addr     *net.UDPAddr
+	Addr     net.Addr // The address of a client, responses are sent to it
 }
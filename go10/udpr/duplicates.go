@@ -0,0 +1,77 @@
+package udpr
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// duplicateWindow is how long a transmission is remembered, longer than the retransmissions of the highest retry budget take
+const duplicateWindow = 5 * time.Minute
+
+// seenTransmission is a transmission that was received and accepted, identified by the digest of its data
+// so that a different datagram that reuses the identifier is not mistaken for a retransmission.
+type seenTransmission struct {
+	digest [32]byte
+	seenAt time.Time
+}
+
+// DuplicateCache remembers the transmissions recently accepted from each sender, so a retransmission
+// sent because an ACK was lost can be acknowledged again and dropped.
+type DuplicateCache struct {
+	mu        sync.Mutex
+	senders   map[string]map[uint32]seenTransmission
+	lastPrune time.Time
+}
+
+// NewDuplicateCache initializes a new DuplicateCache
+func NewDuplicateCache() *DuplicateCache {
+	return &DuplicateCache{
+		senders:   make(map[string]map[uint32]seenTransmission),
+		lastPrune: time.Now(),
+	}
+}
+
+// IsDuplicate checks whether a transmission from a sender has already been accepted
+func (dc *DuplicateCache) IsDuplicate(sender string, idBytes []byte, data []byte) bool {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	seen, exists := dc.senders[sender][binary.BigEndian.Uint32(idBytes)]
+	return exists && seen.digest == sha256.Sum256(data) && time.Since(seen.seenAt) < duplicateWindow
+}
+
+// MarkSeen records a transmission from a sender once it has been accepted. Transmissions are only recorded
+// once accepted, so a forged packet cannot cause the genuine one to be dropped as a duplicate.
+func (dc *DuplicateCache) MarkSeen(sender string, idBytes []byte, data []byte) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(dc.lastPrune) > duplicateWindow {
+		dc.prune(now)
+	}
+
+	transmissions, exists := dc.senders[sender]
+	if !exists {
+		transmissions = make(map[uint32]seenTransmission)
+		dc.senders[sender] = transmissions
+	}
+	transmissions[binary.BigEndian.Uint32(idBytes)] = seenTransmission{digest: sha256.Sum256(data), seenAt: now}
+}
+
+// prune removes the transmissions older than the duplicate window
+func (dc *DuplicateCache) prune(now time.Time) {
+	for sender, transmissions := range dc.senders {
+		for identifier, seen := range transmissions {
+			if now.Sub(seen.seenAt) >= duplicateWindow {
+				delete(transmissions, identifier)
+			}
+		}
+		if len(transmissions) == 0 {
+			delete(dc.senders, sender)
+		}
+	}
+	dc.lastPrune = now
+}
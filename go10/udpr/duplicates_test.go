@@ -0,0 +1,62 @@
+package udpr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDuplicateCacheOnlyRemembersAccepted(t *testing.T) {
+	dc := NewDuplicateCache()
+	id := []byte{0, 0, 0, 1}
+	data := []byte("datagram")
+
+	if dc.IsDuplicate("192.0.2.1:2012", id, data) {
+		t.Fatal("transmission is a duplicate before it was accepted")
+	}
+	dc.MarkSeen("192.0.2.1:2012", id, data)
+	if !dc.IsDuplicate("192.0.2.1:2012", id, data) {
+		t.Fatal("retransmission of an accepted transmission is not a duplicate")
+	}
+}
+
+func TestDuplicateCacheMatchesSenderIdentifierAndData(t *testing.T) {
+	dc := NewDuplicateCache()
+	id := []byte{0, 0, 0, 1}
+	data := []byte("datagram")
+	dc.MarkSeen("192.0.2.1:2012", id, data)
+
+	if dc.IsDuplicate("192.0.2.2:2012", id, data) {
+		t.Error("same transmission from another sender is a duplicate")
+	}
+	if dc.IsDuplicate("192.0.2.1:2012", []byte{0, 0, 0, 2}, data) {
+		t.Error("transmission with another identifier is a duplicate")
+	}
+	if dc.IsDuplicate("192.0.2.1:2012", id, []byte("other datagram")) {
+		t.Error("different datagram that reuses the identifier is a duplicate")
+	}
+}
+
+func TestDuplicateCacheForgetsAfterWindow(t *testing.T) {
+	dc := NewDuplicateCache()
+	id := []byte{0, 0, 0, 1}
+	data := []byte("datagram")
+	dc.MarkSeen("192.0.2.1:2012", id, data)
+
+	// Age the transmission past the duplicate window
+	seen := dc.senders["192.0.2.1:2012"][1]
+	seen.seenAt = time.Now().Add(-duplicateWindow)
+	dc.senders["192.0.2.1:2012"][1] = seen
+	if dc.IsDuplicate("192.0.2.1:2012", id, data) {
+		t.Error("transmission older than the duplicate window is a duplicate")
+	}
+
+	// The next transmission accepted once the window has passed prunes it
+	dc.lastPrune = time.Now().Add(-2 * duplicateWindow)
+	dc.MarkSeen("192.0.2.2:2012", id, data)
+	if _, exists := dc.senders["192.0.2.1:2012"]; exists {
+		t.Error("expired transmissions were not pruned")
+	}
+	if !dc.IsDuplicate("192.0.2.2:2012", id, data) {
+		t.Error("transmission accepted while pruning is not remembered")
+	}
+}
@@ -0,0 +1,240 @@
+package udpr
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ripple/types"
+)
+
+// A message too large for one datagram is sent as fragments of FragmentSize bytes, each retransmitted and
+// acknowledged on its own, and reassembled by the receiver. A fragment is a format byte, the message identifier,
+// the index of the fragment and the number of fragments, the length of the data it carries, and the data.
+// No valid message is larger than the largest datagram with a payload, so no more fragments than it takes are accepted.
+const (
+	FragmentSize       = 512
+	fragmentHeaderSize = 1 + 4 + 2 + 2 + 2
+	FragmentDataSize   = FragmentSize - fragmentHeaderSize
+	fragmentFormat     = 1
+	MaxMessageSize     = types.MaxMessageSize
+	maxFragments       = (MaxMessageSize + FragmentDataSize - 1) / FragmentDataSize
+)
+
+// Reassembly limits, so partial messages cannot exhaust memory. A host is limited both in the number of partial
+// messages and in the space they reserve, so a few hosts cannot take the space reserved for all of them.
+const (
+	reassemblyTimeout        = 60 * time.Second // Longer than the retry budget of a single fragment
+	maxPendingPerSender      = 8                // Partial messages from one host, an IPv4 address or an IPv6 /64
+	maxPendingBytesPerSender = 128 * 1024       // Space reserved for partial messages from one host
+	maxPendingBytes          = 4 * 1024 * 1024  // Space reserved for partial messages from all addresses
+)
+
+var (
+	// Predefined errors for fragments that are rejected
+	ErrMalformedFragment = errors.New("malformed fragment")
+	ErrReassemblyLimit   = errors.New("reassembly limit reached")
+)
+
+// Global counter for generating message identifiers, starting at a random value so they are not predictable
+var messageCounter = randomUint32()
+
+// IsFragment checks whether a received buffer is a fragment of a message
+func IsFragment(buf []byte) bool {
+	return len(buf) == FragmentSize
+}
+
+// Fragment splits a message into fragments of FragmentSize bytes, the last one padded
+func Fragment(messageID uint32, data []byte) ([][]byte, error) {
+	if len(data) == 0 || len(data) > MaxMessageSize {
+		return nil, fmt.Errorf("message of %d bytes cannot be fragmented, the maximum is %d bytes", len(data), MaxMessageSize)
+	}
+
+	count := (len(data) + FragmentDataSize - 1) / FragmentDataSize
+	fragments := make([][]byte, 0, count)
+	for index := 0; index < count; index++ {
+		chunk := data[index*FragmentDataSize:]
+		if len(chunk) > FragmentDataSize {
+			chunk = chunk[:FragmentDataSize]
+		}
+
+		fragment := make([]byte, FragmentSize)
+		fragment[0] = fragmentFormat
+		binary.BigEndian.PutUint32(fragment[1:5], messageID)
+		binary.BigEndian.PutUint16(fragment[5:7], uint16(index))
+		binary.BigEndian.PutUint16(fragment[7:9], uint16(count))
+		binary.BigEndian.PutUint16(fragment[9:11], uint16(len(chunk)))
+		copy(fragment[fragmentHeaderSize:], chunk)
+		fragments = append(fragments, fragment)
+	}
+	return fragments, nil
+}
+
+// SendFragmented sends a message too large for one datagram as fragments, one after the other, each with
+// the reliable send function of a transport. The budget is shared by all the fragments.
+func SendFragmented(send func(fragment []byte, budget time.Duration) error, data []byte, budget time.Duration) error {
+	fragments, err := Fragment(atomic.AddUint32(&messageCounter, 1), data)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(budget)
+	for index, fragment := range fragments {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("sending message ran out of time after %d of %d fragments", index, len(fragments))
+		}
+		if err := send(fragment, remaining); err != nil {
+			return fmt.Errorf("failed to send fragment %d of %d: %w", index+1, len(fragments), err)
+		}
+	}
+	return nil
+}
+
+// ReceivedFragment is a fragment with the transmission identifier it was received with, so it can be remembered
+// as seen once the message it is part of has been accepted
+type ReceivedFragment struct {
+	ID   []byte
+	Data []byte
+}
+
+// partialMessage holds the fragments of a message received so far
+type partialMessage struct {
+	chunks    [][]byte
+	fragments []ReceivedFragment
+	received  int
+	reserved  int
+	source    string
+	startedAt time.Time
+}
+
+// messageKey identifies a message by the address it is sent from and its identifier
+type messageKey struct {
+	sender    string
+	messageID uint32
+}
+
+// senderSource returns the host a sender address belongs to, the IP address, or the /64 of an IPv6 address,
+// so a host cannot get past the limit on partial messages by sending from many ports or addresses
+func senderSource(sender string) string {
+	host, _, err := net.SplitHostPort(sender)
+	if err != nil {
+		return sender
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+	return ip.String()
+}
+
+// Reassembler collects fragments into messages. Space for a message is reserved when its first fragment
+// arrives, and messages that are not complete within the timeout are dropped.
+type Reassembler struct {
+	mu             sync.Mutex
+	pending        map[messageKey]*partialMessage
+	perSender      map[string]int
+	perSenderBytes map[string]int
+	reserved       int
+}
+
+// NewReassembler initializes a new Reassembler
+func NewReassembler() *Reassembler {
+	return &Reassembler{
+		pending:        make(map[messageKey]*partialMessage),
+		perSender:      make(map[string]int),
+		perSenderBytes: make(map[string]int),
+	}
+}
+
+// Add adds a fragment received from a sender with its transmission identifier, and returns the message once all its
+// fragments have arrived, along with the fragments it was reassembled from. It returns nil while the message is
+// incomplete, including for a fragment that was already received.
+func (r *Reassembler) Add(sender string, id []byte, fragment []byte) ([]byte, []ReceivedFragment, error) {
+	if !IsFragment(fragment) || fragment[0] != fragmentFormat {
+		return nil, nil, ErrMalformedFragment
+	}
+	key := messageKey{sender, binary.BigEndian.Uint32(fragment[1:5])}
+	index := int(binary.BigEndian.Uint16(fragment[5:7]))
+	count := int(binary.BigEndian.Uint16(fragment[7:9]))
+	length := int(binary.BigEndian.Uint16(fragment[9:11]))
+
+	// Every fragment but the last is full
+	if count == 0 || count > maxFragments || index >= count || length == 0 || length > FragmentDataSize || (index < count-1 && length != FragmentDataSize) {
+		return nil, nil, ErrMalformedFragment
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(time.Now())
+
+	message, exists := r.pending[key]
+	if !exists {
+		reserve := count * FragmentSize
+		source := senderSource(sender)
+		if r.perSender[source] >= maxPendingPerSender || r.perSenderBytes[source]+reserve > maxPendingBytesPerSender || r.reserved+reserve > maxPendingBytes {
+			return nil, nil, ErrReassemblyLimit
+		}
+		message = &partialMessage{
+			chunks:    make([][]byte, count),
+			reserved:  reserve,
+			source:    source,
+			startedAt: time.Now(),
+		}
+		r.pending[key] = message
+		r.perSender[source]++
+		r.perSenderBytes[source] += reserve
+		r.reserved += reserve
+	} else if len(message.chunks) != count {
+		// The fragments disagree on the size of the message, drop it
+		r.remove(key, message)
+		return nil, nil, ErrMalformedFragment
+	}
+
+	if message.chunks[index] != nil {
+		return nil, nil, nil
+	}
+	received := ReceivedFragment{ID: append([]byte{}, id...), Data: append([]byte{}, fragment...)}
+	message.chunks[index] = received.Data[fragmentHeaderSize : fragmentHeaderSize+length]
+	message.fragments = append(message.fragments, received)
+	message.received++
+	if message.received < count {
+		return nil, nil, nil
+	}
+
+	// All fragments have arrived, join them
+	r.remove(key, message)
+	data := make([]byte, 0, (count-1)*FragmentDataSize+len(message.chunks[count-1]))
+	for _, chunk := range message.chunks {
+		data = append(data, chunk...)
+	}
+	return data, message.fragments, nil
+}
+
+// remove drops a partial message and releases the space reserved for it
+func (r *Reassembler) remove(key messageKey, message *partialMessage) {
+	delete(r.pending, key)
+	r.reserved -= message.reserved
+	if r.perSender[message.source]--; r.perSender[message.source] == 0 {
+		delete(r.perSender, message.source)
+	}
+	if r.perSenderBytes[message.source] -= message.reserved; r.perSenderBytes[message.source] == 0 {
+		delete(r.perSenderBytes, message.source)
+	}
+}
+
+// prune drops the partial messages that have timed out
+func (r *Reassembler) prune(now time.Time) {
+	for key, message := range r.pending {
+		if now.Sub(message.startedAt) > reassemblyTimeout {
+			r.remove(key, message)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package udpr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testID returns the transmission identifier of a fragment in the tests
+func testID(index int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(index))
+}
+
+func TestReassemblyOutOfOrder(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 300)
+	fragments, err := Fragment(1, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fragments) != 6 {
+		t.Fatalf("%d bytes split into %d fragments, want 6", len(data), len(fragments))
+	}
+
+	r := NewReassembler()
+	order := []int{3, 0, 5, 1, 4}
+	for _, index := range order {
+		message, _, err := r.Add("192.0.2.1:2012", testID(index), fragments[index])
+		if err != nil || message != nil {
+			t.Fatalf("fragment %d: got message %v, error %v, want neither", index, message != nil, err)
+		}
+	}
+
+	// A fragment received again is ignored
+	if message, _, err := r.Add("192.0.2.1:2012", testID(3), fragments[3]); err != nil || message != nil {
+		t.Fatalf("repeated fragment: got message %v, error %v, want neither", message != nil, err)
+	}
+
+	message, received, err := r.Add("192.0.2.1:2012", testID(2), fragments[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(message, data) {
+		t.Error("reassembled message differs from the one fragmented")
+	}
+	if len(received) != len(fragments) {
+		t.Errorf("message reassembled from %d fragments, want %d", len(received), len(fragments))
+	}
+	if len(r.pending) != 0 || r.reserved != 0 || len(r.perSender) != 0 {
+		t.Error("reassembled message still holds space")
+	}
+}
+
+func TestFragmentRejectsOversizedMessages(t *testing.T) {
+	if _, err := Fragment(1, make([]byte, MaxMessageSize+1)); err == nil {
+		t.Error("message larger than the maximum was fragmented")
+	}
+	if maxFragments*FragmentDataSize >= MaxMessageSize+FragmentDataSize {
+		t.Errorf("%d fragments accepted for messages of at most %d bytes", maxFragments, MaxMessageSize)
+	}
+	if _, err := Fragment(1, nil); err == nil {
+		t.Error("empty message was fragmented")
+	}
+}
+
+func TestReassemblyRejectsMalformedFragments(t *testing.T) {
+	fragments, err := Fragment(1, make([]byte, 2*FragmentDataSize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(fragment []byte){
+		"format":          func(fragment []byte) { fragment[0] = 2 },
+		"index":           func(fragment []byte) { binary.BigEndian.PutUint16(fragment[5:7], 3) },
+		"no fragments":    func(fragment []byte) { binary.BigEndian.PutUint16(fragment[7:9], 0) },
+		"too many":        func(fragment []byte) { binary.BigEndian.PutUint16(fragment[7:9], maxFragments+1) },
+		"empty":           func(fragment []byte) { binary.BigEndian.PutUint16(fragment[9:11], 0) },
+		"short non-final": func(fragment []byte) { binary.BigEndian.PutUint16(fragment[9:11], FragmentDataSize-1) },
+		"long":            func(fragment []byte) { binary.BigEndian.PutUint16(fragment[9:11], FragmentDataSize+1) },
+	}
+	for name, corrupt := range tests {
+		fragment := append([]byte{}, fragments[0]...)
+		corrupt(fragment)
+		if _, _, err := NewReassembler().Add("192.0.2.1:2012", testID(0), fragment); !errors.Is(err, ErrMalformedFragment) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrMalformedFragment)
+		}
+	}
+
+	// A fragment that disagrees with the earlier ones on the number of fragments drops the message
+	r := NewReassembler()
+	if _, _, err := r.Add("192.0.2.1:2012", testID(0), fragments[0]); err != nil {
+		t.Fatal(err)
+	}
+	other, err := Fragment(1, make([]byte, 4*FragmentDataSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.Add("192.0.2.1:2012", testID(1), other[1]); !errors.Is(err, ErrMalformedFragment) {
+		t.Errorf("inconsistent fragment: got %v, want %v", err, ErrMalformedFragment)
+	}
+	if len(r.pending) != 0 || r.reserved != 0 {
+		t.Error("inconsistent message was not dropped")
+	}
+}
+
+func TestReassemblyLimitsPerSender(t *testing.T) {
+	r := NewReassembler()
+	for messageID := uint32(0); messageID <= maxPendingPerSender; messageID++ {
+		fragments, err := Fragment(messageID, make([]byte, 2*FragmentDataSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Each message from another port of the same host, which counts against the same limit
+		_, _, err = r.Add(fmt.Sprintf("192.0.2.1:%d", 2000+messageID), testID(0), fragments[0])
+		if messageID < maxPendingPerSender && err != nil {
+			t.Fatalf("partial message %d rejected: %v", messageID, err)
+		} else if messageID == maxPendingPerSender && !errors.Is(err, ErrReassemblyLimit) {
+			t.Fatalf("partial message over the limit: got %v, want %v", err, ErrReassemblyLimit)
+		}
+	}
+
+	// Another address in the same IPv6 /64 shares a limit, one in another /64 does not
+	fragments, err := Fragment(100, make([]byte, 2*FragmentDataSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for messageID := 0; messageID < maxPendingPerSender; messageID++ {
+		if _, _, err := r.Add(fmt.Sprintf("[2001:db8::%x]:2012", messageID+1), testID(messageID), fragments[0]); err != nil {
+			t.Fatalf("partial message %d from IPv6 rejected: %v", messageID, err)
+		}
+	}
+	if _, _, err := r.Add("[2001:db8::ffff]:2012", testID(0), fragments[0]); !errors.Is(err, ErrReassemblyLimit) {
+		t.Errorf("partial message from the same /64: got %v, want %v", err, ErrReassemblyLimit)
+	}
+	if _, _, err := r.Add("[2001:db8:0:1::1]:2012", testID(0), fragments[0]); err != nil {
+		t.Errorf("partial message from another /64 rejected: %v", err)
+	}
+}
+
+func TestReassemblyLimitsReservedBytesPerSender(t *testing.T) {
+	fragments, err := Fragment(1, make([]byte, MaxMessageSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	perMessage := len(fragments) * FragmentSize
+
+	r := NewReassembler()
+	limit := maxPendingBytesPerSender / perMessage
+	if limit >= maxPendingPerSender {
+		t.Fatalf("%d of the largest messages fit in the space of a host, the count limit is reached first", limit)
+	}
+	for port := 0; port < limit; port++ {
+		if _, _, err := r.Add(fmt.Sprintf("192.0.2.1:%d", 2000+port), testID(0), fragments[0]); err != nil {
+			t.Fatalf("partial message %d rejected: %v", port, err)
+		}
+	}
+	if _, _, err := r.Add("192.0.2.1:3000", testID(0), fragments[0]); !errors.Is(err, ErrReassemblyLimit) {
+		t.Errorf("partial message over the space of a host: got %v, want %v", err, ErrReassemblyLimit)
+	}
+	if _, _, err := r.Add("192.0.2.2:2012", testID(0), fragments[0]); err != nil {
+		t.Errorf("partial message from another host rejected: %v", err)
+	}
+}
+
+func TestReassemblyLimitsReservedBytes(t *testing.T) {
+	fragments, err := Fragment(1, make([]byte, MaxMessageSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	perMessage := len(fragments) * FragmentSize
+
+	r := NewReassembler()
+	limit := maxPendingBytes / perMessage
+	for host := 0; host < limit; host++ {
+		if _, _, err := r.Add(fmt.Sprintf("10.0.%d.%d:2012", host/256, host%256), testID(0), fragments[0]); err != nil {
+			t.Fatalf("partial message %d rejected: %v", host, err)
+		}
+	}
+	if _, _, err := r.Add("198.51.100.1:2012", testID(0), fragments[0]); !errors.Is(err, ErrReassemblyLimit) {
+		t.Errorf("partial message over the reserved space: got %v, want %v", err, ErrReassemblyLimit)
+	}
+}
+
+func TestReassemblyTimeout(t *testing.T) {
+	fragments, err := Fragment(1, make([]byte, 2*FragmentDataSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReassembler()
+	if _, _, err := r.Add("192.0.2.1:2012", testID(0), fragments[0]); err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range r.pending {
+		message.startedAt = time.Now().Add(-reassemblyTimeout - time.Second)
+	}
+
+	// The fragment that would have completed the message starts a new one instead
+	message, _, err := r.Add("192.0.2.1:2012", testID(1), fragments[1])
+	if err != nil || message != nil {
+		t.Fatalf("fragment after the timeout: got message %v, error %v, want neither", message != nil, err)
+	}
+	if r.reserved != len(fragments)*FragmentSize {
+		t.Errorf("reserved %d bytes after the timeout, want %d", r.reserved, len(fragments)*FragmentSize)
+	}
+}
@@ -0,0 +1,76 @@
+package udpr
+
+import (
+	"sync"
+	"time"
+)
+
+// Retransmission timer bounds, and the smoothing factors of RFC 6298
+const (
+	minRTO   = 200 * time.Millisecond // Lower than the 1 second of RFC 6298, so LAN peers are retried quickly
+	maxRTO   = maxDelay
+	rttAlpha = 0.125 // Weight of a new sample in the smoothed RTT
+	rttBeta  = 0.25  // Weight of a new sample in the RTT variation
+)
+
+// rttState is the RTT estimate for one remote address
+type rttState struct {
+	srtt   time.Duration // Smoothed round-trip time
+	rttvar time.Duration // Round-trip time variation
+	rto    time.Duration // Retransmission timeout
+}
+
+// RTTTable keeps an RTT estimate per remote address, to set the retransmission timeout as in RFC 6298
+type RTTTable struct {
+	mu     sync.Mutex
+	states map[string]*rttState
+}
+
+// NewRTTTable initializes a new RTTTable
+func NewRTTTable() *RTTTable {
+	return &RTTTable{
+		states: make(map[string]*rttState),
+	}
+}
+
+// RTO returns the retransmission timeout for a remote address, initialDelay if nothing has been measured yet
+func (rt *RTTTable) RTO(addr string) time.Duration {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if state, exists := rt.states[addr]; exists {
+		return state.rto
+	}
+	return initialDelay
+}
+
+// Sample updates the estimate of a remote address with a measured round-trip time. Following Karn's algorithm,
+// only transmissions acknowledged without being retransmitted are sampled, since the ACK of a retransmission is ambiguous.
+func (rt *RTTTable) Sample(addr string, rtt time.Duration) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	state, exists := rt.states[addr]
+	if !exists {
+		state = &rttState{srtt: rtt, rttvar: rtt / 2}
+		rt.states[addr] = state
+	} else {
+		delta := state.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		state.rttvar = time.Duration((1-rttBeta)*float64(state.rttvar) + rttBeta*float64(delta))
+		state.srtt = time.Duration((1-rttAlpha)*float64(state.srtt) + rttAlpha*float64(rtt))
+	}
+	state.rto = clampRTO(state.srtt + 4*state.rttvar)
+}
+
+// clampRTO keeps a retransmission timeout within bounds
+func clampRTO(rto time.Duration) time.Duration {
+	if rto < minRTO {
+		return minRTO
+	}
+	if rto > maxRTO {
+		return maxRTO
+	}
+	return rto
+}
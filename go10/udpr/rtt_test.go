@@ -0,0 +1,57 @@
+package udpr
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// droppingConn is a PacketConn that loses every packet written to it
+type droppingConn struct{}
+
+func (droppingConn) ReadFrom(p []byte) (int, net.Addr, error)     { select {} }
+func (droppingConn) WriteTo(p []byte, addr net.Addr) (int, error) { return len(p), nil }
+func (droppingConn) Close() error                                 { return nil }
+
+func TestRTTSample(t *testing.T) {
+	rt := NewRTTTable()
+	if rto := rt.RTO("192.0.2.1:2012"); rto != initialDelay {
+		t.Errorf("RTO %v before a sample, want %v", rto, initialDelay)
+	}
+
+	rt.Sample("192.0.2.1:2012", 100*time.Millisecond)
+	if rto := rt.RTO("192.0.2.1:2012"); rto != 300*time.Millisecond {
+		t.Errorf("RTO %v after a sample of 100ms, want 300ms", rto)
+	}
+	rt.Sample("192.0.2.1:2012", time.Millisecond)
+	if rto := rt.RTO("192.0.2.1:2012"); rto < minRTO {
+		t.Errorf("RTO %v below the minimum %v", rto, minRTO)
+	}
+	rt.Sample("192.0.2.2:2012", time.Minute)
+	if rto := rt.RTO("192.0.2.2:2012"); rto != maxRTO {
+		t.Errorf("RTO %v after a sample of a minute, want the maximum %v", rto, maxRTO)
+	}
+}
+
+func TestTimeoutsDoNotChangeSharedRTO(t *testing.T) {
+	peer := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 2012}
+	rt := NewRTTTable()
+	rt.Sample(peer.String(), 100*time.Millisecond)
+	before := rt.RTO(peer.String())
+
+	// Many transmissions in flight to one address all time out
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			SendWithRetry(droppingConn{}, NewAckManager(), rt, peer, []byte("datagram"), time.Second)
+		}()
+	}
+	wg.Wait()
+
+	if after := rt.RTO(peer.String()); after != before {
+		t.Errorf("RTO %v after timeouts, want it unchanged at %v until the next sample", after, before)
+	}
+}
@@ -1,69 +1,153 @@
 package udpr
 
 import (
-	"bytes"
+	"crypto/rand"
 	"encoding/binary"
 	"fmt"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
-// Global counter for generating unique 32-bit identifiers
-var identifierCounter uint32
+// Global counter for generating unique 32-bit identifiers, starting at a random value so they are not predictable
+var identifierCounter = randomUint32()
 
 const (
-	initialDelay = 1 * time.Second	   // Initial delay duration
+	initialDelay = 1 * time.Second	   // Retransmission timeout before the RTT to an address is measured
 	maxDelay = 16 * time.Second 	   // Maximum delay duration
 )
 
-// SendWithRetry sends data with retransmission logic and waits for a simple acknowledgment
-func SendWithRetry(conn *net.UDPConn, addr *net.UDPAddr, data []byte, maxRetries int) error {
+// AckSize is the size of an acknowledgment, the identifier of the transmission it acknowledges
+const AckSize = 4
+
+// PacketConn is the unreliable packet socket that transmissions are sent from and acknowledgments read from,
+// a *net.UDPConn or a connection on an in-memory network.
+type PacketConn interface {
+	ReadFrom(p []byte) (int, net.Addr, error)
+	WriteTo(p []byte, addr net.Addr) (int, error)
+	Close() error
+}
+
+// randomUint32 returns a random starting value for a counter of identifiers
+func randomUint32() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("failed to generate random identifier: %v", err))
+	}
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// AckManager routes acknowledgments read from a shared socket to the senders waiting for them,
+// so many transmissions can be in flight on one socket at once.
+type AckManager struct {
+	mu      sync.Mutex
+	waiting map[ackKey]chan struct{}
+}
+
+// ackKey identifies a transmission waiting for its acknowledgment by the address it was sent to and its identifier,
+// so only the address a transmission was sent to can acknowledge it
+type ackKey struct {
+	remote     string
+	identifier uint32
+}
+
+// NewAckManager initializes a new AckManager
+func NewAckManager() *AckManager {
+	return &AckManager{
+		waiting: make(map[ackKey]chan struct{}),
+	}
+}
+
+// register creates the channel a sender waits on for the acknowledgment of a transmission
+func (am *AckManager) register(key ackKey) chan struct{} {
+	ackChan := make(chan struct{})
+	am.mu.Lock()
+	am.waiting[key] = ackChan
+	am.mu.Unlock()
+	return ackChan
+}
+
+// unregister removes a transmission that is no longer waited for
+func (am *AckManager) unregister(key ackKey) {
+	am.mu.Lock()
+	delete(am.waiting, key)
+	am.mu.Unlock()
+}
+
+// ReceivedAck hands an acknowledgment received from an address to the sender waiting for it, and returns false
+// if no sender is waiting, for example because the acknowledgment arrived after the sender gave up, or because
+// it came from an address other than the one the transmission was sent to.
+func (am *AckManager) ReceivedAck(addr net.Addr, idBytes []byte) bool {
+	key := ackKey{addr.String(), binary.BigEndian.Uint32(idBytes)}
+	am.mu.Lock()
+	ackChan, exists := am.waiting[key]
+	delete(am.waiting, key)
+	am.mu.Unlock()
+	if exists {
+		close(ackChan)
+	}
+	return exists
+}
+
+// SendWithRetry sends data from a shared socket with retransmission logic, and waits for the AckManager
+// that reads the socket to hand it the acknowledgment. Retransmissions are timed by the RTT measured to
+// the address, and stop once the budget has passed.
+func SendWithRetry(conn PacketConn, ackManager *AckManager, rttTable *RTTTable, addr net.Addr, data []byte, budget time.Duration) error {
 
 	// Generate a unique 32-bit identifier for this transmission
 	identifier := atomic.AddUint32(&identifierCounter, 1)
 
-	// Convert the identifier to a 4-byte slice
-	idBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(idBytes, identifier)
-
 	// Create the packet with the 4-byte identifier
-	packet := append(idBytes, data...)
+	packet := binary.BigEndian.AppendUint32(make([]byte, 0, AckSize+len(data)), identifier)
+	packet = append(packet, data...)
 
-	delay := initialDelay
+	remote := addr.String()
+	key := ackKey{remote, identifier}
+	ackChan := ackManager.register(key)
+	defer ackManager.unregister(key)
 
-	for retries := 0; retries <= maxRetries; retries++ {
+	deadline := time.Now().Add(budget)
+	delay := rttTable.RTO(remote)
+
+	for attempt := 0; ; attempt++ {
 		// Send the datagram with the identifier
-		if _, err := conn.WriteToUDP(packet, addr); err != nil {
-			return fmt.Errorf("failed to send data to %s: %w", addr.String(), err)
+		sentAt := time.Now()
+		if _, err := conn.WriteTo(packet, addr); err != nil {
+			return fmt.Errorf("failed to send data to %s: %w", remote, err)
 		}
 
-		// Set a deadline for the read operation
-		conn.SetReadDeadline(time.Now().Add(delay))
-
-		// Wait for the acknowledgment
-		ack := make([]byte, 4)
-		_, _, err := conn.ReadFromUDP(ack)
-
-		if err == nil && bytes.Equal(ack, idBytes) {
-			// Correct ACK received successfully
+		// Wait for the acknowledgment, but not past the deadline
+		wait := delay
+		if remaining := time.Until(deadline); remaining < wait {
+			wait = remaining
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ackChan:
+			timer.Stop()
+			if attempt == 0 {
+				rttTable.Sample(remote, time.Since(sentAt))
+			}
 			return nil
+		case <-timer.C:
 		}
 
-		// No correct ACK or an error occurred, retry
-		if delay < maxDelay {
-			delay *= 2 // Exponential backoff
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("retransmission to %s failed after %d attempts within %v", remote, attempt+1, budget)
 		}
-		fmt.Printf("Timeout or invalid ACK, retrying... (%d/%d)\n", retries, maxRetries)
-	}
 
-	return fmt.Errorf("retransmission failed after %d attempts", maxRetries)
+		// No ACK received, back off and retry. The backed-off timeout only applies to this transmission, as the timer
+		// of RFC 6298 does, so transmissions in flight to the same address do not back off each other's timeouts.
+		delay = clampRTO(delay * 2)
+		fmt.Printf("Timeout waiting for ACK from %s, retrying in %v... (attempt %d)\n", remote, delay, attempt+1)
+	}
 }
 
 // SendAck sends a simple acknowledgment with the byte slice identifier
-func SendAck(conn *net.UDPConn, addr *net.UDPAddr, idBytes []byte) error {
+func SendAck(conn PacketConn, addr net.Addr, idBytes []byte) error {
 	// Directly send the identifier as the ACK
-	if _, err := conn.WriteToUDP(idBytes, addr); err != nil {
+	if _, err := conn.WriteTo(idBytes, addr); err != nil {
 		return fmt.Errorf("failed to send ACK: %w", err)
 	}
 	return nil
@@ -0,0 +1,58 @@
+package udpr
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// ackingConn is a PacketConn that hands every packet written to it to a function, which may acknowledge it
+type ackingConn struct {
+	onWrite func(packet []byte, addr net.Addr)
+}
+
+func (c *ackingConn) ReadFrom(p []byte) (int, net.Addr, error) { select {} }
+func (c *ackingConn) Close() error                             { return nil }
+
+func (c *ackingConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.onWrite(append([]byte{}, p...), addr)
+	return len(p), nil
+}
+
+func TestSendWithRetryIgnoresSpoofedAcks(t *testing.T) {
+	peer := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 2012}
+	attacker := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 2012}
+
+	ackManager := NewAckManager()
+	conn := &ackingConn{onWrite: func(packet []byte, addr net.Addr) {
+		// Another host that learns or guesses the identifier acknowledges the transmission
+		go func() {
+			if ackManager.ReceivedAck(attacker, packet[:AckSize]) {
+				t.Error("ACK from another address was handed to the sender")
+			}
+		}()
+	}}
+
+	if err := SendWithRetry(conn, ackManager, NewRTTTable(), peer, []byte("datagram"), 300*time.Millisecond); err == nil {
+		t.Fatal("send acknowledged by another address succeeded")
+	}
+}
+
+func TestSendWithRetryAcceptsAckFromPeer(t *testing.T) {
+	peer := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 2012}
+
+	ackManager := NewAckManager()
+	conn := &ackingConn{onWrite: func(packet []byte, addr net.Addr) {
+		go ackManager.ReceivedAck(addr, packet[:AckSize])
+	}}
+
+	if err := SendWithRetry(conn, ackManager, NewRTTTable(), peer, []byte("datagram"), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(ackManager.waiting) != 0 {
+		t.Errorf("%d transmissions still waiting after the ACK", len(ackManager.waiting))
+	}
+	if ackManager.ReceivedAck(peer, []byte{0, 0, 0, 0}) {
+		t.Error("ACK that nothing waits for was handed to a sender")
+	}
+}
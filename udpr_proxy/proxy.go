@@ -1,112 +1,194 @@
 package main
 
 import (
-    "bytes"
+    "flag"
     "fmt"
-    "io"
     "net"
+    "sync"
+    "sync/atomic"
     "time"
 )
 
 const (
-    ProxyPort  = 2013 // Port where the proxy listens
-    ServerPort = 2012 // Original server port
-    ReadTimeout = 5 * time.Second // Read timeout for server response
+    DefaultListenAddress   = ":2013"          // Address where the proxy listens
+    DefaultUpstreamAddress = "127.0.0.1:2012" // Address of the server
+    DefaultIdleTimeout     = 3 * time.Minute  // Longer than the event subscription, so a renewing client keeps its relay
+    DefaultMaxClients      = 1024             // Clients relayed at once, further clients are dropped until one times out
+    MaxPacketSize          = 65535            // Large enough for any UDP packet
+    SweepInterval          = 10 * time.Second // How often idle relays are closed
 )
 
-// Proxy function to handle client requests
-func runProxyLoop(proxyConn *net.UDPConn) {
-    buffer := make([]byte, 4096) // Initial buffer size
+// relay is the state of one client: a socket of its own towards the server, so everything the server sends
+// to that socket, responses, their retransmissions, ACKs and pushed events, belongs to this client only.
+type relay struct {
+    clientAddr *net.UDPAddr
+    upstream   *net.UDPConn
+    lastActive atomic.Int64 // Unix nanoseconds of the last packet in either direction
+}
+
+// touch records activity on the relay
+func (r *relay) touch() {
+    r.lastActive.Store(time.Now().UnixNano())
+}
+
+// Proxy relays packets between clients and the server, one relay per client address
+type Proxy struct {
+    conn         *net.UDPConn
+    upstreamAddr *net.UDPAddr
+    idleTimeout  time.Duration
+    maxClients   int
+
+    mu     sync.Mutex
+    relays map[string]*relay
+}
 
+// NewProxy initializes a Proxy on a listening socket
+func NewProxy(conn *net.UDPConn, upstreamAddr *net.UDPAddr, idleTimeout time.Duration, maxClients int) *Proxy {
+    return &Proxy{
+        conn:         conn,
+        upstreamAddr: upstreamAddr,
+        idleTimeout:  idleTimeout,
+        maxClients:   maxClients,
+        relays:       make(map[string]*relay),
+    }
+}
+
+// Run reads packets from clients, one datagram per read, and forwards each to the server through the client's relay
+func (p *Proxy) Run() {
+    go p.sweepLoop()
+
+    buffer := make([]byte, MaxPacketSize)
     for {
-        n, clientAddr, err := proxyConn.ReadFromUDP(buffer)
+        n, clientAddr, err := p.conn.ReadFromUDP(buffer)
         if err != nil {
             fmt.Printf("Error reading from proxy connection: %v\n", err)
             continue
         }
 
-        // Assume the first 4 bytes are the msgID, the rest is the data
-        msgID := string(buffer[:4])
-        data := buffer[4:n]
+        // A request carries a 4-byte identifier, and an ACK of a response is the identifier alone
+        if n < 4 {
+            fmt.Printf("Dropped %d byte packet from %s, too short to carry an identifier\n", n, clientAddr.String())
+            continue
+        }
+
+        r, err := p.getRelay(clientAddr)
+        if err != nil {
+            fmt.Printf("Failed to relay packet from %s: %v\n", clientAddr.String(), err)
+            continue
+        }
+        r.touch()
 
-        // Forward request to main server port 2012
-        go handleRequest(proxyConn, clientAddr, msgID, data)
+        if _, err := r.upstream.Write(buffer[:n]); err != nil {
+            fmt.Printf("Failed to forward packet from %s to server: %v\n", clientAddr.String(), err)
+        }
     }
 }
 
-// Handle request by communicating with the server and forwarding to the client
-func handleRequest(proxyConn *net.UDPConn, clientAddr *net.UDPAddr, msgID string, data []byte) {
-    serverAddr := net.UDPAddr{
-        Port: ServerPort,
-        IP:   net.ParseIP("127.0.0.1"), // Assuming the server is on localhost
-    }
+// getRelay returns the relay of a client, and opens one if there is none
+func (p *Proxy) getRelay(clientAddr *net.UDPAddr) (*relay, error) {
+    key := clientAddr.String()
 
-    // Send the request to the server on port 2012
-    serverConn, err := net.DialUDP("udp", nil, &serverAddr)
-    if err != nil {
-        fmt.Printf("Failed to dial server connection: %v\n", err)
-        return
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if r, exists := p.relays[key]; exists {
+        return r, nil
+    }
+    if len(p.relays) >= p.maxClients {
+        return nil, fmt.Errorf("already relaying for %d clients", p.maxClients)
     }
-    defer serverConn.Close()
 
-    _, err = serverConn.Write(append([]byte(msgID), data...))
+    upstream, err := net.DialUDP("udp", nil, p.upstreamAddr)
     if err != nil {
-        fmt.Printf("Failed to send request to server: %v\n", err)
-        return
+        return nil, fmt.Errorf("failed to dial server connection: %w", err)
     }
+    r := &relay{clientAddr: clientAddr, upstream: upstream}
+    r.touch()
+    p.relays[key] = r
 
-    // Use a bytes.Buffer to dynamically handle the response
-    var responseBuffer bytes.Buffer
+    go p.upstreamLoop(r)
+    return r, nil
+}
 
-    serverConn.SetReadDeadline(time.Now().Add(ReadTimeout))
-    _, err = io.Copy(&responseBuffer, serverConn)
-    if err != nil && err != io.EOF {
-        fmt.Printf("Failed to receive response from server: %v\n", err)
-        return
+// upstreamLoop forwards every packet the server sends to a relay to its client, from the listening socket, until the relay is closed.
+// The server retransmits a response until the client's ACK has come back through the relay, so each retransmission is forwarded as well.
+func (p *Proxy) upstreamLoop(r *relay) {
+    buffer := make([]byte, MaxPacketSize)
+    for {
+        n, err := r.upstream.Read(buffer)
+        if err != nil {
+            // The relay was closed, or the server is unreachable, in which case the client retransmits through a new relay
+            p.closeRelay(r)
+            return
+        }
+        r.touch()
+
+        if _, err := p.conn.WriteToUDP(buffer[:n], r.clientAddr); err != nil {
+            fmt.Printf("Failed to forward packet to client %s: %v\n", r.clientAddr.String(), err)
+        }
     }
+}
 
-    // Forward the server's response to the client from port 2013
-    _, err = proxyConn.WriteToUDP(responseBuffer.Bytes(), clientAddr)
-    if err != nil {
-        fmt.Printf("Failed to forward response to client: %v\n", err)
-        return
+// closeRelay closes the socket of a relay and forgets it, unless it has already been replaced
+func (p *Proxy) closeRelay(r *relay) {
+    r.upstream.Close()
+
+    p.mu.Lock()
+    key := r.clientAddr.String()
+    if p.relays[key] == r {
+        delete(p.relays, key)
     }
+    p.mu.Unlock()
+}
+
+// sweepLoop closes the relays of clients that have been idle for longer than the idle timeout
+func (p *Proxy) sweepLoop() {
+    ticker := time.NewTicker(SweepInterval)
+    defer ticker.Stop()
 
-    // Handle the client's ACK directly
-    handleClientAck(proxyConn, clientAddr, msgID, serverConn)
+    for range ticker.C {
+        cutoff := time.Now().Add(-p.idleTimeout).UnixNano()
+
+        var idle []*relay
+        p.mu.Lock()
+        for _, r := range p.relays {
+            if r.lastActive.Load() < cutoff {
+                idle = append(idle, r)
+            }
+        }
+        p.mu.Unlock()
+
+        for _, r := range idle {
+            p.closeRelay(r)
+        }
+    }
 }
 
-// Handle the client's ACK and forward it to the server's ephemeral port
-func handleClientAck(proxyConn *net.UDPConn, clientAddr *net.UDPAddr, msgID string, serverConn *net.UDPConn) {
-    ackBuffer := make([]byte, 4) // Expecting only the ACK ID
+func main() {
+    listenAddress := flag.String("listen", DefaultListenAddress, "address where the proxy listens")
+    upstreamAddress := flag.String("upstream", DefaultUpstreamAddress, "address of the server")
+    idleTimeout := flag.Duration("idle-timeout", DefaultIdleTimeout, "how long the relay of an idle client is kept")
+    maxClients := flag.Int("max-clients", DefaultMaxClients, "how many clients are relayed at once")
+    flag.Parse()
 
-    // Wait for the ACK
-    _, _, err := proxyConn.ReadFromUDP(ackBuffer)
+    upstreamAddr, err := net.ResolveUDPAddr("udp", *upstreamAddress)
     if err != nil {
-        fmt.Printf("Failed to read ACK from client: %v\n", err)
+        fmt.Printf("Failed to resolve server address %s: %v\n", *upstreamAddress, err)
         return
     }
-
-    // Forward the ACK to the server's ephemeral port
-    _, err = serverConn.Write(ackBuffer)
+    proxyAddr, err := net.ResolveUDPAddr("udp", *listenAddress)
     if err != nil {
-        fmt.Printf("Failed to forward ACK to server: %v\n", err)
+        fmt.Printf("Failed to resolve listen address %s: %v\n", *listenAddress, err)
+        return
     }
-}
 
-func main() {
-    // Set up the proxy to listen on port 2013
-    proxyAddr := net.UDPAddr{
-        Port: ProxyPort,
-        IP:   net.ParseIP("0.0.0.0"),
-    }
-    proxyConn, err := net.ListenUDP("udp", &proxyAddr)
+    proxyConn, err := net.ListenUDP("udp", proxyAddr)
     if err != nil {
-        fmt.Printf("Failed to listen on proxy port %d: %v\n", ProxyPort, err)
+        fmt.Printf("Failed to listen on %s: %v\n", *listenAddress, err)
         return
     }
     defer proxyConn.Close()
 
-    // Start the proxy loop
-    runProxyLoop(proxyConn)
+    fmt.Printf("Relaying clients at %s to the server at %s\n", proxyConn.LocalAddr().String(), upstreamAddr.String())
+    NewProxy(proxyConn, upstreamAddr, *idleTimeout, *maxClients).Run()
 }